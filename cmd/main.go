@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/okoye-dev/oss-archive/internal/config"
+	"github.com/okoye-dev/oss-archive/internal/database"
+	"github.com/okoye-dev/oss-archive/internal/repository"
 	"github.com/okoye-dev/oss-archive/internal/server"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/storage"
 )
 
 func main() {
@@ -14,9 +21,165 @@ func main() {
 		log.Fatalf("❌ Failed to load config: %v", err)
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("❌ Migration failed: %v", err)
+			}
+			return
+		case "verify":
+			if err := runVerify(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("❌ Verify failed: %v", err)
+			}
+			return
+		case "rotate-keys":
+			if err := runRotateKeys(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("❌ Key rotation failed: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("❌ Unknown command %q (available: migrate, verify, rotate-keys)", os.Args[1])
+		}
+	}
+
 	// Create and start server
 	srv := server.New(cfg)
 	if err := srv.Start(); err != nil {
 		log.Fatalf("❌ Server error: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// runMigrate handles `migrate up|down|status`
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		ran, err := database.MigrateUp(ctx, db)
+		for _, m := range ran {
+			log.Printf("✅ Applied %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			log.Println("Schema is up to date")
+		}
+
+	case "down":
+		m, err := database.MigrateDown(ctx, db)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			log.Println("No migrations to roll back")
+			return nil
+		}
+		log.Printf("↩️  Rolled back %04d_%s", m.Version, m.Name)
+
+	case "status":
+		statuses, err := database.Status(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-32s %s\n", status.Version, status.Name, state)
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down or status)", args[0])
+	}
+
+	return nil
+}
+
+// runVerify handles `verify`, re-reading every stored version and reporting
+// the ones that are missing or don't match their checksum
+func runVerify(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: verify")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := database.CheckSchema(ctx, db); err != nil {
+		return err
+	}
+	fileStorage, err := server.NewStorage(cfg, db)
+	if err != nil {
+		return err
+	}
+
+	// Verifying never changes anything, so no blobs or holds are needed
+	versions := services.NewVersionService(fileStorage, repository.NewFileRepository(db), nil, nil)
+	report, err := versions.Verify(ctx, func(problem services.ObjectProblem) {
+		fmt.Printf("%-10s file %s version %d (%s): %s\n",
+			problem.Problem, problem.Version.FileID, problem.Version.Version, problem.Version.StorageKey, problem.Detail)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Checked %d versions against their checksum and %d without one", report.Checked, report.Unchecked)
+	if report.Problems > 0 {
+		return fmt.Errorf("%d damaged or missing objects", report.Problems)
+	}
+	log.Println("✅ All objects intact")
+	return nil
+}
+
+// runRotateKeys handles `rotate-keys`, re-wrapping the data keys of
+// encrypted objects with the current storage.encryption_key. The objects
+// themselves aren't rewritten.
+func runRotateKeys(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: rotate-keys")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := database.CheckSchema(ctx, db); err != nil {
+		return err
+	}
+	fileStorage, err := server.NewStorage(cfg, db)
+	if err != nil {
+		return err
+	}
+	encrypted, ok := fileStorage.(*storage.EncryptedStorage)
+	if !ok {
+		return fmt.Errorf("storage encryption is not configured")
+	}
+
+	rotated, err := encrypted.RotateKeys(ctx)
+	log.Printf("Re-wrapped %d data keys", rotated)
+	if err != nil {
+		return err
+	}
+	log.Println("✅ Every data key is wrapped by the current master key; previous keys can be removed")
+	return nil
+}
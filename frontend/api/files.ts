@@ -1,4 +1,9 @@
 import { apiService, getApiBaseUrl } from "./api-service";
+import { useAuthStore } from "@/store/authStore";
+
+const authHeaders = (): Record<string, string> => ({
+  Authorization: `Bearer ${useAuthStore.getState().user?.access_token}`,
+});
 
 export interface FileData {
   id: string;
@@ -9,11 +14,24 @@ export interface FileData {
 
 interface FilesResponse {
   files: FileData[];
+  next_cursor?: string;
 }
 
+// Listings are paged; follow the cursors so large archives load completely
 export const getFiles = async (): Promise<FileData[]> => {
-  const response = await apiService.get<FilesResponse>("/files");
-  return response.files || [];
+  const files: FileData[] = [];
+  let cursor: string | undefined;
+
+  do {
+    const params = new URLSearchParams({ limit: "1000" });
+    if (cursor) params.set("cursor", cursor);
+
+    const response = await apiService.get<FilesResponse>(`/files?${params}`);
+    files.push(...(response.files || []));
+    cursor = response.next_cursor;
+  } while (cursor);
+
+  return files;
 };
 
 export const uploadFiles = async (
@@ -50,12 +68,241 @@ export const uploadFiles = async (
     });
     
     xhr.open('POST', `${getApiBaseUrl()}/files`);
+    xhr.setRequestHeader('Authorization', authHeaders().Authorization);
     xhr.send(formData);
   });
 };
 
-export const downloadFile = async (fileName: string): Promise<void> => {
-  const response = await fetch(`${getApiBaseUrl()}/files/${fileName}?download=true`);
+const TUS_VERSION = "1.0.0";
+const CHUNK_SIZE = 8 * 1024 * 1024;
+const MAX_RETRIES = 5;
+
+const tusHeaders = (): Record<string, string> => ({
+  ...authHeaders(),
+  "Tus-Resumable": TUS_VERSION,
+});
+
+// tus metadata values are base64, and btoa only takes latin1
+const encodeMetadata = (value: string) => btoa(unescape(encodeURIComponent(value)));
+
+const getUploadOffset = async (uploadUrl: string): Promise<number | null> => {
+  const response = await fetch(uploadUrl, { method: "HEAD", headers: tusHeaders() });
+  if (!response.ok) {
+    return null;
+  }
+  return Number(response.headers.get("Upload-Offset"));
+};
+
+const patchChunk = (
+  uploadUrl: string,
+  file: File,
+  offset: number,
+  onProgress?: (progress: number) => void
+): Promise<number> => {
+  return new Promise((resolve, reject) => {
+    const xhr = new XMLHttpRequest();
+
+    if (onProgress) {
+      xhr.upload.addEventListener('progress', (event) => {
+        onProgress(Math.round(((offset + event.loaded) / file.size) * 100));
+      });
+    }
+
+    xhr.addEventListener('load', () => {
+      if (xhr.status === 204) {
+        resolve(Number(xhr.getResponseHeader("Upload-Offset")));
+      } else {
+        reject(new Error(`Upload failed: ${xhr.statusText}`));
+      }
+    });
+
+    xhr.addEventListener('error', () => {
+      reject(new Error('Upload failed'));
+    });
+
+    xhr.open('PATCH', uploadUrl);
+    Object.entries(tusHeaders()).forEach(([name, value]) => xhr.setRequestHeader(name, value));
+    xhr.setRequestHeader("Upload-Offset", String(offset));
+    xhr.setRequestHeader("Content-Type", "application/offset+octet-stream");
+    xhr.send(file.slice(offset, offset + CHUNK_SIZE));
+  });
+};
+
+// uploadFileResumable sends a file over tus in chunks. The upload URL is kept
+// in localStorage, so an interrupted upload of the same file picks up from
+// the last byte the server stored, even after a page reload.
+export const uploadFileResumable = async (
+  file: File,
+  onProgress?: (progress: number) => void
+): Promise<void> => {
+  const fingerprint = `tus::${file.name}::${file.size}::${file.lastModified}`;
+  let uploadUrl = localStorage.getItem(fingerprint);
+  let offset = 0;
+
+  if (uploadUrl) {
+    const resumeOffset = await getUploadOffset(uploadUrl).catch(() => null);
+    if (resumeOffset === null) {
+      localStorage.removeItem(fingerprint);
+      uploadUrl = null;
+    } else {
+      offset = resumeOffset;
+    }
+  }
+
+  if (!uploadUrl) {
+    const response = await fetch(`${getApiBaseUrl()}/uploads`, {
+      method: "POST",
+      headers: {
+        ...tusHeaders(),
+        "Upload-Length": String(file.size),
+        "Upload-Metadata": `filename ${encodeMetadata(file.name)},filetype ${encodeMetadata(file.type || "application/octet-stream")}`,
+      },
+    });
+    uploadUrl = response.headers.get("Location");
+    if (response.status !== 201 || !uploadUrl) {
+      throw new Error(`Upload failed: ${response.statusText}`);
+    }
+    localStorage.setItem(fingerprint, uploadUrl);
+  }
+
+  let retries = 0;
+  while (offset < file.size) {
+    try {
+      offset = await patchChunk(uploadUrl, file, offset, onProgress);
+      retries = 0;
+    } catch (err) {
+      if (++retries > MAX_RETRIES) {
+        throw err;
+      }
+      await new Promise((resolve) => setTimeout(resolve, 1000 * retries));
+
+      // The server keeps whatever arrived, so ask where to carry on from
+      const resumeOffset = await getUploadOffset(uploadUrl).catch(() => null);
+      if (resumeOffset !== null) {
+        offset = resumeOffset;
+      }
+    }
+  }
+
+  localStorage.removeItem(fingerprint);
+  onProgress?.(100);
+};
+
+const PRESIGN_BATCH = 100;
+const PART_CONCURRENCY = 4;
+
+interface DirectUpload {
+  id: string;
+  size: number;
+  part_size: number;
+  part_count: number;
+}
+
+interface PresignedParts {
+  parts: { part_number: number; url: string }[];
+  expires_in: number;
+}
+
+const putPart = (url: string, blob: Blob, onLoaded: (loaded: number) => void): Promise<string> => {
+  return new Promise((resolve, reject) => {
+    const xhr = new XMLHttpRequest();
+
+    xhr.upload.addEventListener('progress', (event) => onLoaded(event.loaded));
+
+    xhr.addEventListener('load', () => {
+      // The bucket's CORS rules must expose ETag for this to be readable
+      const etag = xhr.getResponseHeader("ETag");
+      if (xhr.status >= 200 && xhr.status < 300 && etag) {
+        resolve(etag);
+      } else {
+        reject(new Error(`Part upload failed: ${xhr.statusText || "ETag not exposed"}`));
+      }
+    });
+
+    xhr.addEventListener('error', () => {
+      reject(new Error('Part upload failed'));
+    });
+
+    xhr.open('PUT', url);
+    xhr.send(blob);
+  });
+};
+
+// uploadFileDirect PUTs the file to the bucket in parts using presigned URLs,
+// so the bytes never pass through the API server. The server checks every
+// part's ETag and the final size before the file shows up in the catalog.
+export const uploadFileDirect = async (
+  file: File,
+  onProgress?: (progress: number) => void
+): Promise<FileData> => {
+  const upload = await apiService.post<DirectUpload>("/multipart-uploads", {
+    body: {
+      file_name: file.name,
+      content_type: file.type || "application/octet-stream",
+      size: file.size,
+    },
+  });
+
+  const loaded = new Map<number, number>();
+  const reportProgress = () => {
+    if (onProgress && file.size > 0) {
+      const total = Array.from(loaded.values()).reduce((sum, value) => sum + value, 0);
+      onProgress(Math.round((total / file.size) * 100));
+    }
+  };
+
+  try {
+    const completed: { part_number: number; etag: string }[] = [];
+
+    for (let first = 1; first <= upload.part_count; first += PRESIGN_BATCH) {
+      const last = Math.min(first + PRESIGN_BATCH - 1, upload.part_count);
+      const partNumbers = Array.from({ length: last - first + 1 }, (_, i) => first + i);
+      const { parts } = await apiService.post<PresignedParts>(`/multipart-uploads/${upload.id}/parts`, {
+        body: { part_numbers: partNumbers },
+      });
+
+      const queue = [...parts];
+      const worker = async () => {
+        for (let part = queue.shift(); part; part = queue.shift()) {
+          const start = (part.part_number - 1) * upload.part_size;
+          const blob = file.slice(start, start + upload.part_size);
+          const partNumber = part.part_number;
+
+          let retries = 0;
+          for (;;) {
+            try {
+              const etag = await putPart(part.url, blob, (bytes) => {
+                loaded.set(partNumber, bytes);
+                reportProgress();
+              });
+              completed.push({ part_number: partNumber, etag });
+              break;
+            } catch (err) {
+              if (++retries > MAX_RETRIES) {
+                throw err;
+              }
+              await new Promise((resolve) => setTimeout(resolve, 1000 * retries));
+            }
+          }
+        }
+      };
+
+      await Promise.all(Array.from({ length: PART_CONCURRENCY }, worker));
+    }
+
+    return await apiService.post<FileData>(`/multipart-uploads/${upload.id}/complete`, {
+      body: { parts: completed },
+    });
+  } catch (err) {
+    await apiService.delete(`/multipart-uploads/${upload.id}`).catch(() => undefined);
+    throw err;
+  }
+};
+
+export const downloadFile = async (fileId: string, fileName: string): Promise<void> => {
+  const response = await fetch(`${getApiBaseUrl()}/files/${fileId}?download=true`, {
+    headers: authHeaders(),
+  });
   
   if (!response.ok) {
     throw new Error(`Failed to get download URL: ${response.statusText}`);
@@ -67,15 +314,17 @@ export const downloadFile = async (fileName: string): Promise<void> => {
   // Use presigned URL directly for download
   const a = document.createElement('a');
   a.href = downloadUrl;
-  a.download = fileName.split('_').slice(1).join('_'); // Remove UUID prefix for filename
+  a.download = fileName;
   a.style.display = 'none';
   document.body.appendChild(a);
   a.click();
   document.body.removeChild(a);
 };
 
-export const getFileViewUrl = async (fileName: string): Promise<string> => {
-  const response = await fetch(`${getApiBaseUrl()}/files/${fileName}`);
+export const getFileViewUrl = async (fileId: string): Promise<string> => {
+  const response = await fetch(`${getApiBaseUrl()}/files/${fileId}`, {
+    headers: authHeaders(),
+  });
   
   if (!response.ok) {
     throw new Error(`Failed to get view URL: ${response.statusText}`);
@@ -112,7 +112,7 @@ const Home = () => {
                       <div className="flex items-center gap-2">
                         <span className="text-xs text-gray-400">{formatFileSize(file.size)}</span>
                         <Button
-                          onClick={() => downloadFile(file.storage_key)}
+                          onClick={() => downloadFile(file.id, file.name)}
                           variant="outline"
                           className="hover:bg-primary/10"
                         >
@@ -1,7 +1,11 @@
 import { useState, useEffect, useCallback } from "react";
-import { getFiles, uploadFiles, downloadFile, FileData } from "@/api/files";
+import { getFiles, uploadFileResumable, uploadFileDirect, downloadFile, FileData } from "@/api/files";
 import { useToast } from "./useToast";
 
+// Direct uploads skip the API server but need CORS on the bucket that allows
+// PUT and exposes the ETag header
+const uploadFile = process.env.NEXT_PUBLIC_DIRECT_UPLOADS === "true" ? uploadFileDirect : uploadFileResumable;
+
 export const useFiles = () => {
   const [files, setFiles] = useState<FileData[]>([]);
   const [loading, setLoading] = useState(false);
@@ -27,13 +31,12 @@ export const useFiles = () => {
     fetchFiles();
   }, []);
 
-  const uploadMultipleFiles = useCallback(async (fileList: File[]): Promise<FileData[]> => {
+  const uploadMultipleFiles = useCallback(async (fileList: File[]): Promise<void> => {
     let isUploading = true;
     
     try {
       setUploading(true);
       setUploadProgress(0);
-      const uploadedFiles: FileData[] = [];
       
       // Show initial toast (non-dismissible during upload)
       const progressToast = toast({
@@ -50,10 +53,8 @@ export const useFiles = () => {
       
       for (let i = 0; i < fileList.length; i++) {
         const file = fileList[i];
-        const formData = new FormData();
-        formData.append("file", file);
         
-        const uploadedFile = await uploadFiles(formData, (progress) => {
+        await uploadFile(file, (progress) => {
           const fileProgress = (i / fileList.length) * 100 + (progress / fileList.length);
           const totalProgress = Math.round(fileProgress);
           setUploadProgress(totalProgress);
@@ -65,7 +66,6 @@ export const useFiles = () => {
             description: `${totalProgress}% complete`,
           });
         });
-        uploadedFiles.push(uploadedFile);
       }
 
       // Mark upload as complete and show success toast
@@ -78,7 +78,6 @@ export const useFiles = () => {
       });
 
       await fetchFiles();
-      return uploadedFiles;
     } catch (err) {
       console.error("Failed to upload files:", err);
       isUploading = false;
@@ -87,16 +86,15 @@ export const useFiles = () => {
         description: "Failed to upload files. Please try again.",
         variant: "destructive",
       });
-      return [];
     } finally {
       setUploading(false);
       setUploadProgress(0);
     }
   }, [fetchFiles, toast]);
 
-  const handleDownload = useCallback(async (fileName: string) => {
+  const handleDownload = useCallback(async (fileId: string, fileName: string) => {
     try {
-      await downloadFile(fileName);
+      await downloadFile(fileId, fileName);
       toast({
         title: "Download started",
         description: "File download has been initiated",
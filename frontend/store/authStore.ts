@@ -148,7 +148,7 @@ export const useAuthStore = create<AuthState>()(
 
         try {
           const baseUrl = getApiBaseUrl();
-          const response = await fetch(`${baseUrl}/v1/auth/refresh`, {
+          const response = await fetch(`${baseUrl}/auth/refresh`, {
             method: "POST",
             headers: {
               "Content-Type": "application/json",
@@ -165,7 +165,7 @@ export const useAuthStore = create<AuthState>()(
             ...user,
             access_token: data.access_token || data.accessToken,
             refresh_token: data.refresh_token || data.refreshToken || user.refresh_token,
-            token_expiry: data.token_expiry || data.expiresIn,
+            token_expiry: data.expires_in || data.expiresIn,
           };
 
           set({
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordLength is the most bytes of a password bcrypt takes into account
+const MaxPasswordLength = 72
+
+// HashPassword returns a bcrypt hash of the password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewOpaqueToken returns a random URL-safe token with 256 bits of entropy
+func NewOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+const shortTokenAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewShortToken returns a random token of the given length that is easy to
+// read aloud and paste into a URL (no 0/O or 1/l/I)
+func NewShortToken(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	// 256 is not a multiple of the alphabet size; reject the biased tail
+	limit := byte(256 - 256%len(shortTokenAlphabet))
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		for _, b := range buf {
+			if b < limit && len(out) < length {
+				out = append(out, shortTokenAlphabet[int(b)%len(shortTokenAlphabet)])
+			}
+		}
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate token: %w", err)
+		}
+	}
+
+	return string(out), nil
+}
+
+// HashOpaqueToken returns the SHA-256 of a token for storage. Opaque tokens
+// are high-entropy, so a fast hash is enough to keep them useless if leaked.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// Principal is the authenticated caller behind a request
+type Principal struct {
+	UserID   string
+	Username string
+	Role     models.Role
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal attached by the auth middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned for access tokens that are malformed, expired or badly signed
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the JWT claims carried by an access token
+type Claims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// TokenManager signs and verifies HS256 access tokens
+type TokenManager struct {
+	secret []byte
+	issuer string
+	ttl    time.Duration
+}
+
+func NewTokenManager(secret, issuer string, ttl time.Duration) (*TokenManager, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt secret must be at least 32 characters")
+	}
+
+	return &TokenManager{
+		secret: []byte(secret),
+		issuer: issuer,
+		ttl:    ttl,
+	}, nil
+}
+
+// TTL is how long issued access tokens stay valid
+func (m *TokenManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue signs a new access token for the given user
+func (m *TokenManager) Issue(userID, username string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Issuer:    m.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// Parse verifies an access token and returns its claims
+func (m *TokenManager) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return m.secret, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,11 +13,16 @@ type Config struct {
 	Database DatabaseConfig `yaml:"database"`
 	Server   ServerConfig   `yaml:"server"`
 	Logging  LoggingConfig  `yaml:"logging"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Trash    TrashConfig    `yaml:"trash"`
 	S3       S3Config       `yaml:"s3"`
+	Auth     AuthConfig     `yaml:"auth"`
 }
 
 // DatabaseConfig holds database connection settings
 type DatabaseConfig struct {
+	Driver   string `yaml:"driver"` // postgres, sqlite
+	Path     string `yaml:"path"`   // database file, sqlite only
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	User     string `yaml:"user"`
@@ -27,11 +33,12 @@ type DatabaseConfig struct {
 
 // ServerConfig holds server settings
 type ServerConfig struct {
-	Port            int `yaml:"port"`
-	ReadTimeout     int `yaml:"read_timeout"`     // in seconds
-	WriteTimeout    int `yaml:"write_timeout"`    // in seconds
-	IdleTimeout     int `yaml:"idle_timeout"`     // in seconds
-	ShutdownTimeout int `yaml:"shutdown_timeout"` // in seconds
+	Port            int    `yaml:"port"`
+	ReadTimeout     int    `yaml:"read_timeout"`     // in seconds
+	WriteTimeout    int    `yaml:"write_timeout"`    // in seconds
+	IdleTimeout     int    `yaml:"idle_timeout"`     // in seconds
+	ShutdownTimeout int    `yaml:"shutdown_timeout"` // in seconds
+	PublicURL       string `yaml:"public_url"`       // base for share links, e.g. https://archive.example.com
 }
 
 // LoggingConfig holds logging settings
@@ -40,6 +47,23 @@ type LoggingConfig struct {
 	Mode  string `yaml:"mode"`  // gin mode: debug, release, test
 }
 
+// StorageConfig selects where file contents are kept
+type StorageConfig struct {
+	Backend                string   `yaml:"backend"`                  // s3, local
+	Path                   string   `yaml:"path"`                     // root directory, local only
+	SigningKey             string   `yaml:"signing_key"`              // signs download URLs, local only; derived from the JWT secret when empty
+	EncryptionKey          string   `yaml:"encryption_key"`           // base64 of 32 bytes; objects are encrypted at rest when set
+	PreviousEncryptionKeys []string `yaml:"previous_encryption_keys"` // still unwrap data keys until they are rotated
+	PresignExpiry          int      `yaml:"presign_expiry"`           // in seconds; lifetime of download URLs
+	MaxPresignExpiry       int      `yaml:"max_presign_expiry"`       // in seconds; the most a request can ask for
+}
+
+// TrashConfig controls how long deleted files can still be restored
+type TrashConfig struct {
+	Retention     int `yaml:"retention"`      // in seconds
+	PurgeInterval int `yaml:"purge_interval"` // in seconds
+}
+
 // S3Config holds S3-compatible storage settings
 type S3Config struct {
 	Endpoint        string `yaml:"endpoint"`
@@ -49,6 +73,15 @@ type S3Config struct {
 	UseSSL          bool   `yaml:"use_ssl"`
 	BucketName      string `yaml:"bucket_name"`
 	ForcePathStyle  bool   `yaml:"force_path_style"`
+	ObjectLockMode  string `yaml:"object_lock_mode"` // GOVERNANCE or COMPLIANCE, for buckets with Object Lock
+}
+
+// AuthConfig holds token signing settings
+type AuthConfig struct {
+	JWTSecret       string `yaml:"jwt_secret"`
+	Issuer          string `yaml:"issuer"`
+	AccessTokenTTL  int    `yaml:"access_token_ttl"`  // in seconds
+	RefreshTokenTTL int    `yaml:"refresh_token_ttl"` // in seconds
 }
 
 // LoadConfig reads and parses the configuration file
@@ -78,6 +111,7 @@ func loadFromEnv() *Config {
 
 	// Log environment variables for debugging
 	fmt.Printf("🔧 Loading configuration from environment:\n")
+	fmt.Printf("   STORAGE_BACKEND: %s\n", os.Getenv("STORAGE_BACKEND"))
 	fmt.Printf("   S3_ENDPOINT: %s\n", os.Getenv("S3_ENDPOINT"))
 	fmt.Printf("   S3_REGION: %s\n", os.Getenv("S3_REGION"))
 	fmt.Printf("   S3_ACCESS_KEY_ID: %s\n", maskString(os.Getenv("S3_ACCESS_KEY_ID")))
@@ -85,28 +119,50 @@ func loadFromEnv() *Config {
 	fmt.Printf("   S3_USE_SSL: %s\n", os.Getenv("S3_USE_SSL"))
 	fmt.Printf("   S3_BUCKET_NAME: %s\n", os.Getenv("S3_BUCKET_NAME"))
 	fmt.Printf("   S3_FORCE_PATH_STYLE: %s\n", os.Getenv("S3_FORCE_PATH_STYLE"))
+	fmt.Printf("   DB_DRIVER: %s\n", os.Getenv("DB_DRIVER"))
+	fmt.Printf("   DB_HOST: %s\n", os.Getenv("DB_HOST"))
+	fmt.Printf("   DB_NAME: %s\n", os.Getenv("DB_NAME"))
+	fmt.Printf("   JWT_SECRET: %s\n", maskString(os.Getenv("JWT_SECRET")))
 	fmt.Printf("   PORT: %s\n", os.Getenv("PORT"))
 
 	config := &Config{
-		// Database: DatabaseConfig{
-		// 	Host:     getEnv("DB_HOST", "localhost"),
-		// 	Port:     getEnvInt("DB_PORT", 5432),
-		// 	User:     getEnv("DB_USER", "postgres"),
-		// 	Password: getEnv("DB_PASSWORD", ""),
-		// 	DBName:   getEnv("DB_NAME", "oss-archive"),
-		// 	SSLMode:  getEnv("DB_SSLMODE", "require"),
-		// },
+		Database: DatabaseConfig{
+			Driver:   getEnv("DB_DRIVER", "postgres"),
+			Path:     getEnv("DB_PATH", "data/oss-archive.db"),
+			Host:     getEnv("DB_HOST", "localhost"),
+			Port:     getEnvInt("DB_PORT", 5432),
+			User:     getEnv("DB_USER", "postgres"),
+			Password: getEnv("DB_PASSWORD", ""),
+			DBName:   getEnv("DB_NAME", "oss-archive"),
+			SSLMode:  getEnv("DB_SSLMODE", "require"),
+		},
 		Server: ServerConfig{
 			Port:            getEnvInt("PORT", 6060),
 			ReadTimeout:     getEnvInt("READ_TIMEOUT", 30),
 			WriteTimeout:    getEnvInt("WRITE_TIMEOUT", 30),
 			IdleTimeout:     getEnvInt("IDLE_TIMEOUT", 120),
 			ShutdownTimeout: getEnvInt("SHUTDOWN_TIMEOUT", 5),
+			PublicURL:       getEnv("PUBLIC_URL", ""),
 		},
 		Logging: LoggingConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
 			Mode:  getEnv("GIN_MODE", "release"),
 		},
+		Storage: StorageConfig{
+			Backend:    getEnv("STORAGE_BACKEND", "s3"),
+			Path:       getEnv("STORAGE_PATH", "data/storage"),
+			SigningKey: getEnv("STORAGE_SIGNING_KEY", ""),
+
+			EncryptionKey:          getEnv("STORAGE_ENCRYPTION_KEY", ""),
+			PreviousEncryptionKeys: getEnvList("STORAGE_PREVIOUS_ENCRYPTION_KEYS"),
+
+			PresignExpiry:    getEnvInt("STORAGE_PRESIGN_EXPIRY", 3600),
+			MaxPresignExpiry: getEnvInt("STORAGE_MAX_PRESIGN_EXPIRY", 7*24*3600),
+		},
+		Trash: TrashConfig{
+			Retention:     getEnvInt("TRASH_RETENTION", 30*24*3600),
+			PurgeInterval: getEnvInt("TRASH_PURGE_INTERVAL", 3600),
+		},
 		S3: S3Config{
 			Endpoint:        getEnv("S3_ENDPOINT", ""),
 			Region:          getEnv("S3_REGION", "us-east-1"),
@@ -115,6 +171,13 @@ func loadFromEnv() *Config {
 			UseSSL:          getEnvBool("S3_USE_SSL", true),
 			BucketName:      getEnv("S3_BUCKET_NAME", "oss-archive"),
 			ForcePathStyle:  getEnvBool("S3_FORCE_PATH_STYLE", false),
+			ObjectLockMode:  getEnv("S3_OBJECT_LOCK_MODE", "GOVERNANCE"),
+		},
+		Auth: AuthConfig{
+			JWTSecret:       getEnv("JWT_SECRET", ""),
+			Issuer:          getEnv("JWT_ISSUER", "oss-archive"),
+			AccessTokenTTL:  getEnvInt("ACCESS_TOKEN_TTL", 900),
+			RefreshTokenTTL: getEnvInt("REFRESH_TOKEN_TTL", 30*24*3600),
 		},
 	}
 
@@ -137,6 +200,17 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvList splits a comma-separated variable, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
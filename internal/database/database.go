@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/okoye-dev/oss-archive/internal/config"
+	_ "modernc.org/sqlite"
+)
+
+// Supported catalog drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// Open connects to the catalog database selected by database.driver.
+// Schema changes are applied separately through MigrateUp.
+func Open(cfg *config.Config) (*sql.DB, error) {
+	db, err := connect(&cfg.Database, cfg.GetDatabaseConnectionString())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return db, nil
+}
+
+func connect(cfg *config.DatabaseConfig, connString string) (*sql.DB, error) {
+	switch cfg.Driver {
+	case "", DriverPostgres:
+		db, err := sql.Open("pgx", connString)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		return db, nil
+
+	case DriverSQLite:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("database.path is required for the sqlite driver")
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+
+		dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", cfg.Path)
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		// SQLite allows a single writer; serialise access instead of retrying on SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+		return db, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
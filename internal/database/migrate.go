@@ -0,0 +1,312 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaOutdated is returned when the database is behind the migrations built into the binary
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// Migration is one versioned schema change, loaded from
+// migrations/NNNN_name.{up,down}.sql. A change the drivers can't share is
+// written once per driver instead, as NNNN_name.<driver>.{up,down}.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// DriverUp and DriverDown hold the per-driver scripts, by driver name
+	DriverUp   map[string]string
+	DriverDown map[string]string
+}
+
+// up returns the script that applies m on driver
+func (m Migration) up(driver string) string {
+	if script, ok := m.DriverUp[driver]; ok {
+		return script
+	}
+	return m.Up
+}
+
+// down returns the script that rolls m back on driver
+func (m Migration) down(driver string) string {
+	if script, ok := m.DriverDown[driver]; ok {
+		return script
+	}
+	return m.Down
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+const migrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		var driver string
+		for _, known := range drivers {
+			if strings.HasSuffix(base, "."+known) {
+				base, driver = strings.TrimSuffix(base, "."+known), known
+			}
+		}
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name", fileName)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", fileName, err)
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, name)
+		}
+
+		switch {
+		case driver == "" && direction == "up":
+			m.Up = string(body)
+		case driver == "":
+			m.Down = string(body)
+		case direction == "up":
+			if m.DriverUp == nil {
+				m.DriverUp = make(map[string]string)
+			}
+			m.DriverUp[driver] = string(body)
+		default:
+			if m.DriverDown == nil {
+				m.DriverDown = make(map[string]string)
+			}
+			m.DriverDown[driver] = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		for _, driver := range drivers {
+			if m.up(driver) == "" {
+				return nil, fmt.Errorf("migration %04d_%s has no up script for %s", m.Version, m.Name, driver)
+			}
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status lists every embedded migration alongside whether it has been applied
+func Status(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it ran
+func MigrateUp(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	statuses, err := Status(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := driverName(db)
+	var ran []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, status.up(driver)); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				status.Version, status.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("failed to apply migration %04d_%s: %w", status.Version, status.Name, err)
+		}
+
+		ran = append(ran, status.Migration)
+	}
+
+	return ran, nil
+}
+
+// MigrateDown rolls back the most recently applied migration.
+// It returns nil when there is nothing to roll back.
+func MigrateDown(ctx context.Context, db *sql.DB) (*Migration, error) {
+	statuses, err := Status(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := driverName(db)
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if !status.Applied {
+			continue
+		}
+		down := status.down(driver)
+		if down == "" {
+			return nil, fmt.Errorf("migration %04d_%s cannot be rolled back", status.Version, status.Name)
+		}
+
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, status.Version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to roll back migration %04d_%s: %w", status.Version, status.Name, err)
+		}
+
+		return &status.Migration, nil
+	}
+
+	return nil, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated when any embedded migration has not been applied
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	statuses, err := Status(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending migrations %s (run `migrate up`)", ErrSchemaOutdated, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	if _, err := db.ExecContext(ctx, migrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// drivers are the names migrations can be written for separately
+var drivers = []string{DriverPostgres, DriverSQLite}
+
+// driverName tells which of the supported drivers db was opened with
+func driverName(db *sql.DB) string {
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		return DriverSQLite
+	}
+	return DriverPostgres
+}
+
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/config"
+)
+
+// openTestDB opens an empty SQLite database that goes away with the test
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := Open(&config.Config{
+		Database: config.DatabaseConfig{
+			Driver: DriverSQLite,
+			Path:   filepath.Join(t.TempDir(), "catalog.db"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// migrateDownTo rolls migrations back until version is the latest applied
+func migrateDownTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+
+	for {
+		statuses, err := Status(context.Background(), db)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		latest := 0
+		for _, status := range statuses {
+			if status.Applied {
+				latest = status.Version
+			}
+		}
+		if latest <= version {
+			return
+		}
+		if _, err := MigrateDown(context.Background(), db); err != nil {
+			t.Fatalf("migrate down from %d: %v", latest, err)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is numbered %04d, want %04d", i, m.Version, i+1)
+		}
+		for _, driver := range drivers {
+			if m.up(driver) == "" || m.down(driver) == "" {
+				t.Errorf("migration %04d_%s can't be applied and rolled back on %s", m.Version, m.Name, driver)
+			}
+		}
+	}
+
+	// 0014 is written once per driver; the rest share their scripts
+	rebuild := migrations[13]
+	if rebuild.Name != "drop_unique_storage_key" {
+		t.Fatalf("migration 14 is %s", rebuild.Name)
+	}
+	if !strings.Contains(rebuild.up(DriverSQLite), "files_rebuilt") || strings.Contains(rebuild.up(DriverPostgres), "files_rebuilt") {
+		t.Errorf("0014 scripts aren't chosen by driver")
+	}
+	if shared := migrations[0]; shared.up(DriverSQLite) != shared.Up || shared.up(DriverPostgres) != shared.Up {
+		t.Errorf("0001 should use its shared script on every driver")
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	if err := CheckSchema(ctx, db); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("CheckSchema on an empty database = %v", err)
+	}
+
+	for round := range 2 {
+		ran, err := MigrateUp(ctx, db)
+		if err != nil || len(ran) != len(migrations) {
+			t.Fatalf("round %d: migrate up ran %d of %d: %v", round, len(ran), len(migrations), err)
+		}
+		if err := CheckSchema(ctx, db); err != nil {
+			t.Fatalf("round %d: CheckSchema after migrating up: %v", round, err)
+		}
+		if ran, err := MigrateUp(ctx, db); err != nil || len(ran) != 0 {
+			t.Errorf("round %d: migrating up again ran %d: %v", round, len(ran), err)
+		}
+
+		// A schema one migration behind the binary is turned away
+		latest := migrations[len(migrations)-1]
+		rolledBack, err := MigrateDown(ctx, db)
+		if err != nil || rolledBack == nil || rolledBack.Version != latest.Version {
+			t.Fatalf("round %d: migrate down = %+v, %v", round, rolledBack, err)
+		}
+		err = CheckSchema(ctx, db)
+		if !errors.Is(err, ErrSchemaOutdated) || !strings.Contains(err.Error(), latest.Name) {
+			t.Errorf("round %d: CheckSchema one behind = %v", round, err)
+		}
+
+		migrateDownTo(t, db, 0)
+		statuses, err := Status(ctx, db)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				t.Errorf("round %d: %04d_%s still applied after rolling everything back", round, status.Version, status.Name)
+			}
+		}
+		if rolledBack, err := MigrateDown(ctx, db); err != nil || rolledBack != nil {
+			t.Errorf("round %d: rolling back with nothing applied = %+v, %v", round, rolledBack, err)
+		}
+	}
+}
+
+// TestStorageKeyRebuild checks the rows referencing files outlive SQLite
+// rebuilding the table in 0014, in both directions
+func TestStorageKeyRebuild(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	migrateDownTo(t, db, 13)
+
+	now := time.Now().UTC()
+	for _, seed := range []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO users (id, username, password_hash, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)`,
+			[]any{"u1", "alice", "hash", "admin", now}},
+		{`INSERT INTO users (id, username, password_hash, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)`,
+			[]any{"u2", "bob", "hash", "member", now}},
+		{`INSERT INTO files (id, file_name, storage_key, owner_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)`,
+			[]any{"f1", "a.txt", "users/u1/f1/a.txt", "u1", now}},
+		{`INSERT INTO file_versions (file_id, version, storage_key, file_size, content_type, uploaded_by, created_at) VALUES ($1, 1, $2, 0, $3, $4, $5)`,
+			[]any{"f1", "users/u1/f1/a.txt", "text/plain", "u1", now}},
+		{`INSERT INTO file_grants (file_id, user_id, created_at) VALUES ($1, $2, $3)`,
+			[]any{"f1", "u2", now}},
+		{`INSERT INTO share_links (id, file_id, created_by, token_hash, created_at) VALUES ($1, $2, $3, $4, $5)`,
+			[]any{"s1", "f1", "u1", "token-hash", now}},
+	} {
+		if _, err := db.ExecContext(ctx, seed.query, seed.args...); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	kept := func(stage string) {
+		t.Helper()
+		for _, table := range []string{"files", "file_versions", "file_grants", "share_links"} {
+			var count int
+			if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table).Scan(&count); err != nil {
+				t.Fatalf("%s: count %s: %v", stage, table, err)
+			}
+			if count != 1 {
+				t.Errorf("%s: %s has %d rows, want 1", stage, table, count)
+			}
+		}
+	}
+
+	if _, err := MigrateUp(ctx, db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	kept("after 0014 up")
+
+	// Files may now share a storage key, which 0014 can't undo
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO files (id, file_name, storage_key, owner_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)`,
+		"f2", "b.txt", "users/u1/f1/a.txt", "u1", now); err != nil {
+		t.Fatalf("file sharing a storage key: %v", err)
+	}
+	migrateDownTo(t, db, 14)
+	if _, err := MigrateDown(ctx, db); err == nil {
+		t.Fatal("0014 rolled back while files share a storage key")
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, "f2"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	migrateDownTo(t, db, 13)
+	kept("after 0014 down")
+}
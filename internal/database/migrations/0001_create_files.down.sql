@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_files_owner_id;
+DROP TABLE IF EXISTS files;
@@ -0,0 +1,13 @@
+-- File catalog: one row per object stored in the bucket
+CREATE TABLE IF NOT EXISTS files (
+    id           TEXT PRIMARY KEY,
+    file_name    TEXT NOT NULL,
+    storage_key  TEXT NOT NULL UNIQUE,
+    file_size    BIGINT NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT 'application/octet-stream',
+    owner_id     TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_owner_id ON files (owner_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_file_grants_user_id;
+DROP TABLE IF EXISTS file_grants;
@@ -0,0 +1,9 @@
+-- Read access to a file for users other than its owner
+CREATE TABLE IF NOT EXISTS file_grants (
+    file_id    TEXT NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (file_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_grants_user_id ON file_grants (user_id);
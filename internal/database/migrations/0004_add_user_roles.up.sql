@@ -0,0 +1,6 @@
+-- admin, member or viewer; existing accounts keep full control of their files
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+
+-- Make sure the oldest account can administer the instance
+UPDATE users SET role = 'admin'
+WHERE id = (SELECT id FROM users ORDER BY created_at LIMIT 1);
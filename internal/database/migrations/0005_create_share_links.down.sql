@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_share_links_file_id;
+DROP TABLE IF EXISTS share_links;
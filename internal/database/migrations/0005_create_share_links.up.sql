@@ -0,0 +1,15 @@
+-- Public links to a single file. Only a hash of the token is kept.
+CREATE TABLE IF NOT EXISTS share_links (
+    id             TEXT PRIMARY KEY,
+    file_id        TEXT NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    created_by     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash     TEXT NOT NULL UNIQUE,
+    password_hash  TEXT,
+    expires_at     TIMESTAMP,
+    max_downloads  INTEGER,
+    download_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at     TIMESTAMP,
+    created_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_file_id ON share_links (file_id);
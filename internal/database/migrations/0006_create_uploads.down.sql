@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS upload_parts;
+DROP INDEX IF EXISTS idx_uploads_owner_id;
+DROP TABLE IF EXISTS uploads;
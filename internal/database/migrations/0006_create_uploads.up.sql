@@ -0,0 +1,26 @@
+-- Resumable uploads in progress. The id becomes the file id once the upload
+-- completes and the row is removed.
+CREATE TABLE IF NOT EXISTS uploads (
+    id            TEXT PRIMARY KEY,
+    owner_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    file_name     TEXT NOT NULL,
+    content_type  TEXT NOT NULL,
+    storage_key   TEXT NOT NULL UNIQUE,
+    multipart_id  TEXT,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    part_size     BIGINT NOT NULL,
+    created_at    TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_owner_id ON uploads (owner_id);
+
+-- Parts already stored in the upload's multipart upload
+CREATE TABLE IF NOT EXISTS upload_parts (
+    upload_id   TEXT NOT NULL REFERENCES uploads (id) ON DELETE CASCADE,
+    part_number INTEGER NOT NULL,
+    etag        TEXT NOT NULL,
+    size        BIGINT NOT NULL,
+    PRIMARY KEY (upload_id, part_number)
+);
@@ -0,0 +1,2 @@
+-- tus uploads go through the server; direct uploads send parts straight to the bucket
+ALTER TABLE uploads ADD COLUMN mode TEXT NOT NULL DEFAULT 'tus';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_files_folder_id;
+ALTER TABLE files DROP COLUMN folder_id;
+DROP INDEX IF EXISTS idx_folders_sibling_name;
+DROP INDEX IF EXISTS idx_folders_parent_id;
+DROP TABLE IF EXISTS folders;
@@ -0,0 +1,20 @@
+-- Folders form a tree per owner; a NULL parent_id is the owner's root
+CREATE TABLE IF NOT EXISTS folders (
+    id         TEXT PRIMARY KEY,
+    owner_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    parent_id  TEXT REFERENCES folders (id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_folders_parent_id ON folders (parent_id);
+
+-- Sibling names are unique, including at the root where parent_id is NULL
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_sibling_name ON folders (owner_id, COALESCE(parent_id, ''), name);
+
+-- Files with a NULL folder_id sit at their owner's root. There is no foreign
+-- key so SQLite can drop the column again.
+ALTER TABLE files ADD COLUMN folder_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_files_folder_id ON files (folder_id);
@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN version;
+DROP TABLE IF EXISTS file_versions;
@@ -0,0 +1,21 @@
+-- Every revision of a file's content. The files row mirrors the current one.
+-- storage_version_id is set when the bucket keeps the versions itself; other
+-- versions each have their own storage_key.
+CREATE TABLE IF NOT EXISTS file_versions (
+    file_id            TEXT NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    version            INTEGER NOT NULL,
+    storage_key        TEXT NOT NULL,
+    storage_version_id TEXT,
+    file_size          BIGINT NOT NULL,
+    content_type       TEXT NOT NULL,
+    uploaded_by        TEXT REFERENCES users (id) ON DELETE SET NULL,
+    created_at         TIMESTAMP NOT NULL,
+    PRIMARY KEY (file_id, version)
+);
+
+ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Existing files start their history at version 1, uploaded by their owner
+INSERT INTO file_versions (file_id, version, storage_key, file_size, content_type, uploaded_by, created_at)
+SELECT f.id, 1, f.storage_key, f.file_size, f.content_type, u.id, f.created_at
+FROM files f LEFT JOIN users u ON u.id = f.owner_id;
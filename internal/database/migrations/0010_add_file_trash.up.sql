@@ -0,0 +1,5 @@
+-- Deleted files wait in their owner's trash, objects and all, until they are
+-- restored or the purger removes them once their retention has run out
+ALTER TABLE files ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
@@ -0,0 +1,5 @@
+ALTER TABLE folders DROP COLUMN legal_hold;
+ALTER TABLE folders DROP COLUMN retain_until;
+
+ALTER TABLE files DROP COLUMN legal_hold;
+ALTER TABLE files DROP COLUMN retain_until;
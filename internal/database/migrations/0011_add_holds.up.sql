@@ -0,0 +1,7 @@
+-- Admins can keep files, or everything in a folder, from being deleted or
+-- overwritten until retain_until has passed and while legal_hold is set
+ALTER TABLE files ADD COLUMN retain_until TIMESTAMP;
+ALTER TABLE files ADD COLUMN legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE folders ADD COLUMN retain_until TIMESTAMP;
+ALTER TABLE folders ADD COLUMN legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
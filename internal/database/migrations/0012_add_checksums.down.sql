@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN sha256;
+ALTER TABLE file_versions DROP COLUMN sha256;
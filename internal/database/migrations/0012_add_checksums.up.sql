@@ -0,0 +1,5 @@
+-- The hex SHA-256 of each version's content, taken as it was uploaded. The
+-- files row mirrors the current version's. Empty for content the server
+-- never saw whole, such as direct uploads.
+ALTER TABLE file_versions ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_file_versions_storage_key;
+DROP INDEX IF EXISTS idx_blobs_ref_count;
+DROP TABLE IF EXISTS blobs;
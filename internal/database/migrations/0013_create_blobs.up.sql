@@ -0,0 +1,15 @@
+-- Uploads whose checksum is known are stored once per distinct content,
+-- under a key derived from the checksum. ref_count is how many file
+-- versions use the blob; it is taken when the blob is stored for a version
+-- and given back when the file is deleted, and the object is removed once
+-- it reaches zero.
+CREATE TABLE IF NOT EXISTS blobs (
+    sha256      TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL UNIQUE,
+    file_size   BIGINT NOT NULL,
+    ref_count   INTEGER NOT NULL DEFAULT 0,
+    created_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_blobs_ref_count ON blobs (ref_count);
+CREATE INDEX IF NOT EXISTS idx_file_versions_storage_key ON file_versions (storage_key);
@@ -0,0 +1,4 @@
+-- Fails while any files still share a blob
+DROP INDEX IF EXISTS idx_files_storage_key;
+
+ALTER TABLE files ADD CONSTRAINT files_storage_key_key UNIQUE (storage_key);
@@ -0,0 +1,4 @@
+-- Files with the same content now share a blob, and so a storage key
+ALTER TABLE files DROP CONSTRAINT IF EXISTS files_storage_key_key;
+
+CREATE INDEX IF NOT EXISTS idx_files_storage_key ON files (storage_key);
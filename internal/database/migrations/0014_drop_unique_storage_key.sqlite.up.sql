@@ -0,0 +1,41 @@
+-- Files with the same content now share a blob, and so a storage key.
+-- SQLite can only drop the constraint by rebuilding the table, and dropping
+-- the old one deletes the rows that reference it, so those are set aside
+-- and put back.
+CREATE TABLE files_rebuilt (
+    id           TEXT PRIMARY KEY,
+    file_name    TEXT NOT NULL,
+    storage_key  TEXT NOT NULL,
+    file_size    BIGINT NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT 'application/octet-stream',
+    owner_id     TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL,
+    folder_id    TEXT,
+    version      INTEGER NOT NULL DEFAULT 1,
+    deleted_at   TIMESTAMP,
+    retain_until TIMESTAMP,
+    legal_hold   BOOLEAN NOT NULL DEFAULT FALSE,
+    sha256       TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO files_rebuilt SELECT id, file_name, storage_key, file_size, content_type, owner_id, created_at, updated_at,
+    folder_id, version, deleted_at, retain_until, legal_hold, sha256 FROM files;
+
+CREATE TEMP TABLE kept_file_grants AS SELECT * FROM file_grants;
+CREATE TEMP TABLE kept_share_links AS SELECT * FROM share_links;
+CREATE TEMP TABLE kept_file_versions AS SELECT * FROM file_versions;
+
+DROP TABLE files;
+ALTER TABLE files_rebuilt RENAME TO files;
+
+INSERT INTO file_grants SELECT * FROM kept_file_grants;
+INSERT INTO share_links SELECT * FROM kept_share_links;
+INSERT INTO file_versions SELECT * FROM kept_file_versions;
+DROP TABLE kept_file_grants;
+DROP TABLE kept_share_links;
+DROP TABLE kept_file_versions;
+
+CREATE INDEX IF NOT EXISTS idx_files_owner_id ON files (owner_id);
+CREATE INDEX IF NOT EXISTS idx_files_folder_id ON files (folder_id);
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_files_storage_key ON files (storage_key);
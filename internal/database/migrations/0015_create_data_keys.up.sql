@@ -0,0 +1,12 @@
+-- Objects encrypted at rest are sealed with a key of their own, stored here
+-- wrapped by the master key named by master_key_id. Rotating the master key
+-- re-wraps these rows and leaves the objects alone. size is the plaintext
+-- size, since the object in the bucket is larger.
+CREATE TABLE IF NOT EXISTS data_keys (
+    storage_key   TEXT PRIMARY KEY,
+    wrapped_key   TEXT NOT NULL,
+    master_key_id TEXT NOT NULL,
+    size          BIGINT NOT NULL DEFAULT 0,
+    created_at    TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP NOT NULL
+);
@@ -0,0 +1,4 @@
+-- tus uploads are hashed as their bytes arrive, so the finished file gets a
+-- checksum without being read back. hash_state is the base64 of the SHA-256
+-- state after the first upload_offset bytes; it is saved with the offset.
+ALTER TABLE uploads ADD COLUMN hash_state TEXT NOT NULL DEFAULT '';
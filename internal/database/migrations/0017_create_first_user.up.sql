@@ -0,0 +1,12 @@
+-- Signup makes the first account an admin. Claiming this single row in the
+-- same transaction as inserting the account means two signups racing on an
+-- empty instance can't both become admins.
+CREATE TABLE IF NOT EXISTS first_user (
+    id         INTEGER PRIMARY KEY CHECK (id = 1),
+    user_id    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Instances that already have accounts are past their first signup
+INSERT INTO first_user (id, user_id, created_at)
+SELECT 1, id, created_at FROM users ORDER BY created_at LIMIT 1;
@@ -0,0 +1,4 @@
+-- The hex SHA-256 of an encrypted object's plaintext, which the bucket can't
+-- be asked for since it only holds ciphertext. Empty when the object was
+-- written without one, as multipart uploads are.
+ALTER TABLE data_keys ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+type AuthHandler struct {
+	auth *services.AuthService
+}
+
+func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+	return &AuthHandler{
+		auth: authService,
+	}
+}
+
+func newTokenResponse(tokens *services.TokenPair) rest.TokenResponse {
+	return rest.TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
+	}
+}
+
+func (h *AuthHandler) Signup(c *gin.Context) {
+	var req rest.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "Username (3-64 characters) and password (at least 8 characters) are required")
+		return
+	}
+	if len(req.Password) > auth.MaxPasswordLength {
+		rest.BadRequest(c, fmt.Sprintf("Password must be at most %d bytes", auth.MaxPasswordLength))
+		return
+	}
+
+	user, tokens, err := h.auth.Signup(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, services.ErrUsernameTaken) {
+		rest.Conflict(c, err.Error())
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, rest.AuthResponse{
+		User:          rest.NewUserResponse(user),
+		TokenResponse: newTokenResponse(tokens),
+		Message:       "Account created successfully",
+	})
+}
+
+func (h *AuthHandler) Signin(c *gin.Context) {
+	var req rest.SigninRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "Username and password are required")
+		return
+	}
+
+	user, tokens, err := h.auth.Signin(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		rest.Unauthorized(c, err.Error())
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, rest.AuthResponse{
+		User:          rest.NewUserResponse(user),
+		TokenResponse: newTokenResponse(tokens),
+		Message:       "Signed in successfully",
+	})
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req rest.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "Refresh token required")
+		return
+	}
+
+	_, tokens, err := h.auth.Refresh(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		rest.Unauthorized(c, err.Error())
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, newTokenResponse(tokens))
+}
+
+func (h *AuthHandler) Profile(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.auth.GetUser(c.Request.Context(), principal.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.Unauthorized(c, "Invalid token")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, rest.NewUserResponse(user))
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// checksumHeader lets clients send the SHA-256 of what they upload, in hex
+// or base64, so content damaged on the way is refused
+const checksumHeader = "X-Checksum-SHA256"
+
+// checksumUpload hashes an uploaded file and checks it against the digest
+// the client sent, if any. The file is rewound so it can be stored next.
+func checksumUpload(c *gin.Context, file multipart.File) (string, int64, bool) {
+	checksum, size, err := storage.Checksum(file)
+	if err != nil {
+		rest.InternalError(c, err)
+		return "", 0, false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		rest.InternalError(c, err)
+		return "", 0, false
+	}
+
+	if header := c.GetHeader(checksumHeader); header != "" {
+		expected, ok := parseChecksum(header)
+		if !ok {
+			rest.BadRequest(c, checksumHeader+" must be a hex or base64 SHA-256 digest")
+			return "", 0, false
+		}
+		if expected != checksum {
+			rest.BadRequest(c, "Uploaded content does not match "+checksumHeader)
+			return "", 0, false
+		}
+	}
+
+	return checksum, size, true
+}
+
+// parseChecksum returns a hex or base64 SHA-256 digest as lower-case hex
+func parseChecksum(value string) (string, bool) {
+	digest, err := hex.DecodeString(value)
+	if err != nil {
+		digest, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil || len(digest) != 32 {
+		return "", false
+	}
+	return hex.EncodeToString(digest), true
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+// serveContent streams a file's current content through the server. Range
+// requests, including multi-range ones, and If-None-Match, If-Modified-Since
+// and If-Range are answered by http.ServeContent; only the ranges asked for
+// are fetched from storage.
+func serveContent(c *gin.Context, s storage.StorageInterface, file *models.File, download bool) {
+	serveObject(c, s, file, "", download)
+}
+
+// serveVersion streams an earlier version of file like serveContent
+func serveVersion(c *gin.Context, s storage.StorageInterface, file *models.File, version *models.FileVersion, download bool) {
+	content := *file
+	content.StorageKey = version.StorageKey
+	content.FileSize = version.FileSize
+	content.ContentType = version.ContentType
+	content.Version = version.Version
+	content.UpdatedAt = version.CreatedAt
+	serveObject(c, s, &content, version.StorageVersionID, download)
+}
+
+// serveObject streams file's content from its storage key, or from the
+// bucket version versionID of it
+func serveObject(c *gin.Context, s storage.StorageInterface, file *models.File, versionID string, download bool) {
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := storage.InlineDisposition(file.FileName)
+	if download {
+		disposition = storage.AttachmentDisposition(file.FileName)
+	}
+
+	// Each version's content never changes, so the version identifies it
+	c.Header("ETag", fmt.Sprintf(`"%s.%d"`, file.ID, file.Version))
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", disposition)
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	// Uploaded pages mustn't run scripts as the API's origin
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "sandbox")
+
+	object := storage.OpenObjectVersion(c.Request.Context(), s, file.StorageKey, versionID, file.FileSize)
+	defer object.Close()
+	content := &loggedReader{ReadSeeker: object}
+	http.ServeContent(c.Writer, c.Request, file.FileName, file.UpdatedAt, content)
+
+	// The status line is gone by the time the object turns out to be
+	// unreadable, so all that's left is to cut the response short and say
+	// why, unless it was the client that went away
+	if content.err != nil && c.Request.Context().Err() == nil {
+		log.Printf("Failed to stream file %s: %v", file.ID, content.err)
+	}
+}
+
+// loggedReader keeps the first error reading content, which
+// http.ServeContent drops
+type loggedReader struct {
+	io.ReadSeeker
+	err error
+}
+
+func (r *loggedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+type DirectUploadResponse struct {
+	ID        string `json:"id"`
+	Size      int64  `json:"size"`
+	PartSize  int64  `json:"part_size"`
+	PartCount int32  `json:"part_count"`
+}
+
+type PresignedPartsResponse struct {
+	Parts     []PresignedPartResponse `json:"parts"`
+	ExpiresIn int                     `json:"expires_in"`
+}
+
+type PresignedPartResponse struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// DirectUploadHandler lets clients upload parts straight to the bucket with
+// presigned URLs. The server only opens, checks and closes the upload.
+type DirectUploadHandler struct {
+	uploads *services.UploadService
+	policy  *policy.FilePolicy
+}
+
+func NewDirectUploadHandler(uploads *services.UploadService, filePolicy *policy.FilePolicy) *DirectUploadHandler {
+	return &DirectUploadHandler{
+		uploads: uploads,
+		policy:  filePolicy,
+	}
+}
+
+func (h *DirectUploadHandler) StartUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policy.CanUpload(principal); err != nil {
+		rest.Forbidden(c, "Viewers cannot upload files")
+		return
+	}
+
+	var req rest.DirectUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "file_name and a non-negative size are required")
+		return
+	}
+
+	fileName := sanitizeFileName(req.FileName)
+	if fileName == "" {
+		rest.BadRequest(c, "file_name is not a valid file name")
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	upload, err := h.uploads.StartDirect(c.Request.Context(), principal.UserID, fileName, contentType, req.Size)
+	if errors.Is(err, services.ErrUploadTooLarge) {
+		rest.Error(c, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+	if !h.handleError(c, err) {
+		return
+	}
+
+	rest.Success(c, DirectUploadResponse{
+		ID:        upload.ID,
+		Size:      upload.Length,
+		PartSize:  upload.PartSize,
+		PartCount: services.PartCount(upload),
+	})
+}
+
+func (h *DirectUploadHandler) PresignParts(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	var req rest.PresignPartsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "part_numbers must list between 1 and 1000 part numbers")
+		return
+	}
+
+	parts, err := h.uploads.PresignParts(c.Request.Context(), c.Param("id"), principal.UserID, req.PartNumbers)
+	if !h.handleError(c, err) {
+		return
+	}
+
+	partList := make([]PresignedPartResponse, 0, len(parts))
+	for _, part := range parts {
+		partList = append(partList, PresignedPartResponse{
+			PartNumber: part.PartNumber,
+			URL:        part.URL,
+		})
+	}
+
+	rest.Success(c, PresignedPartsResponse{
+		Parts:     partList,
+		ExpiresIn: int(services.PartURLExpiry.Seconds()),
+	})
+}
+
+func (h *DirectUploadHandler) CompleteUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	var req rest.CompleteUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "parts must list every part_number with its etag")
+		return
+	}
+
+	parts := make([]storage.CompletedPart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		parts = append(parts, storage.CompletedPart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	file, err := h.uploads.CompleteDirect(c.Request.Context(), c.Param("id"), principal.UserID, parts)
+	if !h.handleError(c, err) {
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+func (h *DirectUploadHandler) AbortUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	err := h.uploads.AbortDirect(c.Request.Context(), c.Param("id"), principal.UserID)
+	if !h.handleError(c, err) {
+		return
+	}
+
+	rest.Success(c, "Upload aborted")
+}
+
+// handleError writes the response for a failed upload call and reports
+// whether the handler should carry on
+func (h *DirectUploadHandler) handleError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrUploadNotFound):
+		rest.NotFound(c, "Upload not found")
+	case errors.Is(err, services.ErrUploadPartsInvalid):
+		rest.BadRequest(c, err.Error())
+	case errors.Is(err, services.ErrUploadSizeMismatch):
+		rest.Conflict(c, err.Error())
+	case errors.Is(err, storage.ErrPresignUnsupported):
+		rest.NotImplemented(c, "Direct uploads aren't available while files are encrypted; use the resumable upload endpoint")
+	default:
+		rest.InternalError(c, err)
+	}
+	return false
+}
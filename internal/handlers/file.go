@@ -1,27 +1,60 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
 	"github.com/okoye-dev/oss-archive/internal/storage"
 	"github.com/okoye-dev/oss-archive/internal/transport/rest"
 )
 
 type FilesResponse struct {
-	Files []FileResponse `json:"files"`
+	Files      []FileResponse `json:"files"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
 
+// Page sizes for file listings
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
 type FileResponse struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	StorageKey string `json:"storage_key"`
-	Size       int64  `json:"size"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	StorageKey  string     `json:"storage_key"`
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type"`
+	SHA256      string     `json:"sha256,omitempty"`
+	OwnerID     string     `json:"owner_id"`
+	FolderID    string     `json:"folder_id,omitempty"` // omitted at the root
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // only set for files in the trash
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+}
+
+type GrantsResponse struct {
+	Grants []GrantResponse `json:"grants"`
+}
+
+type GrantResponse struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type FileDownloadResponse struct {
@@ -31,16 +64,59 @@ type FileDownloadResponse struct {
 }
 
 type FileHandler struct {
-	storage storage.StorageInterface
+	storage  storage.StorageInterface
+	files    repository.FileRepository
+	users    repository.UserRepository
+	blobs    *services.BlobService
+	folders  *services.FolderService
+	trash    *services.TrashService
+	policy   *policy.FilePolicy
+	expiry   PresignExpiry
 }
 
-func NewFileHandler(storage storage.StorageInterface) *FileHandler {
+func NewFileHandler(storage storage.StorageInterface, files repository.FileRepository, users repository.UserRepository, blobs *services.BlobService, folders *services.FolderService, trash *services.TrashService, filePolicy *policy.FilePolicy, expiry PresignExpiry) *FileHandler {
 	return &FileHandler{
-		storage: storage,
+		storage:  storage,
+		files:    files,
+		users:    users,
+		blobs:    blobs,
+		folders:  folders,
+		trash:    trash,
+		policy:   filePolicy,
+		expiry:   expiry,
+	}
+}
+
+func newFileResponse(file *models.File) FileResponse {
+	return FileResponse{
+		ID:          file.ID,
+		Name:        file.FileName,
+		StorageKey:  file.StorageKey,
+		Size:        file.FileSize,
+		ContentType: file.ContentType,
+		SHA256:      file.SHA256,
+		OwnerID:     file.OwnerID,
+		FolderID:    file.FolderID,
+		Version:     file.Version,
+		CreatedAt:   file.CreatedAt,
+		UpdatedAt:   file.UpdatedAt,
+		DeletedAt:   file.DeletedAt,
+		RetainUntil: file.RetainUntil,
+		LegalHold:   file.LegalHold,
 	}
 }
 
 func (h *FileHandler) UploadFile(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policy.CanUpload(principal); err != nil {
+		rest.Forbidden(c, "Viewers cannot upload files")
+		return
+	}
+
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
 		rest.BadRequest(c, "No file provided")
@@ -48,120 +124,386 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 	}
 	defer file.Close()
 
-	// Generate unique ID and storage key
-	fileID := uuid.New().String()
-	storageKey := fmt.Sprintf("%s_%s", fileID, header.Filename)
-	
+	// An optional folder_id field puts the file in one of the caller's folders
+	folderID := c.PostForm("folder_id")
+	if folderID != "" {
+		folder, ok := loadFolder(c, h.folders, h.policy, folderID, policy.ActionEdit)
+		if !ok {
+			return
+		}
+		if folder.OwnerID != principal.UserID {
+			rest.NotFound(c, "Folder not found")
+			return
+		}
+	}
+
 	// Get content type
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 
-	// Upload to storage using storage key
-	err = h.storage.UploadFile(storageKey, file, header.Size, contentType)
+	checksum, size, ok := checksumUpload(c, file)
+	if !ok {
+		return
+	}
+
+	// Content another file already has is not stored again
+	storageKey, err := h.blobs.Store(c.Request.Context(), file, size, contentType, checksum)
 	if err != nil {
 		rest.InternalError(c, err)
 		return
 	}
 
-	// Return file info
-	fileData := map[string]interface{}{
-		"id":          fileID,
-		"name":        header.Filename,
-		"storage_key": storageKey,
-		"file_size":   header.Size,
-		"file_type":   contentType,
-		"created_at":  time.Now().Format(time.RFC3339),
-		"updated_at":  time.Now().Format(time.RFC3339),
+	// Record the upload in the catalog
+	now := time.Now().UTC()
+	record := &models.File{
+		ID:          uuid.New().String(),
+		FileName:    header.Filename,
+		StorageKey:  storageKey,
+		FileSize:    size,
+		ContentType: contentType,
+		SHA256:      checksum,
+		OwnerID:     principal.UserID,
+		FolderID:    folderID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := h.files.Create(c.Request.Context(), record); err != nil {
+		// Don't keep a reference the catalog knows nothing about
+		if relErr := h.blobs.Release(context.WithoutCancel(c.Request.Context()), checksum); relErr != nil {
+			log.Printf("Failed to clean up %s after catalog error: %v", storageKey, relErr)
+		}
+		rest.InternalError(c, err)
+		return
 	}
 
-	rest.Success(c, fileData)
+	rest.Success(c, newFileResponse(record))
 }
 
 
 func (h *FileHandler) GetFiles(c *gin.Context) {
-	files, err := h.storage.ListFiles()
-	if err != nil {
-		rest.InternalError(c, err)
+	principal, ok := requirePrincipal(c)
+	if !ok {
 		return
 	}
 
-	var fileList []FileResponse
-	for _, storageKey := range files {
-		// Extract ID and name from storage key (format: "timestamp_originalname.ext")
-		parts := strings.SplitN(storageKey, "_", 2)
-		var fileID, fileName string
-		
-		if len(parts) == 2 {
-			fileID = parts[0]
-			fileName = parts[1]
-		} else {
-			// Fallback for files without timestamp prefix
-			fileID = storageKey
-			fileName = filepath.Base(storageKey)
-		}
-		
-		// Get file size
-		fileSize, err := h.storage.GetFileSize(storageKey)
-		if err != nil {
-			log.Printf("Failed to get file size for %s: %v", storageKey, err)
-			fileSize = 0
+	opts, ok := parseListOptions(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.files.ListAccessible(c.Request.Context(), principal.UserID, opts)
+	if !h.handleListError(c, err) {
+		return
+	}
+
+	rest.Success(c, newFilesResponse(page))
+}
+
+// GetAllFiles lists every file in the archive regardless of owner
+func (h *FileHandler) GetAllFiles(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policy.CanListAll(principal); err != nil {
+		rest.Forbidden(c, "Insufficient permissions")
+		return
+	}
+
+	opts, ok := parseListOptions(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.files.List(c.Request.Context(), opts)
+	if !h.handleListError(c, err) {
+		return
+	}
+
+	rest.Success(c, newFilesResponse(page))
+}
+
+// parseListOptions reads limit, cursor, prefix, sort (name, size or date)
+// and order (asc or desc). Names sort ascending by default, everything else
+// newest or largest first.
+func parseListOptions(c *gin.Context) (repository.ListOptions, bool) {
+	opts := repository.ListOptions{
+		Prefix: c.Query("prefix"),
+		Sort:   repository.FileSort(c.DefaultQuery("sort", string(repository.FileSortDate))),
+		Limit:  defaultPageSize,
+		Cursor: c.Query("cursor"),
+	}
+
+	switch opts.Sort {
+	case repository.FileSortName:
+	case repository.FileSortSize, repository.FileSortDate:
+		opts.Descending = true
+	default:
+		rest.BadRequest(c, "sort must be name, size or date")
+		return opts, false
+	}
+
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		opts.Descending = false
+	case "desc":
+		opts.Descending = true
+	default:
+		rest.BadRequest(c, "order must be asc or desc")
+		return opts, false
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			rest.BadRequest(c, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return opts, false
 		}
-		
-		fileList = append(fileList, FileResponse{
-			ID:         fileID,
-			Name:       fileName,
-			StorageKey: storageKey,
-			Size:       fileSize,
-		})
+		opts.Limit = n
 	}
 
-	rest.Success(c, FilesResponse{
-		Files: fileList,
-	})
+	return opts, true
+}
+
+func (h *FileHandler) handleListError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, repository.ErrInvalidCursor):
+		rest.BadRequest(c, "cursor does not belong to this listing")
+		return false
+	case err != nil:
+		rest.InternalError(c, err)
+		return false
+	}
+	return true
+}
+
+func newFilesResponse(page *repository.FilePage) FilesResponse {
+	fileList := make([]FileResponse, 0, len(page.Files))
+	for i := range page.Files {
+		fileList = append(fileList, newFileResponse(&page.Files[i]))
+	}
+
+	return FilesResponse{
+		Files:      fileList,
+		NextCursor: page.NextCursor,
+	}
 }
 
 func (h *FileHandler) GetFile(c *gin.Context) {
-	filename := c.Param("id")
-	if filename == "" {
-		rest.BadRequest(c, "File ID required")
+	file, ok := h.loadFile(c, policy.ActionRead)
+	if !ok {
 		return
 	}
 
-	forceDownload := c.Query("download") == "true"
+	opts, ok := h.expiry.parsePresignOptions(c, file)
+	if !ok {
+		return
+	}
 
 	// Generate presigned URL
-	presignedURL, err := h.storage.GetPresignedURL(filename, forceDownload)
+	presignedURL, err := h.storage.GetPresignedURL(c.Request.Context(), file.StorageKey, opts)
 	if err != nil {
-		rest.NotFound(c, "File not found")
+		presignError(c, err, "/files/:id/content")
 		return
 	}
 
 	rest.Success(c, FileDownloadResponse{
 		URL:        presignedURL,
-		Download:   forceDownload,
-		ExpiresIn:  3600,
+		Download:   opts.Download,
+		ExpiresIn:  int(opts.Lifetime().Seconds()),
 	})
 }
 
+// GetContent streams the file through the server, for clients that need
+// range or conditional requests and for backends that can't presign
+func (h *FileHandler) GetContent(c *gin.Context) {
+	file, ok := h.loadFile(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	serveContent(c, h.storage, file, c.Query("download") == "true")
+}
+
+// presignError answers a request for a download link that couldn't be made,
+// pointing at the route that streams the same content instead
+func presignError(c *gin.Context, err error, contentPath string) {
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		rest.NotImplemented(c, "Encrypted files can't be downloaded through a presigned URL; fetch "+contentPath+" instead")
+		return
+	}
+	rest.InternalError(c, err)
+}
+
 func (h *FileHandler) DeleteFile(c *gin.Context) {
-	filename := c.Param("id")
-	if filename == "" {
-		rest.BadRequest(c, "File ID required")
+	file, ok := h.loadFile(c, policy.ActionDelete)
+	if !ok {
+		return
+	}
+
+	// The file can be restored from the trash until its retention runs out
+	err := h.trash.Trash(c.Request.Context(), file)
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.NotFound(c, "File not found")
+		return
+	}
+	if errors.Is(err, services.ErrHeld) {
+		rest.Conflict(c, heldMessage)
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+func (h *FileHandler) ListGrants(c *gin.Context) {
+	file, ok := h.loadFile(c, policy.ActionShare)
+	if !ok {
 		return
 	}
 
-	err := h.storage.DeleteFile(filename)
+	grants, err := h.files.ListGrants(c.Request.Context(), file.ID)
 	if err != nil {
 		rest.InternalError(c, err)
 		return
 	}
 
-	rest.Success(c, FileResponse{
-		ID:         filename,
-		Name:       filename,
-		StorageKey: filename,
-		Size:       0,
+	grantList := make([]GrantResponse, 0, len(grants))
+	for _, grant := range grants {
+		grantList = append(grantList, GrantResponse{
+			UserID:    grant.UserID,
+			Username:  grant.Username,
+			CreatedAt: grant.CreatedAt,
+		})
+	}
+
+	rest.Success(c, GrantsResponse{
+		Grants: grantList,
 	})
-}
\ No newline at end of file
+}
+
+func (h *FileHandler) AddGrant(c *gin.Context) {
+	file, ok := h.loadFile(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	var req rest.GrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "Username required")
+		return
+	}
+
+	user, err := h.users.GetByUsername(c.Request.Context(), services.NormalizeUsername(req.Username))
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.NotFound(c, "User not found")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+	if user.ID == file.OwnerID {
+		rest.BadRequest(c, "The owner already has access to this file")
+		return
+	}
+
+	grant := &models.FileGrant{
+		FileID:    file.ID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.files.AddGrant(c.Request.Context(), grant); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			rest.Conflict(c, "User already has access to this file")
+			return
+		}
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, GrantResponse{
+		UserID:    grant.UserID,
+		Username:  grant.Username,
+		CreatedAt: grant.CreatedAt,
+	})
+}
+
+func (h *FileHandler) RemoveGrant(c *gin.Context) {
+	file, ok := h.loadFile(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	err := h.files.RemoveGrant(c.Request.Context(), file.ID, c.Param("userId"))
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.NotFound(c, "Grant not found")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, "Access revoked")
+}
+
+// loadFile fetches the :id file and checks the caller may perform action on
+// it. Files the caller can't see at all are reported as not found.
+func (h *FileHandler) loadFile(c *gin.Context, action policy.Action) (*models.File, bool) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return nil, false
+	}
+
+	fileID := c.Param("id")
+	if fileID == "" {
+		rest.BadRequest(c, "File ID required")
+		return nil, false
+	}
+
+	file, err := h.files.GetByID(c.Request.Context(), fileID)
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.NotFound(c, "File not found")
+		return nil, false
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	err = h.policy.Authorize(c.Request.Context(), principal, action, file)
+	switch {
+	case errors.Is(err, policy.ErrHidden):
+		rest.NotFound(c, "File not found")
+		return nil, false
+	case errors.Is(err, policy.ErrForbidden):
+		rest.Forbidden(c, "You don't have permission to "+string(action)+" this file")
+		return nil, false
+	case err != nil:
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	return file, true
+}
+
+// requirePrincipal returns the caller attached by the auth middleware
+func requirePrincipal(c *gin.Context) (*auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(c.Request.Context())
+	if !ok {
+		rest.Unauthorized(c, "Authentication required")
+		return nil, false
+	}
+
+	return principal, true
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// rootFolderID stands for an owner's root in /folders/:id routes
+const rootFolderID = "root"
+
+type FolderResponse struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	ParentID    string     `json:"parent_id,omitempty"` // omitted at the root
+	OwnerID     string     `json:"owner_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+}
+
+type FolderChildrenResponse struct {
+	Folder     *FolderResponse  `json:"folder,omitempty"` // omitted for the root
+	Folders    []FolderResponse `json:"folders"`
+	Files      []FileResponse   `json:"files"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// FolderNotEmptyResponse tells the client what a recursive delete would
+// remove, so it can ask the user before repeating it with recursive=true
+type FolderNotEmptyResponse struct {
+	rest.ErrorResponse
+	Folders int `json:"folders"`
+	Files   int `json:"files"`
+}
+
+type FolderDeletedResponse struct {
+	FolderResponse
+	DeletedFolders int `json:"deleted_folders"`
+	TrashedFiles   int `json:"trashed_files"`
+}
+
+// FolderHandler manages folders and moves files between them
+type FolderHandler struct {
+	files   *FileHandler
+	folders *services.FolderService
+	policy  *policy.FilePolicy
+}
+
+func NewFolderHandler(files *FileHandler, folders *services.FolderService, filePolicy *policy.FilePolicy) *FolderHandler {
+	return &FolderHandler{
+		files:   files,
+		folders: folders,
+		policy:  filePolicy,
+	}
+}
+
+func newFolderResponse(folder *models.Folder) FolderResponse {
+	return FolderResponse{
+		ID:          folder.ID,
+		Name:        folder.Name,
+		ParentID:    folder.ParentID,
+		OwnerID:     folder.OwnerID,
+		CreatedAt:   folder.CreatedAt,
+		UpdatedAt:   folder.UpdatedAt,
+		RetainUntil: folder.RetainUntil,
+		LegalHold:   folder.LegalHold,
+	}
+}
+
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policy.CanUpload(principal); err != nil {
+		rest.Forbidden(c, "Viewers cannot create folders")
+		return
+	}
+
+	var req rest.CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "name is required")
+		return
+	}
+
+	// Folders are created in the caller's own tree
+	if req.ParentID != "" {
+		if _, ok := loadFolder(c, h.folders, h.policy, req.ParentID, policy.ActionEdit); !ok {
+			return
+		}
+	}
+
+	folder, err := h.folders.Create(c.Request.Context(), principal.UserID, req.ParentID, req.Name)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	rest.Success(c, newFolderResponse(folder))
+}
+
+func (h *FolderHandler) GetFolder(c *gin.Context) {
+	folder, ok := loadFolder(c, h.folders, h.policy, c.Param("id"), policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	rest.Success(c, newFolderResponse(folder))
+}
+
+// ListChildren lists a folder's subfolders and a page of its files. The
+// files accept the same query parameters as GET /files.
+func (h *FolderHandler) ListChildren(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	opts, ok := parseListOptions(c)
+	if !ok {
+		return
+	}
+
+	response := FolderChildrenResponse{}
+	ownerID, folderID := principal.UserID, ""
+	if id := c.Param("id"); id != rootFolderID {
+		folder, ok := loadFolder(c, h.folders, h.policy, id, policy.ActionRead)
+		if !ok {
+			return
+		}
+		ownerID, folderID = folder.OwnerID, folder.ID
+		folderResponse := newFolderResponse(folder)
+		response.Folder = &folderResponse
+	}
+
+	folders, page, err := h.folders.Children(c.Request.Context(), ownerID, folderID, opts)
+	if !h.files.handleListError(c, err) {
+		return
+	}
+
+	response.Folders = make([]FolderResponse, 0, len(folders))
+	for i := range folders {
+		response.Folders = append(response.Folders, newFolderResponse(&folders[i]))
+	}
+	files := newFilesResponse(page)
+	response.Files = files.Files
+	response.NextCursor = files.NextCursor
+
+	rest.Success(c, response)
+}
+
+// UpdateFolder renames a folder, moves it, or both
+func (h *FolderHandler) UpdateFolder(c *gin.Context) {
+	folder, ok := loadFolder(c, h.folders, h.policy, c.Param("id"), policy.ActionEdit)
+	if !ok {
+		return
+	}
+
+	var req rest.UpdateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Name == nil && req.ParentID == nil) {
+		rest.BadRequest(c, "name or parent_id is required")
+		return
+	}
+
+	if req.ParentID != nil && *req.ParentID != "" {
+		if _, ok := loadFolder(c, h.folders, h.policy, *req.ParentID, policy.ActionEdit); !ok {
+			return
+		}
+	}
+
+	if req.Name != nil {
+		if err := h.folders.Rename(c.Request.Context(), folder, *req.Name); err != nil {
+			h.handleError(c, err)
+			return
+		}
+	}
+	if req.ParentID != nil {
+		if err := h.folders.Move(c.Request.Context(), folder, *req.ParentID); err != nil {
+			h.handleError(c, err)
+			return
+		}
+	}
+
+	rest.Success(c, newFolderResponse(folder))
+}
+
+// DeleteFolder removes an empty folder. A folder with contents is only
+// deleted when recursive=true is passed, taking its subfolders with it and
+// moving its files to the trash.
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	folder, ok := loadFolder(c, h.folders, h.policy, c.Param("id"), policy.ActionDelete)
+	if !ok {
+		return
+	}
+
+	contents, err := h.folders.Delete(c.Request.Context(), folder, c.Query("recursive") == "true")
+	if errors.Is(err, services.ErrFolderNotEmpty) {
+		message := "Folder is not empty; repeat with recursive=true to delete it and move its files to the trash"
+		c.JSON(http.StatusConflict, FolderNotEmptyResponse{
+			ErrorResponse: rest.ErrorResponse{
+				Error:   message,
+				Code:    http.StatusConflict,
+				Message: message,
+			},
+			Folders: contents.Folders,
+			Files:   contents.Files,
+		})
+		return
+	}
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	rest.Success(c, FolderDeletedResponse{
+		FolderResponse: newFolderResponse(folder),
+		DeletedFolders: contents.Folders,
+		TrashedFiles:   contents.Files,
+	})
+}
+
+// MoveFile puts a file into another folder of its owner's
+func (h *FolderHandler) MoveFile(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionEdit)
+	if !ok {
+		return
+	}
+
+	var req rest.MoveFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "folder_id is required; use an empty string for the root")
+		return
+	}
+
+	if *req.FolderID != "" {
+		if _, ok := loadFolder(c, h.folders, h.policy, *req.FolderID, policy.ActionEdit); !ok {
+			return
+		}
+	}
+
+	if err := h.folders.MoveFile(c.Request.Context(), file, *req.FolderID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+func (h *FolderHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound):
+		rest.NotFound(c, "Folder not found")
+	case errors.Is(err, services.ErrInvalidFolderName):
+		rest.BadRequest(c, err.Error())
+	case errors.Is(err, services.ErrFolderExists), errors.Is(err, services.ErrFolderCycle):
+		rest.Conflict(c, err.Error())
+	case errors.Is(err, services.ErrHeld):
+		rest.Conflict(c, heldMessage)
+	default:
+		rest.InternalError(c, err)
+	}
+}
+
+// loadFolder fetches a folder and checks the caller may perform action on
+// it, writing the error response if not
+func loadFolder(c *gin.Context, folders *services.FolderService, filePolicy *policy.FilePolicy, id string, action policy.Action) (*models.Folder, bool) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return nil, false
+	}
+
+	folder, err := folders.Get(c.Request.Context(), id)
+	if errors.Is(err, services.ErrFolderNotFound) {
+		rest.NotFound(c, "Folder not found")
+		return nil, false
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	err = filePolicy.AuthorizeFolder(principal, action, folder)
+	switch {
+	case errors.Is(err, policy.ErrHidden):
+		rest.NotFound(c, "Folder not found")
+		return nil, false
+	case errors.Is(err, policy.ErrForbidden):
+		rest.Forbidden(c, "You don't have permission to "+string(action)+" this folder")
+		return nil, false
+	case err != nil:
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	return folder, true
+}
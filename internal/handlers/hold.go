@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// heldMessage is returned when a hold stops a file from being changed
+const heldMessage = "A retention period or legal hold is in effect"
+
+// HoldHandler lets admins put retention periods and legal holds on files
+// and folders
+type HoldHandler struct {
+	files   *FileHandler
+	folders *services.FolderService
+	holds   *services.HoldService
+	policy  *policy.FilePolicy
+}
+
+func NewHoldHandler(files *FileHandler, folders *services.FolderService, holds *services.HoldService, filePolicy *policy.FilePolicy) *HoldHandler {
+	return &HoldHandler{
+		files:   files,
+		folders: folders,
+		holds:   holds,
+		policy:  filePolicy,
+	}
+}
+
+func (h *HoldHandler) SetFileHold(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionEdit)
+	if !ok {
+		return
+	}
+
+	hold, ok := bindHold(c, file.Hold)
+	if !ok {
+		return
+	}
+
+	if err := h.holds.SetFileHold(c.Request.Context(), file, hold); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+func (h *HoldHandler) SetFolderHold(c *gin.Context) {
+	folder, ok := loadFolder(c, h.folders, h.policy, c.Param("id"), policy.ActionEdit)
+	if !ok {
+		return
+	}
+
+	hold, ok := bindHold(c, folder.Hold)
+	if !ok {
+		return
+	}
+
+	if err := h.holds.SetFolderHold(c.Request.Context(), folder, hold); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	rest.Success(c, newFolderResponse(folder))
+}
+
+// bindHold applies the request body to the current hold
+func bindHold(c *gin.Context, hold models.Hold) (models.Hold, bool) {
+	var req rest.HoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.RetainDays == nil && req.LegalHold == nil) {
+		rest.BadRequest(c, "retain_days (1-36500) or legal_hold is required")
+		return hold, false
+	}
+
+	if req.RetainDays != nil {
+		until := time.Now().UTC().AddDate(0, 0, *req.RetainDays)
+		hold.RetainUntil = &until
+	}
+	if req.LegalHold != nil {
+		hold.LegalHold = *req.LegalHold
+	}
+
+	return hold, true
+}
+
+func (h *HoldHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		rest.NotFound(c, "File not found")
+	case errors.Is(err, services.ErrFolderNotFound):
+		rest.NotFound(c, "Folder not found")
+	case errors.Is(err, services.ErrRetentionShortened):
+		rest.Conflict(c, err.Error())
+	default:
+		rest.InternalError(c, err)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// maxFileNameLength is the longest name a download can be saved under
+const maxFileNameLength = 255
+
+// PresignExpiry bounds how long the download URLs handed out stay valid.
+// Zero fields fall back to the storage defaults.
+type PresignExpiry struct {
+	Default time.Duration // when the request doesn't ask for a lifetime
+	Max     time.Duration // the longest a request can ask for
+}
+
+func (e PresignExpiry) defaultExpiry() time.Duration {
+	if e.Default > 0 {
+		return min(e.Default, e.maxExpiry())
+	}
+	return min(storage.DefaultPresignExpiry, e.maxExpiry())
+}
+
+func (e PresignExpiry) maxExpiry() time.Duration {
+	if e.Max > 0 {
+		return min(e.Max, storage.MaxPresignExpiry)
+	}
+	return storage.MaxPresignExpiry
+}
+
+// parsePresignOptions reads download, expires_in (seconds), content_type and
+// filename for a download link to file. The lifetime is always filled in, so
+// it can be reported as it was signed.
+func (e PresignExpiry) parsePresignOptions(c *gin.Context, file *models.File) (storage.PresignOptions, bool) {
+	opts := storage.PresignOptions{
+		Download: c.Query("download") == "true",
+		FileName: file.FileName,
+		Expires:  e.defaultExpiry(),
+	}
+
+	if expiresIn := c.Query("expires_in"); expiresIn != "" {
+		maxSeconds := int64(e.maxExpiry() / time.Second)
+		n, err := strconv.ParseInt(expiresIn, 10, 64)
+		if err != nil || n < 1 || n > maxSeconds {
+			rest.BadRequest(c, fmt.Sprintf("expires_in must be between 1 and %d seconds", maxSeconds))
+			return opts, false
+		}
+		opts.Expires = time.Duration(n) * time.Second
+	}
+
+	if contentType := c.Query("content_type"); contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			rest.BadRequest(c, "content_type is not a valid media type")
+			return opts, false
+		}
+		opts.ContentType = contentType
+	}
+
+	if name := c.Query("filename"); name != "" {
+		if !validFileName(name) {
+			rest.BadRequest(c, fmt.Sprintf("filename must be at most %d bytes, without slashes or control characters", maxFileNameLength))
+			return opts, false
+		}
+		opts.FileName = name
+	}
+
+	return opts, true
+}
+
+// validFileName reports whether name can be offered as a download's name
+func validFileName(name string) bool {
+	if len(name) > maxFileNameLength || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return !strings.ContainsFunc(name, unicode.IsControl)
+}
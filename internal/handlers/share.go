@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// shareRedirectExpiry is how long the URL a share link redirects to stays
+// valid. The link itself is what gets passed around, and it counts a
+// download each time, so the URL only has to last until it is followed.
+const shareRedirectExpiry = 10 * time.Second
+
+type SharesResponse struct {
+	Shares []ShareResponse `json:"shares"`
+}
+
+type ShareResponse struct {
+	ID                string     `json:"id"`
+	URL               string     `json:"url,omitempty"`   // only returned when the link is created
+	Token             string     `json:"token,omitempty"` // only returned when the link is created
+	ExpiresAt         *time.Time `json:"expires_at"`
+	MaxDownloads      *int       `json:"max_downloads"`
+	DownloadCount     int        `json:"download_count"`
+	PasswordProtected bool       `json:"password_protected"`
+	Active            bool       `json:"active"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// ShareHandler manages share links on files and serves the public /s/:token route
+type ShareHandler struct {
+	files     *FileHandler
+	shares    *services.ShareService
+	publicURL string
+}
+
+func NewShareHandler(files *FileHandler, shares *services.ShareService, publicURL string) *ShareHandler {
+	return &ShareHandler{
+		files:     files,
+		shares:    shares,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+func newShareResponse(link *models.ShareLink) ShareResponse {
+	return ShareResponse{
+		ID:                link.ID,
+		ExpiresAt:         link.ExpiresAt,
+		MaxDownloads:      link.MaxDownloads,
+		DownloadCount:     link.DownloadCount,
+		PasswordProtected: link.PasswordHash != "",
+		Active:            services.ShareActive(link, time.Now()),
+		RevokedAt:         link.RevokedAt,
+		CreatedAt:         link.CreatedAt,
+	}
+}
+
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+	principal, _ := requirePrincipal(c)
+
+	var req rest.CreateShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			rest.BadRequest(c, "expires_in and max_downloads must be positive")
+			return
+		}
+	}
+	if len(req.Password) > auth.MaxPasswordLength {
+		rest.BadRequest(c, fmt.Sprintf("password must be at most %d bytes", auth.MaxPasswordLength))
+		return
+	}
+
+	opts := services.ShareOptions{
+		Password:     req.Password,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.ExpiresIn != nil {
+		expiresAt := time.Now().UTC().Add(time.Duration(*req.ExpiresIn) * time.Second)
+		opts.ExpiresAt = &expiresAt
+	}
+
+	link, token, err := h.shares.Create(c.Request.Context(), file, principal.UserID, opts)
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	response := newShareResponse(link)
+	response.Token = token
+	response.URL = h.shareURL(c, token)
+
+	rest.Success(c, response)
+}
+
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	links, err := h.shares.List(c.Request.Context(), file.ID)
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	shareList := make([]ShareResponse, 0, len(links))
+	for i := range links {
+		shareList = append(shareList, newShareResponse(&links[i]))
+	}
+
+	rest.Success(c, SharesResponse{
+		Shares: shareList,
+	})
+}
+
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	err := h.shares.Revoke(c.Request.Context(), file.ID, c.Param("shareId"))
+	if errors.Is(err, services.ErrShareNotFound) {
+		rest.NotFound(c, "Share link not found")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, "Share link revoked")
+}
+
+// OpenShare is the public entry point for a share link. The password, when
+// the link has one, is read from the X-Share-Password header or a "password"
+// form field so it never ends up in a URL.
+func (h *ShareHandler) OpenShare(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	password := c.GetHeader("X-Share-Password")
+	if password == "" && c.Request.Method == http.MethodPost {
+		password = c.PostForm("password")
+	}
+
+	link, file, err := h.shares.Open(c.Request.Context(), c.Param("token"), password)
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		rest.NotFound(c, "Share link not found")
+		return
+	case errors.Is(err, services.ErrShareUnavailable):
+		rest.Gone(c, err.Error())
+		return
+	case errors.Is(err, services.ErrSharePasswordRequired), errors.Is(err, services.ErrSharePasswordInvalid):
+		rest.Unauthorized(c, err.Error())
+		return
+	case errors.Is(err, services.ErrSharePasswordLocked):
+		rest.TooManyRequests(c, err.Error())
+		return
+	case err != nil:
+		rest.InternalError(c, err)
+		return
+	}
+
+	presignedURL, err := h.files.storage.GetPresignedURL(c.Request.Context(), file.StorageKey, storage.PresignOptions{
+		Download: true,
+		FileName: file.FileName,
+		Expires:  shareRedirectExpiry,
+	})
+	// Links to files the bucket can't hand out are streamed instead
+	streamed := errors.Is(err, storage.ErrPresignUnsupported)
+	if err != nil && !streamed {
+		rest.InternalError(c, err)
+		return
+	}
+
+	// Only count the download once it is sure to be served
+	err = h.shares.Consume(c.Request.Context(), link)
+	if errors.Is(err, services.ErrShareUnavailable) {
+		rest.Gone(c, err.Error())
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	if streamed {
+		serveContent(c, h.files.storage, file, true)
+		return
+	}
+
+	status := http.StatusFound
+	if c.Request.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	c.Redirect(status, presignedURL)
+}
+
+// shareURL builds the public link, preferring the configured public URL over
+// whatever host the request came in on
+func (h *ShareHandler) shareURL(c *gin.Context, token string) string {
+	return baseURL(c, h.publicURL) + "/s/" + token
+}
+
+// baseURL is the scheme and host clients reach the server on. The configured
+// public URL wins; otherwise it is taken from the request and any proxy headers.
+func baseURL(c *gin.Context, publicURL string) string {
+	if publicURL != "" {
+		return publicURL
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+
+	return scheme + "://" + host
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+type TrashResponse struct {
+	Files      []TrashedFileResponse `json:"files"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+type TrashedFileResponse struct {
+	FileResponse
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashHandler serves the caller's trash
+type TrashHandler struct {
+	files  *FileHandler
+	trash  *services.TrashService
+	policy *policy.FilePolicy
+}
+
+func NewTrashHandler(files *FileHandler, trash *services.TrashService, filePolicy *policy.FilePolicy) *TrashHandler {
+	return &TrashHandler{
+		files:  files,
+		trash:  trash,
+		policy: filePolicy,
+	}
+}
+
+func (h *TrashHandler) newTrashedFileResponse(file *models.File) TrashedFileResponse {
+	return TrashedFileResponse{
+		FileResponse: newFileResponse(file),
+		PurgeAt:      h.trash.PurgeAt(file),
+	}
+}
+
+// ListTrash lists the caller's deleted files. It accepts the same query
+// parameters as GET /files.
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	opts, ok := parseListOptions(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.trash.List(c.Request.Context(), principal.UserID, opts)
+	if !h.files.handleListError(c, err) {
+		return
+	}
+
+	fileList := make([]TrashedFileResponse, 0, len(page.Files))
+	for i := range page.Files {
+		fileList = append(fileList, h.newTrashedFileResponse(&page.Files[i]))
+	}
+
+	rest.Success(c, TrashResponse{
+		Files:      fileList,
+		NextCursor: page.NextCursor,
+	})
+}
+
+func (h *TrashHandler) RestoreFile(c *gin.Context) {
+	file, ok := h.loadTrashed(c)
+	if !ok {
+		return
+	}
+
+	err := h.trash.Restore(c.Request.Context(), file)
+	if errors.Is(err, services.ErrNotInTrash) {
+		rest.NotFound(c, "File not found in trash")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+// DeleteFile removes a trashed file for good without waiting for the purger
+func (h *TrashHandler) DeleteFile(c *gin.Context) {
+	file, ok := h.loadTrashed(c)
+	if !ok {
+		return
+	}
+
+	err := h.trash.Delete(c.Request.Context(), file)
+	if errors.Is(err, services.ErrHeld) {
+		rest.Conflict(c, heldMessage)
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, newFileResponse(file))
+}
+
+// loadTrashed fetches the :id file from the trash. Only those who could
+// delete the file may see it there.
+func (h *TrashHandler) loadTrashed(c *gin.Context) (*models.File, bool) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return nil, false
+	}
+
+	file, err := h.trash.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrNotInTrash) {
+		rest.NotFound(c, "File not found in trash")
+		return nil, false
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	err = h.policy.Authorize(c.Request.Context(), principal, policy.ActionDelete, file)
+	switch {
+	case errors.Is(err, policy.ErrHidden), errors.Is(err, policy.ErrForbidden):
+		rest.NotFound(c, "File not found in trash")
+		return nil, false
+	case err != nil:
+		rest.InternalError(c, err)
+		return nil, false
+	}
+
+	return file, true
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// The tus resumable upload protocol, https://tus.io/protocols/resumable-upload
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// TusHandler speaks tus 1.0 on top of the upload service
+type TusHandler struct {
+	uploads   *services.UploadService
+	policy    *policy.FilePolicy
+	publicURL string
+}
+
+func NewTusHandler(uploads *services.UploadService, filePolicy *policy.FilePolicy, publicURL string) *TusHandler {
+	return &TusHandler{
+		uploads:   uploads,
+		policy:    filePolicy,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// Resumable tags every response with the protocol version and turns away
+// clients speaking a version we don't support
+func (h *TusHandler) Resumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		rest.Error(c, http.StatusPreconditionFailed, "Unsupported tus version")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(services.MaxUploadSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TusHandler) CreateUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if err := h.policy.CanUpload(principal); err != nil {
+		rest.Forbidden(c, "Viewers cannot upload files")
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		rest.BadRequest(c, "Upload-Defer-Length is not supported")
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		rest.BadRequest(c, "Upload-Length must be a non-negative integer")
+		return
+	}
+	if length > services.MaxUploadSize {
+		rest.Error(c, http.StatusRequestEntityTooLarge, "Upload is larger than Tus-Max-Size")
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		rest.BadRequest(c, "Upload-Metadata is malformed")
+		return
+	}
+
+	fileName := sanitizeFileName(metadata["filename"])
+	if fileName == "" {
+		rest.BadRequest(c, "Upload-Metadata must include a filename")
+		return
+	}
+
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	upload, err := h.uploads.Create(c.Request.Context(), principal.UserID, fileName, contentType, length)
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	c.Header("Location", baseURL(c, h.publicURL)+c.Request.URL.Path+"/"+upload.ID)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusCreated)
+}
+
+func (h *TusHandler) GetUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+
+	upload, err := h.uploads.Get(c.Request.Context(), c.Param("id"), principal.UserID)
+	if errors.Is(err, services.ErrUploadNotFound) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load upload %s: %v", c.Param("id"), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+func (h *TusHandler) PatchUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		rest.Error(c, http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		rest.BadRequest(c, "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	upload, file, err := h.uploads.Write(c.Request.Context(), c.Param("id"), principal.UserID, offset, c.Request.ContentLength, c.Request.Body)
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		rest.NotFound(c, "Upload not found")
+		return
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		rest.Conflict(c, "Upload-Offset does not match the current offset")
+		return
+	case errors.Is(err, services.ErrUploadTooLarge):
+		rest.Error(c, http.StatusRequestEntityTooLarge, "Request body runs past Upload-Length")
+		return
+	case err != nil:
+		rest.InternalError(c, err)
+		return
+	}
+
+	if file != nil {
+		log.Printf("Finished resumable upload %s (%d bytes)", file.ID, file.FileSize)
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TusHandler) DeleteUpload(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	err := h.uploads.Terminate(c.Request.Context(), c.Param("id"), principal.UserID)
+	if errors.Is(err, services.ErrUploadNotFound) {
+		rest.NotFound(c, "Upload not found")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// parseUploadMetadata decodes "key base64value" pairs separated by commas.
+// Keys may appear without a value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// sanitizeFileName keeps only the last path element of a client supplied
+// name, the same way multipart form uploads are treated
+func sanitizeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
@@ -1,31 +1,98 @@
 package handlers
 
 import (
-	"time"
+	"errors"
 
 	"github.com/gin-gonic/gin"
 	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
 	"github.com/okoye-dev/oss-archive/internal/transport/rest"
 )
 
-func CreateUser(c *gin.Context) {
-	rest.Success(c, "User created successfully")
+type UsersResponse struct {
+	Users []rest.UserResponse `json:"users"`
 }
 
-func GetUser(c *gin.Context) {
-	mockUser := models.User{
-		ID: "1",
-		FirstName: "John",
-		LastName: "Doe",
-		Email: "john.doe@example.com",
-		Password: "password",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	
-	rest.Success(c, mockUser)
+type UserHandler struct {
+	users   repository.UserRepository
+	service *services.UserService
 }
 
-func UpdateUser(c *gin.Context) {
-	rest.Success(c, "User updated successfully")
-}
\ No newline at end of file
+func NewUserHandler(users repository.UserRepository, service *services.UserService) *UserHandler {
+	return &UserHandler{
+		users:   users,
+		service: service,
+	}
+}
+
+// GetUser returns the caller's own account; admins may look up anyone's
+func (h *UserHandler) GetUser(c *gin.Context) {
+	principal, ok := requirePrincipal(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	if id != principal.UserID && principal.Role != models.RoleAdmin {
+		rest.Forbidden(c, "You can only view your own account")
+		return
+	}
+
+	user, err := h.users.GetByID(c.Request.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		rest.NotFound(c, "User not found")
+		return
+	}
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, rest.NewUserResponse(user))
+}
+
+// ListUsers returns every account with its role (admin only)
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	users, err := h.service.List(c.Request.Context())
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	userList := make([]rest.UserResponse, 0, len(users))
+	for i := range users {
+		userList = append(userList, rest.NewUserResponse(&users[i]))
+	}
+
+	rest.Success(c, UsersResponse{
+		Users: userList,
+	})
+}
+
+// SetRole changes a user's role (admin only)
+func (h *UserHandler) SetRole(c *gin.Context) {
+	var req rest.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.BadRequest(c, "Role required")
+		return
+	}
+
+	user, err := h.service.SetRole(c.Request.Context(), c.Param("id"), models.Role(req.Role))
+	switch {
+	case errors.Is(err, services.ErrInvalidRole):
+		rest.BadRequest(c, err.Error())
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		rest.NotFound(c, "User not found")
+		return
+	case errors.Is(err, services.ErrLastAdmin):
+		rest.Conflict(c, err.Error())
+		return
+	case err != nil:
+		rest.InternalError(c, err)
+		return
+	}
+
+	rest.Success(c, rest.NewUserResponse(user))
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+type VersionsResponse struct {
+	Versions []VersionResponse `json:"versions"`
+}
+
+type VersionResponse struct {
+	Version     int       `json:"version"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	SHA256      string    `json:"sha256,omitempty"`
+	UploadedBy  string    `json:"uploaded_by,omitempty"` // omitted once the account is gone
+	Uploader    string    `json:"uploader,omitempty"`
+	Current     bool      `json:"current"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// VersionHandler serves a file's history and adds new revisions to it
+type VersionHandler struct {
+	files    *FileHandler
+	versions *services.VersionService
+}
+
+func NewVersionHandler(files *FileHandler, versions *services.VersionService) *VersionHandler {
+	return &VersionHandler{
+		files:    files,
+		versions: versions,
+	}
+}
+
+func newVersionResponse(file *models.File, version *models.FileVersion) VersionResponse {
+	return VersionResponse{
+		Version:     version.Version,
+		Size:        version.FileSize,
+		ContentType: version.ContentType,
+		SHA256:      version.SHA256,
+		UploadedBy:  version.UploadedBy,
+		Uploader:    version.UploaderName,
+		Current:     version.Version == file.Version,
+		CreatedAt:   version.CreatedAt,
+	}
+}
+
+// UploadVersion replaces a file's content with the uploaded one, keeping
+// the earlier content in its history
+func (h *VersionHandler) UploadVersion(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionEdit)
+	if !ok {
+		return
+	}
+	principal, _ := requirePrincipal(c)
+
+	upload, header, err := c.Request.FormFile("file")
+	if err != nil {
+		rest.BadRequest(c, "No file provided")
+		return
+	}
+	defer upload.Close()
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	checksum, size, ok := checksumUpload(c, upload)
+	if !ok {
+		return
+	}
+
+	version, err := h.versions.Upload(c.Request.Context(), file, principal.UserID, upload, size, contentType, checksum)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	version.UploaderName = principal.Username
+
+	rest.Success(c, newVersionResponse(file, version))
+}
+
+func (h *VersionHandler) ListVersions(c *gin.Context) {
+	file, ok := h.files.loadFile(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	versions, err := h.versions.List(c.Request.Context(), file.ID)
+	if err != nil {
+		rest.InternalError(c, err)
+		return
+	}
+
+	versionList := make([]VersionResponse, 0, len(versions))
+	for i := range versions {
+		versionList = append(versionList, newVersionResponse(file, &versions[i]))
+	}
+
+	rest.Success(c, VersionsResponse{
+		Versions: versionList,
+	})
+}
+
+// GetVersion returns a presigned link to one version, like GET /files/:id
+func (h *VersionHandler) GetVersion(c *gin.Context) {
+	file, version, ok := h.loadVersion(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	opts, ok := h.files.expiry.parsePresignOptions(c, file)
+	if !ok {
+		return
+	}
+	presignedURL, err := h.versions.URL(c.Request.Context(), version, opts)
+	if err != nil {
+		presignError(c, err, "/files/:id/versions/:version/content")
+		return
+	}
+
+	rest.Success(c, FileDownloadResponse{
+		URL:       presignedURL,
+		Download:  opts.Download,
+		ExpiresIn: int(opts.Lifetime().Seconds()),
+	})
+}
+
+// GetVersionContent streams one version through the server, like
+// GET /files/:id/content. Earlier versions of encrypted files can only be
+// read this way, since they can't be presigned.
+func (h *VersionHandler) GetVersionContent(c *gin.Context) {
+	file, version, ok := h.loadVersion(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	serveVersion(c, h.files.storage, file, version, c.Query("download") == "true")
+}
+
+// RestoreVersion makes an earlier version current by copying it to the top
+// of the history
+func (h *VersionHandler) RestoreVersion(c *gin.Context) {
+	file, version, ok := h.loadVersion(c, policy.ActionEdit)
+	if !ok {
+		return
+	}
+	principal, _ := requirePrincipal(c)
+
+	restored, err := h.versions.Restore(c.Request.Context(), file, version, principal.UserID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	restored.UploaderName = principal.Username
+
+	rest.Success(c, newVersionResponse(file, restored))
+}
+
+// loadVersion fetches the :version of the :id file after checking the
+// caller may perform action on the file
+func (h *VersionHandler) loadVersion(c *gin.Context, action policy.Action) (*models.File, *models.FileVersion, bool) {
+	file, ok := h.files.loadFile(c, action)
+	if !ok {
+		return nil, nil, false
+	}
+
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		rest.BadRequest(c, "version must be a positive number")
+		return nil, nil, false
+	}
+
+	version, err := h.versions.Get(c.Request.Context(), file.ID, number)
+	if err != nil {
+		h.handleError(c, err)
+		return nil, nil, false
+	}
+
+	return file, version, true
+}
+
+func (h *VersionHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrVersionNotFound):
+		rest.NotFound(c, "Version not found")
+	case errors.Is(err, services.ErrVersionConflict):
+		rest.Conflict(c, err.Error())
+	case errors.Is(err, services.ErrHeld):
+		rest.Conflict(c, heldMessage)
+	default:
+		rest.InternalError(c, err)
+	}
+}
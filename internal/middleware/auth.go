@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+// RequireAuth rejects requests without a valid bearer access token and
+// attaches the caller's auth.Principal to the request context. The account
+// is reloaded on every request so role changes and deletions apply at once.
+func RequireAuth(tokens *auth.TokenManager, users repository.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			unauthorized(c, "Authentication required")
+			return
+		}
+
+		claims, err := tokens.Parse(strings.TrimSpace(token))
+		if err != nil {
+			unauthorized(c, "Invalid token")
+			return
+		}
+
+		user, err := users.GetByID(c.Request.Context(), claims.Subject)
+		if errors.Is(err, repository.ErrNotFound) {
+			unauthorized(c, "Invalid token")
+			return
+		}
+		if err != nil {
+			rest.InternalError(c, err)
+			c.Abort()
+			return
+		}
+
+		principal := &auth.Principal{
+			UserID:   user.ID,
+			Username: user.Username,
+			Role:     user.Role,
+		}
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+
+		c.Next()
+	}
+}
+
+// RequireRole only lets principals with one of the given roles through.
+// It must run after RequireAuth.
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.PrincipalFromContext(c.Request.Context())
+		if !ok {
+			unauthorized(c, "Authentication required")
+			return
+		}
+
+		for _, role := range roles {
+			if principal.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		rest.Forbidden(c, "Insufficient permissions")
+		c.Abort()
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="oss-archive"`)
+	rest.Unauthorized(c, message)
+	c.Abort()
+}
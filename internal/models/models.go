@@ -2,22 +2,184 @@ package models
 
 import "time"
 
+// Role controls what a user may do across the archive
+type Role string
+
+const (
+	// RoleAdmin can read, delete and share any file and manage users
+	RoleAdmin Role = "admin"
+	// RoleMember has full control of their own files
+	RoleMember Role = "member"
+	// RoleViewer can only read files they own or have been granted
+	RoleViewer Role = "viewer"
+)
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleMember, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// User is the stored account. It is never serialised directly;
+// responses go through rest.UserResponse.
 type User struct {
-	ID        string `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           string
+	Username     string
+	PasswordHash string `json:"-"`
+	Role         Role
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// RefreshToken is a hashed, single-use refresh token. Tokens issued by
+// rotating one another share a FamilyID.
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	FamilyID  string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// Hold keeps a file from being deleted or overwritten. Retention lapses on
+// its own at RetainUntil; a legal hold lasts until an admin lifts it.
+type Hold struct {
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+}
+
+// Active reports whether the hold still applies at now
+func (h Hold) Active(now time.Time) bool {
+	return h.LegalHold || (h.RetainUntil != nil && h.RetainUntil.After(now))
 }
 
 type File struct {
-	ID        string `json:"id"`
-	FileName  string `json:"file_name"`
-	FilePath  string `json:"file_path"`
-	FileSize  int64 `json:"file_size"`
-	FileType  string `json:"file_type"`
+	ID          string     `json:"id"`
+	FileName    string     `json:"file_name"`
+	StorageKey  string     `json:"storage_key"`
+	FileSize    int64      `json:"file_size"`
+	ContentType string     `json:"content_type"`
+	SHA256      string     `json:"sha256"` // hex; empty when the server never saw the whole content
+	OwnerID     string     `json:"owner_id"`
+	FolderID    string     `json:"folder_id"` // empty at the owner's root
+	Version     int        `json:"version"`   // the current entry in the file's history
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at"` // set while the file is in the trash
+	Hold
+}
+
+// FileVersion is one revision of a file's content. StorageVersionID is set
+// when the bucket keeps versions itself; otherwise each version is stored
+// under a key of its own.
+type FileVersion struct {
+	FileID           string
+	Version          int
+	StorageKey       string
+	StorageVersionID string
+	FileSize         int64
+	ContentType      string
+	SHA256           string
+	UploadedBy       string // empty once the uploader's account is gone
+	UploaderName     string
+	CreatedAt        time.Time
+}
+
+// Blob is content stored once no matter how many file versions have it.
+// RefCount is how many versions use it; the object goes when none do.
+type Blob struct {
+	SHA256     string
+	StorageKey string
+	FileSize   int64
+	RefCount   int
+	CreatedAt  time.Time
+}
+
+// DataKey is the key an encrypted object was sealed with, kept wrapped by a
+// master key so the catalog alone can't read the bucket. Size is that of the
+// plaintext, which the object's own size overstates.
+type DataKey struct {
+	StorageKey  string
+	WrappedKey  string // base64
+	MasterKeyID string
+	Size        int64
+	SHA256      string // hex digest of the plaintext; empty when not known
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Folder groups an owner's files. Folders only exist in the catalog, so
+// moving or renaming one never touches stored objects.
+type Folder struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"owner_id"`
+	ParentID  string    `json:"parent_id"` // empty at the owner's root
+	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-}
\ No newline at end of file
+	Hold                // covers every file and folder below
+}
+
+// FileGrant gives a user other than the owner read access to a file
+type FileGrant struct {
+	FileID    string    `json:"file_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareLink is a revocable public link to a file. A nil ExpiresAt or
+// MaxDownloads means no limit.
+type ShareLink struct {
+	ID            string
+	FileID        string
+	CreatedBy     string
+	TokenHash     string
+	PasswordHash  string
+	ExpiresAt     *time.Time
+	MaxDownloads  *int
+	DownloadCount int
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
+
+// UploadMode says how an upload's bytes reach storage
+type UploadMode string
+
+const (
+	// UploadModeTus streams chunks through the server
+	UploadModeTus UploadMode = "tus"
+	// UploadModeDirect has the client PUT parts to presigned bucket URLs
+	UploadModeDirect UploadMode = "direct"
+)
+
+// Upload is a resumable upload in progress. Offset counts every byte
+// received, including a tail that is still too small to send as a part.
+type Upload struct {
+	ID          string
+	OwnerID     string
+	FileName    string
+	ContentType string
+	StorageKey  string
+	Mode        UploadMode
+	MultipartID string // tus uploads start the multipart upload with their first full part
+	Length      int64
+	Offset      int64
+	PartSize    int64
+	HashState   []byte // SHA-256 state after the first Offset bytes, tus only
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// UploadPart is one part stored in an upload's multipart upload
+type UploadPart struct {
+	UploadID   string
+	PartNumber int32
+	ETag       string
+	Size       int64
+}
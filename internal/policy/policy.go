@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+// Action is something a caller may try to do to a file
+type Action string
+
+const (
+	ActionRead   Action = "read"   // fetch metadata or download
+	ActionDelete Action = "delete" // remove the file
+	ActionShare  Action = "share"  // manage who else can read it
+	ActionEdit   Action = "edit"   // rename or move it, or add to a folder
+)
+
+var (
+	// ErrForbidden means the caller can see the file but may not do this to it
+	ErrForbidden = errors.New("forbidden")
+	// ErrHidden means the caller may not know the file exists
+	ErrHidden = errors.New("file not visible")
+)
+
+// FilePolicy decides which file operations a principal may perform.
+//
+//   - admins may do anything to any file
+//   - members have full control of files they own and may read granted files
+//   - viewers may only read files they own or have been granted
+type FilePolicy struct {
+	files repository.FileRepository
+}
+
+func NewFilePolicy(files repository.FileRepository) *FilePolicy {
+	return &FilePolicy{
+		files: files,
+	}
+}
+
+// CanUpload reports whether the principal may add new files
+func (p *FilePolicy) CanUpload(principal *auth.Principal) error {
+	if principal.Role == models.RoleViewer {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CanListAll reports whether the principal may list every file in the archive
+func (p *FilePolicy) CanListAll(principal *auth.Principal) error {
+	if principal.Role != models.RoleAdmin {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Authorize checks a single action against a file. It returns ErrHidden when
+// the caller has no access at all and ErrForbidden when they can read the
+// file but not perform the action.
+func (p *FilePolicy) Authorize(ctx context.Context, principal *auth.Principal, action Action, file *models.File) error {
+	if principal.Role == models.RoleAdmin {
+		return nil
+	}
+
+	owner := file.OwnerID == principal.UserID
+	if !owner {
+		granted, err := p.files.HasGrant(ctx, file.ID, principal.UserID)
+		if err != nil {
+			return err
+		}
+		if !granted {
+			return ErrHidden
+		}
+	}
+
+	switch action {
+	case ActionRead:
+		return nil
+	case ActionDelete, ActionShare, ActionEdit:
+		if owner && principal.Role == models.RoleMember {
+			return nil
+		}
+		return ErrForbidden
+	default:
+		return ErrForbidden
+	}
+}
+
+// AuthorizeFolder checks an action against a folder. Folders are private to
+// their owner: nobody else learns they exist, grants on the files inside
+// notwithstanding, and owners who are viewers may only look.
+func (p *FilePolicy) AuthorizeFolder(principal *auth.Principal, action Action, folder *models.Folder) error {
+	if principal.Role == models.RoleAdmin {
+		return nil
+	}
+	if folder.OwnerID != principal.UserID {
+		return ErrHidden
+	}
+
+	if action == ActionRead || principal.Role == models.RoleMember {
+		return nil
+	}
+	return ErrForbidden
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// BlobRepository keeps the reference counts of deduplicated content. A
+// reference is taken with Acquire or Add when content is stored for a new
+// file version and belongs to that version from then on; deleting the file
+// gives its references back.
+type BlobRepository interface {
+	Get(ctx context.Context, sha256 string) (*models.Blob, error)
+	// Acquire takes a reference to a blob that is still in use. It returns
+	// false when the blob is unknown or nothing uses it any more, in which
+	// case its object may already be gone.
+	Acquire(ctx context.Context, sha256 string) (bool, error)
+	// Add records a blob whose object has just been written, with one
+	// reference. A row that is already there gains a reference instead.
+	Add(ctx context.Context, blob *models.Blob) error
+	// Release gives back a reference and returns how many are left
+	Release(ctx context.Context, sha256 string) (int, error)
+	// ListUnreferenced returns up to limit blobs nothing uses, in checksum
+	// order starting after the given one
+	ListUnreferenced(ctx context.Context, after string, limit int) ([]models.Blob, error)
+	// Delete forgets a blob, unless it has been referenced again since
+	Delete(ctx context.Context, sha256 string) error
+}
+
+type SQLBlobRepository struct {
+	db *sql.DB
+}
+
+func NewBlobRepository(db *sql.DB) BlobRepository {
+	return &SQLBlobRepository{
+		db: db,
+	}
+}
+
+const blobColumns = `sha256, storage_key, file_size, ref_count, created_at`
+
+func (r *SQLBlobRepository) Get(ctx context.Context, sha256 string) (*models.Blob, error) {
+	blob, err := scanBlob(r.db.QueryRowContext(ctx, `SELECT `+blobColumns+` FROM blobs WHERE sha256 = $1`, sha256))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	return blob, nil
+}
+
+func (r *SQLBlobRepository) Acquire(ctx context.Context, sha256 string) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE blobs SET ref_count = ref_count + 1 WHERE sha256 = $1 AND ref_count > 0`, sha256)
+	if err != nil {
+		return false, fmt.Errorf("failed to reference blob: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to reference blob: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLBlobRepository) Add(ctx context.Context, blob *models.Blob) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO blobs (`+blobColumns+`) VALUES ($1, $2, $3, 1, $4)
+		 ON CONFLICT (sha256) DO UPDATE SET ref_count = blobs.ref_count + 1`,
+		blob.SHA256,
+		blob.StorageKey,
+		blob.FileSize,
+		blob.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add blob: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLBlobRepository) Release(ctx context.Context, sha256 string) (int, error) {
+	var remaining int
+	err := r.db.QueryRowContext(ctx,
+		`UPDATE blobs SET ref_count = ref_count - 1 WHERE sha256 = $1 AND ref_count > 0
+		 RETURNING ref_count`, sha256).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to release blob: %w", err)
+	}
+
+	return remaining, nil
+}
+
+func (r *SQLBlobRepository) ListUnreferenced(ctx context.Context, after string, limit int) ([]models.Blob, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+blobColumns+` FROM blobs WHERE ref_count <= 0 AND sha256 > $1 ORDER BY sha256 LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []models.Blob
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan blob: %w", err)
+		}
+		blobs = append(blobs, *blob)
+	}
+
+	return blobs, rows.Err()
+}
+
+func (r *SQLBlobRepository) Delete(ctx context.Context, sha256 string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM blobs WHERE sha256 = $1 AND ref_count <= 0`, sha256)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+
+	return nil
+}
+
+func scanBlob(s scanner) (*models.Blob, error) {
+	var blob models.Blob
+	if err := s.Scan(&blob.SHA256, &blob.StorageKey, &blob.FileSize, &blob.RefCount, &blob.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
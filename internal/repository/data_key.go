@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// DataKeyRepository keeps the wrapped keys of objects encrypted at rest. It
+// is the storage.KeyStore the encrypting backend is built with.
+type DataKeyRepository interface {
+	// GetDataKey returns nil when the object has no data key, as objects
+	// written before encryption was turned on don't
+	GetDataKey(ctx context.Context, storageKey string) (*models.DataKey, error)
+	// PutDataKey records an object's data key, replacing any it had
+	PutDataKey(ctx context.Context, key *models.DataKey) error
+	// SetDataKeySize records the plaintext size once it is known
+	SetDataKeySize(ctx context.Context, storageKey string, size int64) error
+	// RewrapDataKey replaces a wrapped key with the same key wrapped by
+	// another master key. It returns false if the object has been given a
+	// different key since previous was read.
+	RewrapDataKey(ctx context.Context, storageKey, previous, wrapped, masterKeyID string) (bool, error)
+	// ListStaleDataKeys returns up to limit keys not wrapped by masterKeyID,
+	// in storage key order starting after the given one
+	ListStaleDataKeys(ctx context.Context, masterKeyID, after string, limit int) ([]models.DataKey, error)
+	DeleteDataKey(ctx context.Context, storageKey string) error
+}
+
+type SQLDataKeyRepository struct {
+	db *sql.DB
+}
+
+func NewDataKeyRepository(db *sql.DB) DataKeyRepository {
+	return &SQLDataKeyRepository{
+		db: db,
+	}
+}
+
+const dataKeyColumns = `storage_key, wrapped_key, master_key_id, size, sha256, created_at, updated_at`
+
+func (r *SQLDataKeyRepository) GetDataKey(ctx context.Context, storageKey string) (*models.DataKey, error) {
+	key, err := scanDataKey(r.db.QueryRowContext(ctx,
+		`SELECT `+dataKeyColumns+` FROM data_keys WHERE storage_key = $1`, storageKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (r *SQLDataKeyRepository) PutDataKey(ctx context.Context, key *models.DataKey) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO data_keys (`+dataKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (storage_key) DO UPDATE SET
+		     wrapped_key = excluded.wrapped_key,
+		     master_key_id = excluded.master_key_id,
+		     size = excluded.size,
+		     sha256 = excluded.sha256,
+		     created_at = excluded.created_at,
+		     updated_at = excluded.updated_at`,
+		key.StorageKey,
+		key.WrappedKey,
+		key.MasterKeyID,
+		key.Size,
+		key.SHA256,
+		key.CreatedAt.UTC(),
+		key.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store data key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLDataKeyRepository) SetDataKeySize(ctx context.Context, storageKey string, size int64) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE data_keys SET size = $2, updated_at = $3 WHERE storage_key = $1`,
+		storageKey, size, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to update data key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update data key: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLDataKeyRepository) RewrapDataKey(ctx context.Context, storageKey, previous, wrapped, masterKeyID string) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE data_keys SET wrapped_key = $3, master_key_id = $4, updated_at = $5
+		 WHERE storage_key = $1 AND wrapped_key = $2`,
+		storageKey, previous, wrapped, masterKeyID, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to rewrap data key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to rewrap data key: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLDataKeyRepository) ListStaleDataKeys(ctx context.Context, masterKeyID, after string, limit int) ([]models.DataKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+dataKeyColumns+` FROM data_keys
+		 WHERE master_key_id <> $1 AND storage_key > $2
+		 ORDER BY storage_key LIMIT $3`, masterKeyID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.DataKey
+	for rows.Next() {
+		key, err := scanDataKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *SQLDataKeyRepository) DeleteDataKey(ctx context.Context, storageKey string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM data_keys WHERE storage_key = $1`, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+
+	return nil
+}
+
+func scanDataKey(s scanner) (*models.DataKey, error) {
+	var key models.DataKey
+	if err := s.Scan(&key.StorageKey, &key.WrappedKey, &key.MasterKeyID, &key.Size, &key.SHA256, &key.CreatedAt, &key.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
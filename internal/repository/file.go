@@ -0,0 +1,742 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// FileRepository stores file metadata in the catalog
+type FileRepository interface {
+	Create(ctx context.Context, file *models.File) error
+	// GetByID returns a file that is not in the trash
+	GetByID(ctx context.Context, id string) (*models.File, error)
+	List(ctx context.Context, opts ListOptions) (*FilePage, error)
+	// ListAccessible returns files the user owns or has been granted
+	ListAccessible(ctx context.Context, userID string, opts ListOptions) (*FilePage, error)
+	// ListInFolders returns every file directly inside any of folderIDs,
+	// leaving out the trash
+	ListInFolders(ctx context.Context, folderIDs []string) ([]models.File, error)
+	// ListByStorageKey returns every file, trashed ones included, with a
+	// version stored under storageKey
+	ListByStorageKey(ctx context.Context, storageKey string) ([]models.File, error)
+	// Move puts a file in another folder; an empty folderID is the root
+	Move(ctx context.Context, id, folderID string, now time.Time) error
+	// Delete removes a file from the catalog for good, trashed or not, and
+	// gives back the blob references held by its versions
+	Delete(ctx context.Context, id string) error
+
+	// Trash moves a file to the trash, Restore takes it back out into
+	// folderID, and GetTrashed fetches one that is in there
+	Trash(ctx context.Context, id string, now time.Time) error
+	Restore(ctx context.Context, id, folderID string, now time.Time) error
+	GetTrashed(ctx context.Context, id string) (*models.File, error)
+	// ListTrashedBefore returns up to limit files trashed before the given
+	// time, oldest first, after skipping the first offset
+	ListTrashedBefore(ctx context.Context, before time.Time, offset, limit int) ([]models.File, error)
+
+	// SetHold replaces a file's retention and legal hold
+	SetHold(ctx context.Context, id string, hold models.Hold) error
+
+	// AddVersion records a new revision and makes it the file's current
+	// content. It returns ErrConflict if version doesn't directly follow the
+	// file's current version, as happens when two revisions race.
+	AddVersion(ctx context.Context, version *models.FileVersion) error
+	// ListVersions returns a file's history, newest first
+	ListVersions(ctx context.Context, fileID string) ([]models.FileVersion, error)
+	GetVersion(ctx context.Context, fileID string, version int) (*models.FileVersion, error)
+	// ListAllVersions pages through every version of every file, trashed
+	// ones included, in file and version order starting after the given one
+	ListAllVersions(ctx context.Context, afterFileID string, afterVersion, limit int) ([]models.FileVersion, error)
+	// SetStorageVersionID fills in the bucket's version id for versions
+	// stored under storageKey that were written before the bucket kept versions
+	SetStorageVersionID(ctx context.Context, fileID, storageKey, versionID string) error
+
+	AddGrant(ctx context.Context, grant *models.FileGrant) error
+	RemoveGrant(ctx context.Context, fileID, userID string) error
+	ListGrants(ctx context.Context, fileID string) ([]models.FileGrant, error)
+	HasGrant(ctx context.Context, fileID, userID string) (bool, error)
+}
+
+// FileSort is the order files are listed in
+type FileSort string
+
+const (
+	FileSortName FileSort = "name"
+	FileSortSize FileSort = "size"
+	FileSortDate FileSort = "date"
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't issued for the
+// listing it is used with
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions narrows a file listing and picks the page to return
+type ListOptions struct {
+	Prefix     string  // only names starting with this, ignoring case
+	Owner      string  // only files owned by this user
+	Folder     *string // only files directly in this folder, "" for the root
+	Trashed    bool    // list the trash instead of live files
+	Sort       FileSort
+	Descending bool
+	Limit      int
+	Cursor     string // NextCursor of the previous page
+}
+
+// FilePage is one page of a listing. NextCursor is empty on the last page.
+type FilePage struct {
+	Files      []models.File
+	NextCursor string
+}
+
+// fileCursor is the position after the last file of a page. Keyset
+// pagination stays stable while files are added or removed between pages.
+type fileCursor struct {
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+type SQLFileRepository struct {
+	db *sql.DB
+}
+
+func NewFileRepository(db *sql.DB) FileRepository {
+	return &SQLFileRepository{
+		db: db,
+	}
+}
+
+const fileColumns = `id, file_name, storage_key, file_size, content_type, sha256, owner_id, folder_id, version, created_at, updated_at, deleted_at, retain_until, legal_hold`
+
+// Create adds a file along with the first entry in its history
+func (r *SQLFileRepository) Create(ctx context.Context, file *models.File) error {
+	if file.Version == 0 {
+		file.Version = 1
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO files (`+fileColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		file.ID,
+		file.FileName,
+		file.StorageKey,
+		file.FileSize,
+		file.ContentType,
+		file.SHA256,
+		file.OwnerID,
+		nullString(file.FolderID),
+		file.Version,
+		file.CreatedAt.UTC(),
+		file.UpdatedAt.UTC(),
+		nullTime(file.DeletedAt),
+		nullTime(file.RetainUntil),
+		file.LegalHold,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert file: %w", err)
+	}
+
+	if err := insertVersion(ctx, tx, &models.FileVersion{
+		FileID:      file.ID,
+		Version:     file.Version,
+		StorageKey:  file.StorageKey,
+		FileSize:    file.FileSize,
+		ContentType: file.ContentType,
+		SHA256:      file.SHA256,
+		UploadedBy:  file.OwnerID,
+		CreatedAt:   file.CreatedAt,
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) GetByID(ctx context.Context, id string) (*models.File, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+fileColumns+` FROM files WHERE id = $1 AND deleted_at IS NULL`, id)
+
+	file, err := scanFile(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (r *SQLFileRepository) List(ctx context.Context, opts ListOptions) (*FilePage, error) {
+	return r.page(ctx, opts, nil, nil)
+}
+
+func (r *SQLFileRepository) ListAccessible(ctx context.Context, userID string, opts ListOptions) (*FilePage, error) {
+	return r.page(ctx, opts,
+		[]string{`(owner_id = $1 OR id IN (SELECT file_id FROM file_grants WHERE user_id = $1))`},
+		[]any{userID})
+}
+
+// page runs a listing restricted by conditions, which use args as their
+// leading placeholders
+func (r *SQLFileRepository) page(ctx context.Context, opts ListOptions, conditions []string, args []any) (*FilePage, error) {
+	column, err := sortColumn(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	direction, compare := "ASC", ">"
+	if opts.Descending {
+		direction, compare = "DESC", "<"
+	}
+	order := string(opts.Sort) + " " + direction
+
+	if opts.Trashed {
+		conditions = append(conditions, `deleted_at IS NOT NULL`)
+	} else {
+		conditions = append(conditions, `deleted_at IS NULL`)
+	}
+
+	if opts.Owner != "" {
+		args = append(args, opts.Owner)
+		conditions = append(conditions, fmt.Sprintf(`owner_id = $%d`, len(args)))
+	}
+
+	if opts.Folder != nil {
+		if *opts.Folder == "" {
+			conditions = append(conditions, `folder_id IS NULL`)
+		} else {
+			args = append(args, *opts.Folder)
+			conditions = append(conditions, fmt.Sprintf(`folder_id = $%d`, len(args)))
+		}
+	}
+
+	if opts.Prefix != "" {
+		args = append(args, escapeLike(strings.ToLower(opts.Prefix))+"%")
+		conditions = append(conditions, fmt.Sprintf(`LOWER(file_name) LIKE $%d ESCAPE '\'`, len(args)))
+	}
+
+	if opts.Cursor != "" {
+		cursor, value, err := decodeCursor(opts.Cursor, opts.Sort)
+		if err != nil || cursor.Order != order {
+			return nil, ErrInvalidCursor
+		}
+		args = append(args, value, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf(`(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id %[2]s $%[4]d))`,
+			column, compare, len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + fileColumns + ` FROM files`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	// One extra row tells us whether there is another page
+	args = append(args, opts.Limit+1)
+	query += fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT $%[3]d`, column, direction, len(args))
+
+	files, err := r.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &FilePage{Files: files}
+	if len(files) > opts.Limit {
+		page.Files = files[:opts.Limit]
+		page.NextCursor = encodeCursor(order, &page.Files[opts.Limit-1])
+	}
+
+	return page, nil
+}
+
+func (r *SQLFileRepository) ListInFolders(ctx context.Context, folderIDs []string) ([]models.File, error) {
+	if len(folderIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := make([]string, len(folderIDs))
+	args := make([]any, len(folderIDs))
+	for i, id := range folderIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+
+	return r.query(ctx,
+		`SELECT `+fileColumns+` FROM files WHERE deleted_at IS NULL AND folder_id IN (`+strings.Join(placeholders, ", ")+`)`,
+		args...)
+}
+
+func (r *SQLFileRepository) ListByStorageKey(ctx context.Context, storageKey string) ([]models.File, error) {
+	return r.query(ctx,
+		`SELECT `+fileColumns+` FROM files
+		 WHERE id IN (SELECT file_id FROM file_versions WHERE storage_key = $1)`,
+		storageKey)
+}
+
+func (r *SQLFileRepository) Move(ctx context.Context, id, folderID string, now time.Time) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE files SET folder_id = $1, updated_at = $2 WHERE id = $3`,
+		nullString(folderID), now.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) query(ctx context.Context, query string, args ...any) ([]models.File, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file: %w", err)
+		}
+		files = append(files, *file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return files, nil
+}
+
+func (r *SQLFileRepository) Delete(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Each version holds one reference to the blob it is stored in, if any
+	_, err = tx.ExecContext(ctx,
+		`UPDATE blobs SET ref_count = ref_count -
+		     (SELECT COUNT(*) FROM file_versions v WHERE v.file_id = $1 AND v.storage_key = blobs.storage_key)
+		 WHERE storage_key IN (SELECT storage_key FROM file_versions WHERE file_id = $1)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to release blobs: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delete: %w", err)
+	}
+
+	return nil
+}
+
+const versionColumns = `v.file_id, v.version, v.storage_key, v.storage_version_id, v.file_size, v.content_type, v.sha256, v.uploaded_by, u.username, v.created_at`
+
+func (r *SQLFileRepository) AddVersion(ctx context.Context, version *models.FileVersion) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertVersion(ctx, tx, version); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE files SET storage_key = $1, file_size = $2, content_type = $3, sha256 = $4, version = $5, updated_at = $6
+		 WHERE id = $7 AND version = $8`,
+		version.StorageKey,
+		version.FileSize,
+		version.ContentType,
+		version.SHA256,
+		version.Version,
+		version.CreatedAt.UTC(),
+		version.FileID,
+		version.Version-1,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update file: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update file: %w", err)
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit version: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) ListVersions(ctx context.Context, fileID string) ([]models.FileVersion, error) {
+	return r.queryVersions(ctx,
+		`SELECT `+versionColumns+`
+		 FROM file_versions v LEFT JOIN users u ON u.id = v.uploaded_by
+		 WHERE v.file_id = $1
+		 ORDER BY v.version DESC`, fileID)
+}
+
+func (r *SQLFileRepository) ListAllVersions(ctx context.Context, afterFileID string, afterVersion, limit int) ([]models.FileVersion, error) {
+	return r.queryVersions(ctx,
+		`SELECT `+versionColumns+`
+		 FROM file_versions v LEFT JOIN users u ON u.id = v.uploaded_by
+		 WHERE v.file_id > $1 OR (v.file_id = $1 AND v.version > $2)
+		 ORDER BY v.file_id, v.version
+		 LIMIT $3`, afterFileID, afterVersion, limit)
+}
+
+func (r *SQLFileRepository) queryVersions(ctx context.Context, query string, args ...any) ([]models.FileVersion, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.FileVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, *version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (r *SQLFileRepository) GetVersion(ctx context.Context, fileID string, version int) (*models.FileVersion, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+versionColumns+`
+		 FROM file_versions v LEFT JOIN users u ON u.id = v.uploaded_by
+		 WHERE v.file_id = $1 AND v.version = $2`, fileID, version)
+
+	fileVersion, err := scanVersion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+
+	return fileVersion, nil
+}
+
+func (r *SQLFileRepository) SetStorageVersionID(ctx context.Context, fileID, storageKey, versionID string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE file_versions SET storage_version_id = $1
+		 WHERE file_id = $2 AND storage_key = $3 AND storage_version_id IS NULL`,
+		versionID, fileID, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to set storage version: %w", err)
+	}
+
+	return nil
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, version *models.FileVersion) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO file_versions (file_id, version, storage_key, storage_version_id, file_size, content_type, sha256, uploaded_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		version.FileID,
+		version.Version,
+		version.StorageKey,
+		nullString(version.StorageVersionID),
+		version.FileSize,
+		version.ContentType,
+		version.SHA256,
+		nullString(version.UploadedBy),
+		version.CreatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert version: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) Trash(ctx context.Context, id string, now time.Time) error {
+	return fileUpdated(r.db.ExecContext(ctx,
+		`UPDATE files SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`,
+		now.UTC(), id))
+}
+
+func (r *SQLFileRepository) Restore(ctx context.Context, id, folderID string, now time.Time) error {
+	return fileUpdated(r.db.ExecContext(ctx,
+		`UPDATE files SET deleted_at = NULL, folder_id = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NOT NULL`,
+		nullString(folderID), now.UTC(), id))
+}
+
+func (r *SQLFileRepository) GetTrashed(ctx context.Context, id string) (*models.File, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+fileColumns+` FROM files WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+
+	file, err := scanFile(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (r *SQLFileRepository) ListTrashedBefore(ctx context.Context, before time.Time, offset, limit int) ([]models.File, error) {
+	return r.query(ctx,
+		`SELECT `+fileColumns+` FROM files WHERE deleted_at < $1 ORDER BY deleted_at, id LIMIT $2 OFFSET $3`,
+		before.UTC(), limit, offset)
+}
+
+func (r *SQLFileRepository) SetHold(ctx context.Context, id string, hold models.Hold) error {
+	return fileUpdated(r.db.ExecContext(ctx,
+		`UPDATE files SET retain_until = $1, legal_hold = $2 WHERE id = $3`,
+		nullTime(hold.RetainUntil), hold.LegalHold, id))
+}
+
+// fileUpdated maps the result of a single row write, treating a missing
+// row as ErrNotFound
+func fileUpdated(result sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed to update file: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update file: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) AddGrant(ctx context.Context, grant *models.FileGrant) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO file_grants (file_id, user_id, created_at) VALUES ($1, $2, $3)`,
+		grant.FileID, grant.UserID, grant.CreatedAt.UTC())
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add grant: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) RemoveGrant(ctx context.Context, fileID, userID string) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM file_grants WHERE file_id = $1 AND user_id = $2`, fileID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove grant: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to remove grant: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLFileRepository) ListGrants(ctx context.Context, fileID string) ([]models.FileGrant, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT g.file_id, g.user_id, u.username, g.created_at
+		 FROM file_grants g JOIN users u ON u.id = g.user_id
+		 WHERE g.file_id = $1
+		 ORDER BY g.created_at`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []models.FileGrant
+	for rows.Next() {
+		var grant models.FileGrant
+		if err := rows.Scan(&grant.FileID, &grant.UserID, &grant.Username, &grant.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+func (r *SQLFileRepository) HasGrant(ctx context.Context, fileID, userID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM file_grants WHERE file_id = $1 AND user_id = $2)`,
+		fileID, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check grant: %w", err)
+	}
+
+	return exists, nil
+}
+
+func scanFile(s scanner) (*models.File, error) {
+	var file models.File
+	var folderID sql.NullString
+	var deletedAt, retainUntil sql.NullTime
+	err := s.Scan(
+		&file.ID,
+		&file.FileName,
+		&file.StorageKey,
+		&file.FileSize,
+		&file.ContentType,
+		&file.SHA256,
+		&file.OwnerID,
+		&folderID,
+		&file.Version,
+		&file.CreatedAt,
+		&file.UpdatedAt,
+		&deletedAt,
+		&retainUntil,
+		&file.LegalHold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	file.FolderID = folderID.String
+	if deletedAt.Valid {
+		file.DeletedAt = &deletedAt.Time
+	}
+	if retainUntil.Valid {
+		file.RetainUntil = &retainUntil.Time
+	}
+
+	return &file, nil
+}
+
+func sortColumn(sort FileSort) (string, error) {
+	switch sort {
+	case FileSortName:
+		return "file_name", nil
+	case FileSortSize:
+		return "file_size", nil
+	case FileSortDate:
+		return "created_at", nil
+	default:
+		return "", fmt.Errorf("unknown sort %q", sort)
+	}
+}
+
+func encodeCursor(order string, last *models.File) string {
+	cursor := fileCursor{Order: order, ID: last.ID}
+	switch FileSort(strings.Fields(order)[0]) {
+	case FileSortName:
+		cursor.Value = last.FileName
+	case FileSortSize:
+		cursor.Value = strconv.FormatInt(last.FileSize, 10)
+	case FileSortDate:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor also returns the cursor's sort value as the type its column
+// is compared with
+func decodeCursor(encoded string, sort FileSort) (*fileCursor, any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cursor fileCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, nil, err
+	}
+
+	switch sort {
+	case FileSortSize:
+		size, err := strconv.ParseInt(cursor.Value, 10, 64)
+		return &cursor, size, err
+	case FileSortDate:
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		return &cursor, createdAt.UTC(), err
+	default:
+		return &cursor, cursor.Value, nil
+	}
+}
+
+// escapeLike makes LIKE match s literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func scanVersion(s scanner) (*models.FileVersion, error) {
+	var version models.FileVersion
+	var storageVersionID, uploadedBy, uploaderName sql.NullString
+	err := s.Scan(
+		&version.FileID,
+		&version.Version,
+		&version.StorageKey,
+		&storageVersionID,
+		&version.FileSize,
+		&version.ContentType,
+		&version.SHA256,
+		&uploadedBy,
+		&uploaderName,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	version.StorageVersionID = storageVersionID.String
+	version.UploadedBy = uploadedBy.String
+	version.UploaderName = uploaderName.String
+
+	return &version, nil
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// ErrFolderCycle is returned when a folder would be moved into itself or
+// one of its own subfolders
+var ErrFolderCycle = errors.New("folder cannot be moved inside itself")
+
+// FolderRepository stores the folder tree
+type FolderRepository interface {
+	Create(ctx context.Context, folder *models.Folder) error
+	GetByID(ctx context.Context, id string) (*models.Folder, error)
+	// ListChildren returns the folders directly inside parentID, or at the
+	// owner's root when parentID is empty, sorted by name
+	ListChildren(ctx context.Context, ownerID, parentID string) ([]models.Folder, error)
+	Rename(ctx context.Context, id, name string, now time.Time) error
+	// Move puts a folder under another parent; an empty parentID is the root
+	Move(ctx context.Context, id, parentID string, now time.Time) error
+	// Tree returns a folder and everything below it
+	Tree(ctx context.Context, id string) ([]models.Folder, error)
+	// Ancestors returns a folder and every folder above it
+	Ancestors(ctx context.Context, id string) ([]models.Folder, error)
+	// SetHold replaces a folder's retention and legal hold
+	SetHold(ctx context.Context, id string, hold models.Hold) error
+	// Delete removes a folder along with its subfolders
+	Delete(ctx context.Context, id string) error
+}
+
+type SQLFolderRepository struct {
+	db *sql.DB
+}
+
+func NewFolderRepository(db *sql.DB) FolderRepository {
+	return &SQLFolderRepository{
+		db: db,
+	}
+}
+
+const folderColumns = `id, owner_id, parent_id, name, created_at, updated_at, retain_until, legal_hold`
+
+func (r *SQLFolderRepository) Create(ctx context.Context, folder *models.Folder) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO folders (`+folderColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		folder.ID,
+		folder.OwnerID,
+		nullString(folder.ParentID),
+		folder.Name,
+		folder.CreatedAt.UTC(),
+		folder.UpdatedAt.UTC(),
+		nullTime(folder.RetainUntil),
+		folder.LegalHold,
+	)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert folder: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFolderRepository) GetByID(ctx context.Context, id string) (*models.Folder, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+folderColumns+` FROM folders WHERE id = $1`, id)
+
+	folder, err := scanFolder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+
+	return folder, nil
+}
+
+func (r *SQLFolderRepository) ListChildren(ctx context.Context, ownerID, parentID string) ([]models.Folder, error) {
+	var rows *sql.Rows
+	var err error
+	if parentID == "" {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT `+folderColumns+` FROM folders
+			 WHERE owner_id = $1 AND parent_id IS NULL
+			 ORDER BY name, id`, ownerID)
+	} else {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT `+folderColumns+` FROM folders
+			 WHERE owner_id = $1 AND parent_id = $2
+			 ORDER BY name, id`, ownerID, parentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	return scanFolders(rows)
+}
+
+func (r *SQLFolderRepository) Rename(ctx context.Context, id, name string, now time.Time) error {
+	return folderUpdated(r.db.ExecContext(ctx,
+		`UPDATE folders SET name = $1, updated_at = $2 WHERE id = $3`,
+		name, now.UTC(), id))
+}
+
+func (r *SQLFolderRepository) Move(ctx context.Context, id, parentID string, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if parentID != "" {
+		// Walk up from the new parent; meeting the folder means a cycle
+		var cycle bool
+		err := tx.QueryRowContext(ctx,
+			`WITH RECURSIVE ancestors (id, parent_id) AS (
+			     SELECT id, parent_id FROM folders WHERE id = $1
+			     UNION ALL
+			     SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+			 )
+			 SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`,
+			parentID, id).Scan(&cycle)
+		if err != nil {
+			return fmt.Errorf("failed to check folder ancestry: %w", err)
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+
+	if err := folderUpdated(tx.ExecContext(ctx,
+		`UPDATE folders SET parent_id = $1, updated_at = $2 WHERE id = $3`,
+		nullString(parentID), now.UTC(), id)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit folder move: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLFolderRepository) Tree(ctx context.Context, id string) ([]models.Folder, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`WITH RECURSIVE tree (id) AS (
+		     SELECT id FROM folders WHERE id = $1
+		     UNION ALL
+		     SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id
+		 )
+		 SELECT `+folderColumns+` FROM folders WHERE id IN (SELECT id FROM tree)`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder tree: %w", err)
+	}
+
+	return scanFolders(rows)
+}
+
+func (r *SQLFolderRepository) Ancestors(ctx context.Context, id string) ([]models.Folder, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`WITH RECURSIVE ancestors (id, parent_id) AS (
+		     SELECT id, parent_id FROM folders WHERE id = $1
+		     UNION ALL
+		     SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+		 )
+		 SELECT `+folderColumns+` FROM folders WHERE id IN (SELECT id FROM ancestors)`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder ancestors: %w", err)
+	}
+
+	return scanFolders(rows)
+}
+
+func (r *SQLFolderRepository) SetHold(ctx context.Context, id string, hold models.Hold) error {
+	return folderUpdated(r.db.ExecContext(ctx,
+		`UPDATE folders SET retain_until = $1, legal_hold = $2 WHERE id = $3`,
+		nullTime(hold.RetainUntil), hold.LegalHold, id))
+}
+
+func (r *SQLFolderRepository) Delete(ctx context.Context, id string) error {
+	return folderUpdated(r.db.ExecContext(ctx, `DELETE FROM folders WHERE id = $1`, id))
+}
+
+// folderUpdated maps the result of a single row write, treating a sibling name
+// clash as ErrConflict and a missing row as ErrNotFound
+func folderUpdated(result sql.Result, err error) error {
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update folder: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update folder: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// scanFolders reads every row of a folder query and closes it
+func scanFolders(rows *sql.Rows) ([]models.Folder, error) {
+	defer rows.Close()
+
+	var folders []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, *folder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	return folders, nil
+}
+
+func scanFolder(s scanner) (*models.Folder, error) {
+	var folder models.Folder
+	var parentID sql.NullString
+	var retainUntil sql.NullTime
+	err := s.Scan(
+		&folder.ID,
+		&folder.OwnerID,
+		&parentID,
+		&folder.Name,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+		&retainUntil,
+		&folder.LegalHold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	folder.ParentID = parentID.String
+	if retainUntil.Valid {
+		folder.RetainUntil = &retainUntil.Time
+	}
+
+	return &folder, nil
+}
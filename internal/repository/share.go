@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// ShareRepository stores public share links
+type ShareRepository interface {
+	Create(ctx context.Context, link *models.ShareLink) error
+	GetByID(ctx context.Context, id string) (*models.ShareLink, error)
+	GetByTokenHash(ctx context.Context, tokenHash string) (*models.ShareLink, error)
+	ListByFile(ctx context.Context, fileID string) ([]models.ShareLink, error)
+	Revoke(ctx context.Context, id string) error
+	// ConsumeDownload counts one download against the link. It returns
+	// ErrNotFound when the link is revoked, expired or out of downloads, so
+	// concurrent requests can never exceed the limit.
+	ConsumeDownload(ctx context.Context, id string, now time.Time) error
+}
+
+type SQLShareRepository struct {
+	db *sql.DB
+}
+
+func NewShareRepository(db *sql.DB) ShareRepository {
+	return &SQLShareRepository{
+		db: db,
+	}
+}
+
+const shareColumns = `id, file_id, created_by, token_hash, password_hash, expires_at, max_downloads, download_count, revoked_at, created_at`
+
+func (r *SQLShareRepository) Create(ctx context.Context, link *models.ShareLink) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO share_links (`+shareColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		link.ID,
+		link.FileID,
+		link.CreatedBy,
+		link.TokenHash,
+		nullString(link.PasswordHash),
+		nullTime(link.ExpiresAt),
+		nullInt(link.MaxDownloads),
+		link.DownloadCount,
+		nullTime(link.RevokedAt),
+		link.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert share link: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLShareRepository) GetByID(ctx context.Context, id string) (*models.ShareLink, error) {
+	return r.getOne(ctx, `SELECT `+shareColumns+` FROM share_links WHERE id = $1`, id)
+}
+
+func (r *SQLShareRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.ShareLink, error) {
+	return r.getOne(ctx, `SELECT `+shareColumns+` FROM share_links WHERE token_hash = $1`, tokenHash)
+}
+
+func (r *SQLShareRepository) ListByFile(ctx context.Context, fileID string) ([]models.ShareLink, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+shareColumns+` FROM share_links WHERE file_id = $1 ORDER BY created_at DESC`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []models.ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan share link: %w", err)
+		}
+		links = append(links, *link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+
+	return links, nil
+}
+
+func (r *SQLShareRepository) Revoke(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE share_links SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLShareRepository) ConsumeDownload(ctx context.Context, id string, now time.Time) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE share_links SET download_count = download_count + 1
+		 WHERE id = $1
+		   AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > $2)
+		   AND (max_downloads IS NULL OR download_count < max_downloads)`,
+		id, now.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *SQLShareRepository) getOne(ctx context.Context, query string, args ...any) (*models.ShareLink, error) {
+	link, err := scanShareLink(r.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+
+	return link, nil
+}
+
+func scanShareLink(s scanner) (*models.ShareLink, error) {
+	var link models.ShareLink
+	var passwordHash sql.NullString
+	var expiresAt, revokedAt sql.NullTime
+	var maxDownloads sql.NullInt64
+
+	err := s.Scan(
+		&link.ID,
+		&link.FileID,
+		&link.CreatedBy,
+		&link.TokenHash,
+		&passwordHash,
+		&expiresAt,
+		&maxDownloads,
+		&link.DownloadCount,
+		&revokedAt,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	link.PasswordHash = passwordHash.String
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if maxDownloads.Valid {
+		limit := int(maxDownloads.Int64)
+		link.MaxDownloads = &limit
+	}
+	if revokedAt.Valid {
+		link.RevokedAt = &revokedAt.Time
+	}
+
+	return &link, nil
+}
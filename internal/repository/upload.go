@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// UploadRepository tracks resumable uploads until they become files
+type UploadRepository interface {
+	Create(ctx context.Context, upload *models.Upload) error
+	GetByID(ctx context.Context, id string) (*models.Upload, error)
+	SetMultipartID(ctx context.Context, id, multipartID string) error
+	// UpdateOffset saves how far the upload has got, along with the state of
+	// the hash of everything before offset
+	UpdateOffset(ctx context.Context, id string, offset int64, hashState []byte) error
+	// AddPart records a stored part and the upload's new offset and hash
+	// state together
+	AddPart(ctx context.Context, part *models.UploadPart, offset int64, hashState []byte) error
+	ListParts(ctx context.Context, uploadID string) ([]models.UploadPart, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type SQLUploadRepository struct {
+	db *sql.DB
+}
+
+func NewUploadRepository(db *sql.DB) UploadRepository {
+	return &SQLUploadRepository{
+		db: db,
+	}
+}
+
+const uploadColumns = `id, owner_id, file_name, content_type, storage_key, mode, multipart_id, upload_length, upload_offset, part_size, hash_state, created_at, updated_at`
+
+func (r *SQLUploadRepository) Create(ctx context.Context, upload *models.Upload) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO uploads (`+uploadColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		upload.ID,
+		upload.OwnerID,
+		upload.FileName,
+		upload.ContentType,
+		upload.StorageKey,
+		upload.Mode,
+		nullString(upload.MultipartID),
+		upload.Length,
+		upload.Offset,
+		upload.PartSize,
+		base64.StdEncoding.EncodeToString(upload.HashState),
+		upload.CreatedAt.UTC(),
+		upload.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert upload: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLUploadRepository) GetByID(ctx context.Context, id string) (*models.Upload, error) {
+	upload, err := scanUpload(r.db.QueryRowContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+func (r *SQLUploadRepository) SetMultipartID(ctx context.Context, id, multipartID string) error {
+	return r.update(ctx,
+		`UPDATE uploads SET multipart_id = $1, updated_at = $2 WHERE id = $3`,
+		multipartID, time.Now().UTC(), id)
+}
+
+func (r *SQLUploadRepository) UpdateOffset(ctx context.Context, id string, offset int64, hashState []byte) error {
+	return r.update(ctx,
+		`UPDATE uploads SET upload_offset = $1, hash_state = $2, updated_at = $3 WHERE id = $4`,
+		offset, base64.StdEncoding.EncodeToString(hashState), time.Now().UTC(), id)
+}
+
+func (r *SQLUploadRepository) AddPart(ctx context.Context, part *models.UploadPart, offset int64, hashState []byte) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO upload_parts (upload_id, part_number, etag, size) VALUES ($1, $2, $3, $4)`,
+		part.UploadID, part.PartNumber, part.ETag, part.Size)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to insert upload part: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE uploads SET upload_offset = $1, hash_state = $2, updated_at = $3 WHERE id = $4`,
+		offset, base64.StdEncoding.EncodeToString(hashState), time.Now().UTC(), part.UploadID)
+	if err != nil {
+		return fmt.Errorf("failed to update upload offset: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upload part: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLUploadRepository) ListParts(ctx context.Context, uploadID string) ([]models.UploadPart, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT upload_id, part_number, etag, size FROM upload_parts WHERE upload_id = $1 ORDER BY part_number`,
+		uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upload parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []models.UploadPart
+	for rows.Next() {
+		var part models.UploadPart
+		if err := rows.Scan(&part.UploadID, &part.PartNumber, &part.ETag, &part.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan upload part: %w", err)
+		}
+		parts = append(parts, part)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list upload parts: %w", err)
+	}
+
+	return parts, nil
+}
+
+func (r *SQLUploadRepository) Delete(ctx context.Context, id string) error {
+	return r.update(ctx, `DELETE FROM uploads WHERE id = $1`, id)
+}
+
+// update runs a single-row write and maps "no rows" to ErrNotFound
+func (r *SQLUploadRepository) update(ctx context.Context, query string, args ...any) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func scanUpload(s scanner) (*models.Upload, error) {
+	var upload models.Upload
+	var multipartID sql.NullString
+	var hashState string
+
+	err := s.Scan(
+		&upload.ID,
+		&upload.OwnerID,
+		&upload.FileName,
+		&upload.ContentType,
+		&upload.StorageKey,
+		&upload.Mode,
+		&multipartID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.PartSize,
+		&hashState,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	upload.MultipartID = multipartID.String
+	upload.HashState, err = base64.StdEncoding.DecodeString(hashState)
+	if err != nil {
+		return nil, fmt.Errorf("malformed hash state: %w", err)
+	}
+	return &upload, nil
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// UserRepository stores user accounts in the catalog
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	// CreateFirst inserts user only if no account was ever created before,
+	// deciding both in one transaction. It reports whether user was inserted.
+	CreateFirst(ctx context.Context, user *models.User) (bool, error)
+	GetByID(ctx context.Context, id string) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	List(ctx context.Context) ([]models.User, error)
+	// UpdateRole changes a user's role unless that would leave no admin,
+	// deciding both in one transaction. It reports whether the role changed.
+	UpdateRole(ctx context.Context, id string, role models.Role) (bool, error)
+}
+
+type SQLUserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &SQLUserRepository{
+		db: db,
+	}
+}
+
+const userColumns = `id, username, password_hash, role, created_at, updated_at`
+
+func (r *SQLUserRepository) Create(ctx context.Context, user *models.User) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		user.ID,
+		user.Username,
+		user.PasswordHash,
+		user.Role,
+		user.CreatedAt.UTC(),
+		user.UpdatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLUserRepository) CreateFirst(ctx context.Context, user *models.User) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A concurrent signup waits here until the first one commits
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO first_user (id, user_id, created_at) VALUES (1, $1, $2)`,
+		user.ID, user.CreatedAt.UTC())
+	if isUniqueViolation(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim first user: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		user.ID,
+		user.Username,
+		user.PasswordHash,
+		user.Role,
+		user.CreatedAt.UTC(),
+		user.UpdatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return false, ErrConflict
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *SQLUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+}
+
+func (r *SQLUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username)
+}
+
+func (r *SQLUserRepository) List(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (r *SQLUserRepository) UpdateRole(ctx context.Context, id string, role models.Role) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Touching every admin row first makes a concurrent role change wait
+	// here until this one commits, so the count below can't be stale
+	_, err = tx.ExecContext(ctx, `UPDATE users SET role = role WHERE role = $1`, models.RoleAdmin)
+	if err != nil {
+		return false, fmt.Errorf("failed to update role: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET role = $1, updated_at = $2
+		 WHERE id = $3 AND (role <> $4 OR $1 = $4 OR (SELECT COUNT(*) FROM users WHERE role = $4) > 1)`,
+		role, time.Now().UTC(), id, models.RoleAdmin)
+	if err != nil {
+		return false, fmt.Errorf("failed to update role: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update role: %w", err)
+	}
+	if affected == 0 {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("failed to update role: %w", err)
+		}
+		if !exists {
+			return false, ErrNotFound
+		}
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *SQLUserRepository) getOne(ctx context.Context, query string, args ...any) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func scanUser(s scanner) (*models.User, error) {
+	var user models.User
+	err := s.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
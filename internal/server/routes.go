@@ -3,24 +3,87 @@ package server
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
 	"github.com/okoye-dev/oss-archive/internal/handlers"
+	"github.com/okoye-dev/oss-archive/internal/middleware"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
 	"github.com/okoye-dev/oss-archive/internal/storage"
 )
 
-func SetupRoutes(router *gin.Engine, storage storage.StorageInterface) {
+// Dependencies are the shared services the routes are built from
+type Dependencies struct {
+	Storage   storage.StorageInterface
+	Files     repository.FileRepository
+	Users     repository.UserRepository
+	Auth      *services.AuthService
+	UserSvc   *services.UserService
+	Shares    *services.ShareService
+	Uploads   *services.UploadService
+	Folders   *services.FolderService
+	Blobs     *services.BlobService
+	Versions  *services.VersionService
+	Trash     *services.TrashService
+	Holds     *services.HoldService
+	Tokens    *auth.TokenManager
+	Policy    *policy.FilePolicy
+	PublicURL string // base for share links and upload URLs; derived from the request when empty
+	Presign   handlers.PresignExpiry
+}
+
+func SetupRoutes(router *gin.Engine, deps *Dependencies) {
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders: []string{
+			"Origin", "Content-Type", "Accept", "Authorization", "X-Share-Password", "X-Checksum-SHA256",
+			"Range", "If-None-Match", "If-Modified-Since", "If-Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Defer-Length",
+		},
+		ExposeHeaders: []string{
+			"Content-Length", "Location", "ETag",
+			"Content-Range", "Accept-Ranges", "Content-Disposition", "Last-Modified",
+			"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length",
+		},
 		AllowCredentials: false,
 	}))
 
 	api := router.Group("/api/v1")
+	authHandler := handlers.NewAuthHandler(deps.Auth)
+	fileHandler := handlers.NewFileHandler(deps.Storage, deps.Files, deps.Users, deps.Blobs, deps.Folders, deps.Trash, deps.Policy, deps.Presign)
+	folderHandler := handlers.NewFolderHandler(fileHandler, deps.Folders, deps.Policy)
+	versionHandler := handlers.NewVersionHandler(fileHandler, deps.Versions)
+	trashHandler := handlers.NewTrashHandler(fileHandler, deps.Trash, deps.Policy)
+	holdHandler := handlers.NewHoldHandler(fileHandler, deps.Folders, deps.Holds, deps.Policy)
+	shareHandler := handlers.NewShareHandler(fileHandler, deps.Shares, deps.PublicURL)
+	tusHandler := handlers.NewTusHandler(deps.Uploads, deps.Policy, deps.PublicURL)
+	directUploadHandler := handlers.NewDirectUploadHandler(deps.Uploads, deps.Policy)
+	requireAuth := middleware.RequireAuth(deps.Tokens, deps.Users)
 	
+	// Public routes
 	setupHealthRoutes(api)
-	setupUserRoutes(api)
-	setupFileRoutes(api, storage)
+	setupAuthRoutes(api, authHandler)
+	setupPublicShareRoutes(router, shareHandler)
+	if local, ok := storage.Unwrap(deps.Storage).(*storage.LocalStorage); ok {
+		setupLocalStorageRoutes(router, handlers.NewLocalStorageHandler(local))
+	}
+
+	// Everything below requires a bearer access token
+	protected := api.Group("")
+	protected.Use(requireAuth)
+
+	userHandler := handlers.NewUserHandler(deps.Users, deps.UserSvc)
+
+	setupProfileRoutes(protected, authHandler)
+	setupUserRoutes(protected, userHandler)
+	setupFileRoutes(protected, fileHandler, shareHandler, folderHandler, versionHandler)
+	setupFolderRoutes(protected, folderHandler)
+	setupTrashRoutes(protected, trashHandler)
+	setupDirectUploadRoutes(protected, directUploadHandler)
+	setupAdminRoutes(protected, userHandler, fileHandler, holdHandler)
+	setupUploadRoutes(api, tusHandler, requireAuth)
 }
 
 func setupHealthRoutes(rg *gin.RouterGroup) {
@@ -28,17 +91,108 @@ func setupHealthRoutes(rg *gin.RouterGroup) {
 	health.GET("", handlers.HealthHandler)
 }
 
-func setupUserRoutes(rg *gin.RouterGroup) {
+func setupAuthRoutes(rg *gin.RouterGroup, authHandler *handlers.AuthHandler) {
+	authRoutes := rg.Group("/auth")
+	authRoutes.POST("/signup", authHandler.Signup)
+	authRoutes.POST("/signin", authHandler.Signin)
+	authRoutes.POST("/refresh", authHandler.Refresh)
+}
+
+// setupPublicShareRoutes serves share links outside /api/v1 so the URLs stay short
+func setupPublicShareRoutes(router *gin.Engine, shareHandler *handlers.ShareHandler) {
+	router.GET("/s/:token", shareHandler.OpenShare)
+	router.POST("/s/:token", shareHandler.OpenShare)
+}
+
+// setupLocalStorageRoutes serves signed object URLs when files are kept on
+// local disk instead of in a bucket
+func setupLocalStorageRoutes(router *gin.Engine, storageHandler *handlers.LocalStorageHandler) {
+	objects := router.Group(storage.LocalRoutePrefix)
+	objects.GET("/*key", storageHandler.Download)
+	objects.HEAD("/*key", storageHandler.Download)
+	objects.PUT("/*key", storageHandler.UploadPart)
+}
+
+func setupProfileRoutes(rg *gin.RouterGroup, authHandler *handlers.AuthHandler) {
+	rg.GET("/profile", authHandler.Profile)
+}
+
+func setupUserRoutes(rg *gin.RouterGroup, userHandler *handlers.UserHandler) {
 	users := rg.Group("/users")
-	users.POST("", handlers.CreateUser)
+	users.GET("/:id", userHandler.GetUser)
 }
 
-func setupFileRoutes(rg *gin.RouterGroup, storage storage.StorageInterface) {
-	fileHandler := handlers.NewFileHandler(storage)
-	
+func setupFileRoutes(rg *gin.RouterGroup, fileHandler *handlers.FileHandler, shareHandler *handlers.ShareHandler, folderHandler *handlers.FolderHandler, versionHandler *handlers.VersionHandler) {
 	files := rg.Group("/files")
 	files.GET("", fileHandler.GetFiles)
 	files.POST("", fileHandler.UploadFile)
 	files.GET("/:id", fileHandler.GetFile)
+	files.GET("/:id/content", fileHandler.GetContent)
+	files.HEAD("/:id/content", fileHandler.GetContent)
+	files.PATCH("/:id", folderHandler.MoveFile)
 	files.DELETE("/:id", fileHandler.DeleteFile)
-}
\ No newline at end of file
+	files.GET("/:id/grants", fileHandler.ListGrants)
+	files.POST("/:id/grants", fileHandler.AddGrant)
+	files.DELETE("/:id/grants/:userId", fileHandler.RemoveGrant)
+	files.GET("/:id/shares", shareHandler.ListShares)
+	files.POST("/:id/shares", shareHandler.CreateShare)
+	files.DELETE("/:id/shares/:shareId", shareHandler.RevokeShare)
+	files.GET("/:id/versions", versionHandler.ListVersions)
+	files.POST("/:id/versions", versionHandler.UploadVersion)
+	files.GET("/:id/versions/:version", versionHandler.GetVersion)
+	files.GET("/:id/versions/:version/content", versionHandler.GetVersionContent)
+	files.HEAD("/:id/versions/:version/content", versionHandler.GetVersionContent)
+	files.POST("/:id/versions/:version/restore", versionHandler.RestoreVersion)
+}
+
+// setupFolderRoutes manages the folder tree; "root" stands for the caller's
+// root in /folders/:id/children
+func setupFolderRoutes(rg *gin.RouterGroup, folderHandler *handlers.FolderHandler) {
+	folders := rg.Group("/folders")
+	folders.POST("", folderHandler.CreateFolder)
+	folders.GET("/:id", folderHandler.GetFolder)
+	folders.GET("/:id/children", folderHandler.ListChildren)
+	folders.PATCH("/:id", folderHandler.UpdateFolder)
+	folders.DELETE("/:id", folderHandler.DeleteFolder)
+}
+
+// setupTrashRoutes serves the caller's deleted files until they are purged
+func setupTrashRoutes(rg *gin.RouterGroup, trashHandler *handlers.TrashHandler) {
+	trash := rg.Group("/trash")
+	trash.GET("", trashHandler.ListTrash)
+	trash.POST("/:id/restore", trashHandler.RestoreFile)
+	trash.DELETE("/:id", trashHandler.DeleteFile)
+}
+
+// setupUploadRoutes exposes the tus endpoint. OPTIONS is left public so
+// clients can discover the protocol before authenticating.
+func setupUploadRoutes(rg *gin.RouterGroup, tusHandler *handlers.TusHandler, requireAuth gin.HandlerFunc) {
+	uploads := rg.Group("/uploads", tusHandler.Resumable)
+	uploads.OPTIONS("", tusHandler.Options)
+	uploads.OPTIONS("/:id", tusHandler.Options)
+
+	authed := uploads.Group("", requireAuth)
+	authed.POST("", tusHandler.CreateUpload)
+	authed.HEAD("/:id", tusHandler.GetUpload)
+	authed.PATCH("/:id", tusHandler.PatchUpload)
+	authed.DELETE("/:id", tusHandler.DeleteUpload)
+}
+
+func setupDirectUploadRoutes(rg *gin.RouterGroup, directUploadHandler *handlers.DirectUploadHandler) {
+	uploads := rg.Group("/multipart-uploads")
+	uploads.POST("", directUploadHandler.StartUpload)
+	uploads.POST("/:id/parts", directUploadHandler.PresignParts)
+	uploads.POST("/:id/complete", directUploadHandler.CompleteUpload)
+	uploads.DELETE("/:id", directUploadHandler.AbortUpload)
+}
+
+func setupAdminRoutes(rg *gin.RouterGroup, userHandler *handlers.UserHandler, fileHandler *handlers.FileHandler, holdHandler *handlers.HoldHandler) {
+	admin := rg.Group("/admin")
+	admin.Use(middleware.RequireRole(models.RoleAdmin))
+
+	admin.GET("/users", userHandler.ListUsers)
+	admin.PUT("/users/:id/role", userHandler.SetRole)
+	admin.GET("/files", fileHandler.GetAllFiles)
+	admin.PUT("/files/:id/hold", holdHandler.SetFileHold)
+	admin.PUT("/folders/:id/hold", holdHandler.SetFolderHold)
+}
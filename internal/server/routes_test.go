@@ -0,0 +1,2292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/config"
+	"github.com/okoye-dev/oss-archive/internal/database"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/transport/rest"
+)
+
+const testPassword = "correct-horse-battery"
+
+// newTestRouter wires the real routes to a throwaway SQLite catalog
+func newTestRouter(t *testing.T) (*gin.Engine, *Dependencies) {
+	t.Helper()
+	return newTestRouterWithStorage(t, func(*sql.DB) storage.StorageInterface {
+		return storage.NewMemoryStorage()
+	})
+}
+
+// newTestRouterWithStorage is newTestRouter with the storage newStorage
+// builds on the catalog
+func newTestRouterWithStorage(t *testing.T, newStorage func(db *sql.DB) storage.StorageInterface) (*gin.Engine, *Dependencies) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Driver: database.DriverSQLite,
+			Path:   filepath.Join(t.TempDir(), "catalog.db"),
+		},
+	}
+	db, err := database.Open(cfg)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := database.MigrateUp(context.Background(), db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	tokens, err := auth.NewTokenManager(strings.Repeat("s", 32), "test", time.Minute)
+	if err != nil {
+		t.Fatalf("token manager: %v", err)
+	}
+
+	users := repository.NewUserRepository(db)
+	files := repository.NewFileRepository(db)
+	memory := newStorage(db)
+	folders := repository.NewFolderRepository(db)
+	holds := services.NewHoldService(memory, files, folders)
+	blobs := services.NewBlobService(memory, repository.NewBlobRepository(db))
+	versions := services.NewVersionService(memory, files, blobs, holds)
+	trash := services.NewTrashService(versions, blobs, holds, files, folders, time.Hour)
+	deps := &Dependencies{
+		Storage:  memory,
+		Files:    files,
+		Users:    users,
+		Auth:     services.NewAuthService(users, repository.NewRefreshTokenRepository(db), tokens, time.Hour),
+		UserSvc:  services.NewUserService(users),
+		Shares:   services.NewShareService(repository.NewShareRepository(db), files),
+		Uploads:  services.NewUploadService(memory, repository.NewUploadRepository(db), files, blobs),
+		Folders:  services.NewFolderService(trash, holds, folders, files),
+		Blobs:    blobs,
+		Versions: versions,
+		Trash:    trash,
+		Holds:    holds,
+		Tokens:   tokens,
+		Policy:   policy.NewFilePolicy(files),
+	}
+
+	router := gin.New()
+	SetupRoutes(router, deps)
+	return router, deps
+}
+
+func doJSON(t *testing.T, router *gin.Engine, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// credentialKeys returns every JSON object key in v that names a credential
+func credentialKeys(v any) []string {
+	var found []string
+	switch value := v.(type) {
+	case map[string]any:
+		for key, child := range value {
+			lower := strings.ToLower(key)
+			if strings.Contains(lower, "password") || strings.Contains(lower, "hash") {
+				found = append(found, key)
+			}
+			found = append(found, credentialKeys(child)...)
+		}
+	case []any:
+		for _, child := range value {
+			found = append(found, credentialKeys(child)...)
+		}
+	}
+	return found
+}
+
+func TestUserEndpointsNeverLeakCredentials(t *testing.T) {
+	router, deps := newTestRouter(t)
+
+	signup := doJSON(t, router, http.MethodPost, "/api/v1/auth/signup", "", map[string]string{
+		"username": "alice",
+		"password": testPassword,
+	})
+	if signup.Code != http.StatusOK {
+		t.Fatalf("signup: got %d: %s", signup.Code, signup.Body)
+	}
+
+	var session struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(signup.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode signup: %v", err)
+	}
+
+	stored, err := deps.Users.GetByID(context.Background(), session.User.ID)
+	if err != nil {
+		t.Fatalf("load stored user: %v", err)
+	}
+
+	responses := map[string]*httptest.ResponseRecorder{
+		"signup": signup,
+		"signin": doJSON(t, router, http.MethodPost, "/api/v1/auth/signin", "", map[string]string{
+			"username": "alice",
+			"password": testPassword,
+		}),
+		"refresh": doJSON(t, router, http.MethodPost, "/api/v1/auth/refresh", "", map[string]string{
+			"refresh_token": session.RefreshToken,
+		}),
+		"profile":  doJSON(t, router, http.MethodGet, "/api/v1/profile", session.AccessToken, nil),
+		"get user": doJSON(t, router, http.MethodGet, "/api/v1/users/"+session.User.ID, session.AccessToken, nil),
+		// The first account is an admin, so it can list everyone
+		"list users": doJSON(t, router, http.MethodGet, "/api/v1/admin/users", session.AccessToken, nil),
+	}
+
+	for name, rec := range responses {
+		t.Run(name, func(t *testing.T) {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+
+			body := rec.Body.String()
+			if strings.Contains(body, stored.PasswordHash) {
+				t.Errorf("response contains the password hash: %s", body)
+			}
+			if strings.Contains(body, testPassword) {
+				t.Errorf("response contains the plaintext password: %s", body)
+			}
+
+			var decoded any
+			if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if keys := credentialKeys(decoded); len(keys) > 0 {
+				t.Errorf("response has credential fields %v: %s", keys, body)
+			}
+		})
+	}
+}
+
+func TestUserModelDoesNotMarshalPasswordHash(t *testing.T) {
+	_, deps := newTestRouter(t)
+
+	user, _, err := deps.Auth.Signup(context.Background(), "bob", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if strings.Contains(string(data), user.PasswordHash) {
+		t.Errorf("models.User marshals its password hash: %s", data)
+	}
+}
+
+func TestFileUploadDownloadDelete(t *testing.T) {
+	router, deps := newTestRouter(t)
+
+	_, tokens, err := deps.Auth.Signup(context.Background(), "carol", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	io.WriteString(part, "kept in memory")
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	upload := httptest.NewRecorder()
+	router.ServeHTTP(upload, req)
+	if upload.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", upload.Code, upload.Body)
+	}
+
+	var file struct {
+		ID         string `json:"id"`
+		StorageKey string `json:"storage_key"`
+		Size       int64  `json:"size"`
+	}
+	if err := json.Unmarshal(upload.Body.Bytes(), &file); err != nil {
+		t.Fatalf("decode upload: %v", err)
+	}
+	if file.Size != int64(len("kept in memory")) {
+		t.Errorf("upload reported size %d", file.Size)
+	}
+
+	stored, err := deps.Storage.GetFile(context.Background(), file.StorageKey)
+	if err != nil {
+		t.Fatalf("object was not stored: %v", err)
+	}
+	data, _ := io.ReadAll(stored)
+	stored.Close()
+	if string(data) != "kept in memory" {
+		t.Errorf("stored object = %q", data)
+	}
+
+	download := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID+"?download=true", tokens.AccessToken, nil)
+	if download.Code != http.StatusOK {
+		t.Fatalf("download: got %d: %s", download.Code, download.Body)
+	}
+	if !strings.Contains(download.Body.String(), `"download":true`) {
+		t.Errorf("download response = %s", download.Body)
+	}
+
+	remove := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+file.ID, tokens.AccessToken, nil)
+	if remove.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", remove.Code, remove.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID, tokens.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: got %d", rec.Code)
+	}
+
+	// The object is only removed once the file leaves the trash
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+file.ID, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete from trash: got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := deps.Storage.GetFileSize(context.Background(), file.StorageKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("object still stored after delete: %v", err)
+	}
+}
+
+func TestFilePresignOptions(t *testing.T) {
+	router, deps := newTestRouter(t)
+
+	_, tokens, err := deps.Auth.Signup(context.Background(), "sam", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, "data.bin", "1,2,3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+
+	link := func(query string) (fileDownload, *httptest.ResponseRecorder) {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID+query, tokens.AccessToken, nil)
+		var download fileDownload
+		if rec.Code == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), &download)
+		}
+		return download, rec
+	}
+
+	// expires_in reports the lifetime the URL was actually signed with
+	for query, want := range map[string]int{"": 3600, "?expires_in=120": 120} {
+		download, rec := link(query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("link%s: got %d: %s", query, rec.Code, rec.Body)
+		}
+		if download.ExpiresIn != want {
+			t.Errorf("link%s: expires_in = %d, want %d", query, download.ExpiresIn, want)
+		}
+		signed, _ := strconv.ParseInt(download.query(t).Get("expires"), 10, 64)
+		if left := time.Until(time.Unix(signed, 0)); left < time.Duration(want-5)*time.Second || left > time.Duration(want)*time.Second {
+			t.Errorf("link%s: signed to expire in %v, want %ds", query, left, want)
+		}
+	}
+
+	download, rec := link("?content_type=text/csv&filename=r%C3%A9sum%C3%A9.csv")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("overrides: got %d: %s", rec.Code, rec.Body)
+	}
+	query := download.query(t)
+	if got := query.Get("response-content-type"); got != "text/csv" {
+		t.Errorf("response-content-type = %q", got)
+	}
+	if got := query.Get("response-content-disposition"); got != `inline; filename="r_sum_.csv"; filename*=UTF-8''r%C3%A9sum%C3%A9.csv` {
+		t.Errorf("response-content-disposition = %q", got)
+	}
+
+	for _, bad := range []string{
+		"?expires_in=0",
+		"?expires_in=soon",
+		fmt.Sprintf("?expires_in=%d", int(storage.MaxPresignExpiry.Seconds())+1),
+		"?content_type=not%20a%20type",
+		"?filename=../etc/passwd",
+		"?filename=a%0Ab",
+	} {
+		if _, rec := link(bad); rec.Code != http.StatusBadRequest {
+			t.Errorf("link%s: got %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+// fileDownload is the body of GET /files/:id
+type fileDownload struct {
+	URL       string `json:"url"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+func (d fileDownload) query(t *testing.T) url.Values {
+	t.Helper()
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		t.Fatalf("download URL %q does not parse: %v", d.URL, err)
+	}
+	return u.Query()
+}
+
+func TestFileContent(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, owner, err := deps.Auth.Signup(ctx, "quinn", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, stranger, err := deps.Auth.Signup(ctx, "rafe", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	content := "the quick brown fox jumps over the lazy dog"
+	rec := doUpload(t, router, "/api/v1/files", owner.AccessToken, "fox.txt", content)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	path := "/api/v1/files/" + file.ID + "/content"
+
+	get := func(method, path, token string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	whole := get(http.MethodGet, path, owner.AccessToken, nil)
+	if whole.Code != http.StatusOK || whole.Body.String() != content {
+		t.Fatalf("content: got %d %q", whole.Code, whole.Body)
+	}
+	etag := whole.Header().Get("ETag")
+	if etag == "" || whole.Header().Get("Last-Modified") == "" || whole.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("validators missing: %v", whole.Header())
+	}
+	if got := whole.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "inline") || !strings.Contains(got, "fox.txt") {
+		t.Errorf("inline Content-Disposition = %q", got)
+	}
+	if got := get(http.MethodGet, path+"?download=true", owner.AccessToken, nil).Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+		t.Errorf("download Content-Disposition = %q", got)
+	}
+	if head := get(http.MethodHead, path, owner.AccessToken, nil); head.Code != http.StatusOK || head.Body.Len() != 0 || head.Header().Get("Content-Length") != fmt.Sprint(len(content)) {
+		t.Errorf("HEAD: got %d with %d bytes, Content-Length %q", head.Code, head.Body.Len(), head.Header().Get("Content-Length"))
+	}
+
+	single := get(http.MethodGet, path, owner.AccessToken, map[string]string{"Range": "bytes=4-8"})
+	if single.Code != http.StatusPartialContent || single.Body.String() != "quick" {
+		t.Errorf("single range: got %d %q", single.Code, single.Body)
+	}
+	if got := single.Header().Get("Content-Range"); got != fmt.Sprintf("bytes 4-8/%d", len(content)) {
+		t.Errorf("Content-Range = %q", got)
+	}
+	suffix := get(http.MethodGet, path, owner.AccessToken, map[string]string{"Range": "bytes=-3"})
+	if suffix.Code != http.StatusPartialContent || suffix.Body.String() != "dog" {
+		t.Errorf("suffix range: got %d %q", suffix.Code, suffix.Body)
+	}
+
+	multi := get(http.MethodGet, path, owner.AccessToken, map[string]string{"Range": "bytes=0-2,16-18"})
+	if multi.Code != http.StatusPartialContent || !strings.HasPrefix(multi.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Fatalf("multi-range: got %d %s", multi.Code, multi.Header().Get("Content-Type"))
+	}
+	if body := multi.Body.String(); !strings.Contains(body, "\r\nthe\r\n") || !strings.Contains(body, "\r\nfox\r\n") {
+		t.Errorf("multi-range body = %q", body)
+	}
+
+	if rec := get(http.MethodGet, path, owner.AccessToken, map[string]string{"Range": "bytes=100-200"}); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range: got %d", rec.Code)
+	}
+	if rec := get(http.MethodGet, path, owner.AccessToken, map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want 304", rec.Code)
+	}
+	later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if rec := get(http.MethodGet, path, owner.AccessToken, map[string]string{"If-Modified-Since": later}); rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d, want 304", rec.Code)
+	}
+
+	// A new version changes the validator, so a stale If-Range gets it all
+	if rec := doUpload(t, router, "/api/v1/files/"+file.ID+"/versions", owner.AccessToken, "fox.txt", "a new fox"); rec.Code != http.StatusOK {
+		t.Fatalf("upload version: got %d: %s", rec.Code, rec.Body)
+	}
+	stale := get(http.MethodGet, path, owner.AccessToken, map[string]string{"Range": "bytes=0-0", "If-Range": etag})
+	if stale.Code != http.StatusOK || stale.Body.String() != "a new fox" {
+		t.Errorf("stale If-Range: got %d %q", stale.Code, stale.Body)
+	}
+
+	if rec := get(http.MethodGet, path, stranger.AccessToken, nil); rec.Code == http.StatusOK {
+		t.Errorf("another user read the content: %q", rec.Body)
+	}
+}
+
+func TestFileListingPages(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	owner, tokens, err := deps.Auth.Signup(ctx, "erin", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	// Equal sizes and names check that ties are broken consistently
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"b.txt", "a.txt", "report-2.pdf", "Report-1.doc", "a.txt", "c_1.txt", "c%1.txt"}
+	for i, name := range names {
+		created := base.Add(time.Duration(i) * time.Minute)
+		err := deps.Files.Create(ctx, &models.File{
+			ID:          fmt.Sprintf("file-%d", i),
+			FileName:    name,
+			StorageKey:  fmt.Sprintf("users/%s/file-%d/%s", owner.ID, i, name),
+			FileSize:    int64(i % 3),
+			ContentType: "text/plain",
+			OwnerID:     owner.ID,
+			CreatedAt:   created,
+			UpdatedAt:   created,
+		})
+		if err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+	}
+
+	list := func(query string) []string {
+		t.Helper()
+		var ids []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(names) {
+				t.Fatalf("%s: listing never ended", query)
+			}
+			path := "/api/v1/files?limit=2&" + query
+			if cursor != "" {
+				path += "&cursor=" + cursor
+			}
+			rec := doJSON(t, router, http.MethodGet, path, tokens.AccessToken, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: got %d: %s", query, rec.Code, rec.Body)
+			}
+
+			var page struct {
+				Files []struct {
+					ID string `json:"id"`
+				} `json:"files"`
+				NextCursor string `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			for _, file := range page.Files {
+				ids = append(ids, file.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"file-6", "file-5", "file-4", "file-3", "file-2", "file-1", "file-0"}},
+		{"sort=date&order=asc", []string{"file-0", "file-1", "file-2", "file-3", "file-4", "file-5", "file-6"}},
+		{"sort=size", []string{"file-5", "file-2", "file-4", "file-1", "file-6", "file-3", "file-0"}},
+		{"sort=name&prefix=REPORT", []string{"file-3", "file-2"}},
+		{"sort=name&prefix=c_", []string{"file-5"}},
+		{"sort=name&prefix=c%25", []string{"file-6"}},
+	}
+	for _, tt := range tests {
+		if got := list(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Name order depends on the database collation, so only check that
+	// paging visits every file once
+	byName := list("sort=name")
+	slices.Sort(byName)
+	if want := []string{"file-0", "file-1", "file-2", "file-3", "file-4", "file-5", "file-6"}; !slices.Equal(byName, want) {
+		t.Errorf("sort=name visited %v", byName)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "sort=owner", "order=up", "cursor=not-a-cursor"} {
+		if rec := doJSON(t, router, http.MethodGet, "/api/v1/files?"+query, tokens.AccessToken, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestFolders(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	owner, tokens, err := deps.Auth.Signup(ctx, "frank", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "grace", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	createFolder := func(name, parentID string) string {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodPost, "/api/v1/folders", tokens.AccessToken, map[string]string{
+			"name":      name,
+			"parent_id": parentID,
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("create folder %q: got %d: %s", name, rec.Code, rec.Body)
+		}
+		var folder struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &folder)
+		return folder.ID
+	}
+
+	docs := createFolder("Docs", "")
+	archive := createFolder("Archive", docs)
+	deep := createFolder("2025", archive)
+
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/folders", tokens.AccessToken, map[string]string{"name": "Docs"}); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate sibling name: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/folders", tokens.AccessToken, map[string]string{"name": "a/b"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("name with a slash: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/folders/"+docs, other.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's folder: got %d", rec.Code)
+	}
+
+	// A folder can't go inside itself or its own subfolders
+	if rec := doJSON(t, router, http.MethodPatch, "/api/v1/folders/"+docs, tokens.AccessToken, map[string]string{"parent_id": deep}); rec.Code != http.StatusConflict {
+		t.Errorf("move into a descendant: got %d: %s", rec.Code, rec.Body)
+	}
+
+	file := &models.File{
+		ID:          "report",
+		FileName:    "report.txt",
+		StorageKey:  storage.UserKey(owner.ID, "report", "report.txt"),
+		FileSize:    4,
+		ContentType: "text/plain",
+		OwnerID:     owner.ID,
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if err := deps.Storage.UploadFile(ctx, file.StorageKey, strings.NewReader("data"), 4, file.ContentType); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := deps.Files.Create(ctx, file); err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+
+	move := doJSON(t, router, http.MethodPatch, "/api/v1/files/report", tokens.AccessToken, map[string]string{"folder_id": deep})
+	if move.Code != http.StatusOK {
+		t.Fatalf("move file: got %d: %s", move.Code, move.Body)
+	}
+	moved, err := deps.Files.GetByID(ctx, "report")
+	if err != nil {
+		t.Fatalf("load file: %v", err)
+	}
+	if moved.FolderID != deep || moved.StorageKey != file.StorageKey {
+		t.Errorf("moved file has folder %q and key %q; the key should not change", moved.FolderID, moved.StorageKey)
+	}
+
+	// Renaming and moving Archive to the root carries its contents along
+	rec := doJSON(t, router, http.MethodPatch, "/api/v1/folders/"+archive, tokens.AccessToken, map[string]string{"name": "Old", "parent_id": ""})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename and move folder: got %d: %s", rec.Code, rec.Body)
+	}
+
+	var root struct {
+		Folders []struct {
+			Name string `json:"name"`
+		} `json:"folders"`
+	}
+	rec = doJSON(t, router, http.MethodGet, "/api/v1/folders/root/children", tokens.AccessToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &root)
+	if len(root.Folders) != 2 || root.Folders[0].Name != "Docs" || root.Folders[1].Name != "Old" {
+		t.Errorf("root children = %s", rec.Body)
+	}
+
+	var children struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	rec = doJSON(t, router, http.MethodGet, "/api/v1/folders/"+deep+"/children", tokens.AccessToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &children)
+	if len(children.Files) != 1 || children.Files[0].ID != "report" {
+		t.Errorf("folder children = %s", rec.Body)
+	}
+
+	// Deleting a folder with contents needs confirmation
+	rec = doJSON(t, router, http.MethodDelete, "/api/v1/folders/"+archive, tokens.AccessToken, nil)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"folders":1,"files":1`) {
+		t.Fatalf("delete non-empty folder: got %d: %s", rec.Code, rec.Body)
+	}
+	rec = doJSON(t, router, http.MethodDelete, "/api/v1/folders/"+archive+"?recursive=true", tokens.AccessToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recursive delete: got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := deps.Files.GetByID(ctx, "report"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("file survived its folder: %v", err)
+	}
+	// Its files wait in the trash, and come back at the root as the folder is gone
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/trash/report/restore", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("restore file from deleted folder: got %d: %s", rec.Code, rec.Body)
+	}
+	if restored, err := deps.Files.GetByID(ctx, "report"); err != nil || restored.FolderID != "" {
+		t.Errorf("restored file = %+v, %v; want it at the root", restored, err)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/folders/"+deep, tokens.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("subfolder survived: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/folders/"+docs, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("delete empty folder: got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// doUpload posts content as the "file" field of a multipart form
+func doUpload(t *testing.T, router *gin.Engine, path, token, fileName, content string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	io.WriteString(part, content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFileVersions(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, tokens, err := deps.Auth.Signup(ctx, "heidi", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "ivan", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, "plan.txt", "first draft")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID      string `json:"id"`
+		Version int    `json:"version"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	if file.Version != 1 {
+		t.Errorf("new file is at version %d", file.Version)
+	}
+
+	versionsPath := "/api/v1/files/" + file.ID + "/versions"
+	if rec := doUpload(t, router, versionsPath, other.AccessToken, "plan.txt", "not mine"); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's upload: got %d", rec.Code)
+	}
+	rec = doUpload(t, router, versionsPath, tokens.AccessToken, "plan.txt", "second draft, longer")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload version: got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"version":2`) || !strings.Contains(rec.Body.String(), `"uploader":"heidi"`) {
+		t.Errorf("new version = %s", rec.Body)
+	}
+
+	var history struct {
+		Versions []struct {
+			Version  int    `json:"version"`
+			Size     int64  `json:"size"`
+			Uploader string `json:"uploader"`
+			Current  bool   `json:"current"`
+		} `json:"versions"`
+	}
+	rec = doJSON(t, router, http.MethodGet, versionsPath, tokens.AccessToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &history)
+	if len(history.Versions) != 2 ||
+		history.Versions[0].Version != 2 || !history.Versions[0].Current || history.Versions[0].Size != 20 ||
+		history.Versions[1].Version != 1 || history.Versions[1].Current || history.Versions[1].Uploader != "heidi" {
+		t.Fatalf("history = %s", rec.Body)
+	}
+
+	readCurrent := func() string {
+		t.Helper()
+		current, err := deps.Files.GetByID(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("load file: %v", err)
+		}
+		object, err := deps.Storage.GetFile(ctx, current.StorageKey)
+		if err != nil {
+			t.Fatalf("get object: %v", err)
+		}
+		defer object.Close()
+		data, _ := io.ReadAll(object)
+		return string(data)
+	}
+	if got := readCurrent(); got != "second draft, longer" {
+		t.Errorf("current content = %q", got)
+	}
+
+	if rec := doJSON(t, router, http.MethodGet, versionsPath+"/1", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("download version 1: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, versionsPath+"/9", tokens.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing version: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, versionsPath+"/latest", tokens.AccessToken, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("non-numeric version: got %d", rec.Code)
+	}
+
+	rec = doJSON(t, router, http.MethodPost, versionsPath+"/1/restore", tokens.AccessToken, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":3`) {
+		t.Fatalf("restore: got %d: %s", rec.Code, rec.Body)
+	}
+	if got := readCurrent(); got != "first draft" {
+		t.Errorf("content after restore = %q", got)
+	}
+
+	// Deleting the file for good removes the objects of every version
+	versions, err := deps.Files.ListVersions(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+file.ID, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+file.ID, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete from trash: got %d: %s", rec.Code, rec.Body)
+	}
+	for _, version := range versions {
+		if _, err := deps.Storage.GetFileSize(ctx, version.StorageKey); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("object of version %d survived: %v", version.Version, err)
+		}
+	}
+}
+
+func TestTrash(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, tokens, err := deps.Auth.Signup(ctx, "judy", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "mallory", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	upload := func(name string) (string, string) {
+		t.Helper()
+		rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, name, "contents of "+name)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %s: got %d: %s", name, rec.Code, rec.Body)
+		}
+		var file struct {
+			ID         string `json:"id"`
+			StorageKey string `json:"storage_key"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &file)
+		return file.ID, file.StorageKey
+	}
+	keep, _ := upload("keep.txt")
+	expire, expireKey := upload("expire.txt")
+
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+keep, tokens.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting a live file from the trash: got %d", rec.Code)
+	}
+
+	for _, id := range []string{keep, expire} {
+		if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+id, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("delete: got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+keep, tokens.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting a trashed file again: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files", tokens.AccessToken, nil); strings.Contains(rec.Body.String(), keep) {
+		t.Errorf("trashed file is still listed: %s", rec.Body)
+	}
+
+	var trash struct {
+		Files []struct {
+			ID      string    `json:"id"`
+			PurgeAt time.Time `json:"purge_at"`
+		} `json:"files"`
+	}
+	rec := doJSON(t, router, http.MethodGet, "/api/v1/trash", tokens.AccessToken, nil)
+	json.Unmarshal(rec.Body.Bytes(), &trash)
+	if len(trash.Files) != 2 || trash.Files[0].PurgeAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("trash = %s", rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/trash", other.AccessToken, nil); strings.Contains(rec.Body.String(), keep) {
+		t.Errorf("another user sees the trash: %s", rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/trash/"+keep+"/restore", other.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's restore: got %d", rec.Code)
+	}
+
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/trash/"+keep+"/restore", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("restore: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+keep, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("get after restore: got %d", rec.Code)
+	}
+
+	// Nothing has expired yet
+	if purged, err := deps.Trash.PurgeExpired(ctx, time.Now()); err != nil || purged != 0 {
+		t.Fatalf("early purge removed %d files: %v", purged, err)
+	}
+	purged, err := deps.Trash.PurgeExpired(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("purge removed %d files: %v", purged, err)
+	}
+	if _, err := deps.Files.GetTrashed(ctx, expire); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("purged file is still in the catalog: %v", err)
+	}
+	if _, err := deps.Storage.GetFileSize(ctx, expireKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("purged object is still stored: %v", err)
+	}
+	if _, err := deps.Files.GetByID(ctx, keep); err != nil {
+		t.Errorf("restored file was purged: %v", err)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	locks := newLockingStorage()
+	router, deps := newTestRouterWithStorage(t, func(*sql.DB) storage.StorageInterface { return locks })
+	ctx := context.Background()
+
+	// The first account is an admin
+	_, admin, err := deps.Auth.Signup(ctx, "kate", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, tokens, err := deps.Auth.Signup(ctx, "leo", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	upload := func(name string) string {
+		t.Helper()
+		rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, name, "contents of "+name)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %s: got %d: %s", name, rec.Code, rec.Body)
+		}
+		var file struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &file)
+		return file.ID
+	}
+	held := upload("contract.pdf")
+	filed := upload("minutes.txt")
+
+	rec := doJSON(t, router, http.MethodPost, "/api/v1/folders", tokens.AccessToken, map[string]string{"name": "Board"})
+	var folder struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &folder)
+	if rec := doJSON(t, router, http.MethodPatch, "/api/v1/files/"+filed, tokens.AccessToken, map[string]string{"folder_id": folder.ID}); rec.Code != http.StatusOK {
+		t.Fatalf("move into folder: got %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/files/"+held+"/hold", tokens.AccessToken, map[string]bool{"legal_hold": true}); rec.Code != http.StatusForbidden {
+		t.Errorf("member setting a hold: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/files/"+held+"/hold", admin.AccessToken, map[string]any{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty hold: got %d", rec.Code)
+	}
+	rec = doJSON(t, router, http.MethodPut, "/api/v1/admin/files/"+held+"/hold", admin.AccessToken, map[string]bool{"legal_hold": true})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"legal_hold":true`) {
+		t.Fatalf("legal hold: got %d: %s", rec.Code, rec.Body)
+	}
+	rec = doJSON(t, router, http.MethodPut, "/api/v1/admin/folders/"+folder.ID+"/hold", admin.AccessToken, map[string]int{"retain_days": 30})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"retain_until"`) {
+		t.Fatalf("folder retention: got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Nothing held can be deleted, overwritten or moved out of its folder
+	blocked := map[string]*httptest.ResponseRecorder{
+		"delete":           doJSON(t, router, http.MethodDelete, "/api/v1/files/"+held, tokens.AccessToken, nil),
+		"new version":      doUpload(t, router, "/api/v1/files/"+held+"/versions", tokens.AccessToken, "contract.pdf", "forged"),
+		"restore":          doJSON(t, router, http.MethodPost, "/api/v1/files/"+held+"/versions/1/restore", tokens.AccessToken, nil),
+		"delete in folder": doJSON(t, router, http.MethodDelete, "/api/v1/files/"+filed, tokens.AccessToken, nil),
+		"move out":         doJSON(t, router, http.MethodPatch, "/api/v1/files/"+filed, tokens.AccessToken, map[string]string{"folder_id": ""}),
+		"delete folder":    doJSON(t, router, http.MethodDelete, "/api/v1/folders/"+folder.ID+"?recursive=true", tokens.AccessToken, nil),
+		"admin delete":     doJSON(t, router, http.MethodDelete, "/api/v1/files/"+held, admin.AccessToken, nil),
+	}
+	for name, rec := range blocked {
+		if rec.Code != http.StatusConflict {
+			t.Errorf("%s while held: got %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	f, err := deps.Folders.Get(ctx, folder.ID)
+	if err != nil {
+		t.Fatalf("get folder: %v", err)
+	}
+	earlier := time.Now().Add(24 * time.Hour)
+	if err := deps.Holds.SetFolderHold(ctx, f, models.Hold{RetainUntil: &earlier}); !errors.Is(err, services.ErrRetentionShortened) {
+		t.Errorf("shortening retention: %v", err)
+	}
+
+	// Lifting the legal hold lets the file go, but a hold set on it in the
+	// trash keeps the purger away
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/files/"+held+"/hold", admin.AccessToken, map[string]bool{"legal_hold": false}); rec.Code != http.StatusOK {
+		t.Fatalf("lift legal hold: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+held, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete after lifting: got %d: %s", rec.Code, rec.Body)
+	}
+	if err := deps.Files.SetHold(ctx, held, models.Hold{LegalHold: true}); err != nil {
+		t.Fatalf("hold trashed file: %v", err)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+held, tokens.AccessToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("delete held file from trash: got %d", rec.Code)
+	}
+	if purged, err := deps.Trash.PurgeExpired(ctx, time.Now().Add(2*time.Hour)); err != nil || purged != 0 {
+		t.Errorf("purge removed %d held files: %v", purged, err)
+	}
+	if err := deps.Files.SetHold(ctx, held, models.Hold{}); err != nil {
+		t.Fatalf("release trashed file: %v", err)
+	}
+	if purged, err := deps.Trash.PurgeExpired(ctx, time.Now().Add(2*time.Hour)); err != nil || purged != 1 {
+		t.Errorf("purge after release removed %d files: %v", purged, err)
+	}
+
+	// Copies of the same content share an object, which stays held in the
+	// bucket until neither copy is
+	first, second := upload("copy.txt"), upload("copy.txt")
+	copied, err := deps.Files.GetByID(ctx, first)
+	if err != nil {
+		t.Fatalf("get copy: %v", err)
+	}
+	if other, _ := deps.Files.GetByID(ctx, second); other == nil || other.StorageKey != copied.StorageKey {
+		t.Fatalf("copies are stored apart")
+	}
+	setHold := func(id string, on bool) {
+		t.Helper()
+		if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/files/"+id+"/hold", admin.AccessToken, map[string]bool{"legal_hold": on}); rec.Code != http.StatusOK {
+			t.Fatalf("legal hold %v: got %d: %s", on, rec.Code, rec.Body)
+		}
+	}
+	setHold(first, true)
+	setHold(second, true)
+	setHold(first, false)
+	if !locks.legalHeld(copied.StorageKey) {
+		t.Error("lifting one copy's hold released the object the other still holds")
+	}
+	setHold(second, false)
+	if locks.legalHeld(copied.StorageKey) {
+		t.Error("the object is still held after both copies were released")
+	}
+}
+
+// lockingStorage is memory storage behind a bucket with Object Lock,
+// remembering the legal holds set on its objects
+type lockingStorage struct {
+	storage.StorageInterface
+
+	mu    sync.Mutex
+	holds map[string]bool
+}
+
+func newLockingStorage() *lockingStorage {
+	return &lockingStorage{
+		StorageInterface: storage.NewMemoryStorage(),
+		holds:            make(map[string]bool),
+	}
+}
+
+func (s *lockingStorage) ObjectLockEnabled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (s *lockingStorage) SetRetention(ctx context.Context, fileName, versionID string, until time.Time) error {
+	return nil
+}
+
+func (s *lockingStorage) SetLegalHold(ctx context.Context, fileName, versionID string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holds[fileName] = on
+	return nil
+}
+
+func (s *lockingStorage) legalHeld(fileName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holds[fileName]
+}
+
+func TestChecksums(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, tokens, err := deps.Auth.Signup(ctx, "mia", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	upload := func(name, content, checksum string) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		io.WriteString(part, content)
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		if checksum != "" {
+			req.Header.Set("X-Checksum-SHA256", checksum)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The digest of "intact", in hex and base64
+	const sum = "e6d7ddd8f414a22d8935148498c32ce0acdcf5c0c71db2455033f9be0a6cbc0a"
+	const sumBase64 = "5tfd2PQUoi2JNRSEmMMs4Kzc9cDHHbJFUDP5vgpsvAo="
+	if rec := upload("forged.txt", "forged", sum); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong digest: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := upload("bad.txt", "intact", "not-a-digest"); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed digest: got %d", rec.Code)
+	}
+	if objects, _ := deps.Storage.ListFiles(ctx, ""); len(objects) != 0 {
+		t.Errorf("rejected uploads left %d objects", len(objects))
+	}
+
+	var files [2]struct {
+		ID         string `json:"id"`
+		StorageKey string `json:"storage_key"`
+		SHA256     string `json:"sha256"`
+	}
+	lostSum, _, _ := storage.Checksum(strings.NewReader("lost"))
+	uploads := []struct{ name, content, header, sum string }{
+		{"kept.txt", "intact", sumBase64, sum},
+		{"lost.txt", "lost", "", lostSum}, // the header is optional
+	}
+	for i, u := range uploads {
+		rec := upload(u.name, u.content, u.header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %s: got %d: %s", u.name, rec.Code, rec.Body)
+		}
+		json.Unmarshal(rec.Body.Bytes(), &files[i])
+		if files[i].SHA256 != u.sum {
+			t.Errorf("%s: sha256 %q, want %q", u.name, files[i].SHA256, u.sum)
+		}
+	}
+	if stored, err := deps.Storage.(storage.ChecksumStorage).Checksum(ctx, files[0].StorageKey, ""); err != nil || stored != sum {
+		t.Errorf("object metadata: %q, %v", stored, err)
+	}
+
+	report, err := deps.Versions.Verify(ctx, func(problem services.ObjectProblem) {
+		t.Errorf("intact object reported %s: %s", problem.Problem, problem.Detail)
+	})
+	if err != nil || report.Checked != 2 {
+		t.Fatalf("verify: %+v, %v", report, err)
+	}
+
+	deps.Storage.UploadFile(ctx, files[0].StorageKey, strings.NewReader("tamper"), 6, "text/plain")
+	deps.Storage.DeleteFile(ctx, files[1].StorageKey)
+	found := map[string]string{}
+	report, err = deps.Versions.Verify(ctx, func(problem services.ObjectProblem) {
+		found[problem.Version.FileID] = problem.Problem
+	})
+	if err != nil || report.Problems != 2 {
+		t.Fatalf("verify after damage: %+v, %v", report, err)
+	}
+	if found[files[0].ID] != services.ObjectCorrupted || found[files[1].ID] != services.ObjectMissing {
+		t.Errorf("problems found: %v", found)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, ann, err := deps.Auth.Signup(ctx, "nia", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, ben, err := deps.Auth.Signup(ctx, "oto", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	upload := func(path, token, name string) string {
+		t.Helper()
+		rec := doUpload(t, router, path, token, name, "installer bytes")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %s: got %d: %s", name, rec.Code, rec.Body)
+		}
+		var file struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &file)
+		return file.ID
+	}
+	objects := func() []storage.ObjectInfo {
+		t.Helper()
+		objects, err := deps.Storage.ListFiles(ctx, "")
+		if err != nil {
+			t.Fatalf("list objects: %v", err)
+		}
+		return objects
+	}
+	purge := func(id, token string) {
+		t.Helper()
+		if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+id, token, nil); rec.Code != http.StatusOK {
+			t.Fatalf("delete %s: got %d: %s", id, rec.Code, rec.Body)
+		}
+		if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+id, token, nil); rec.Code != http.StatusOK {
+			t.Fatalf("delete %s from trash: got %d: %s", id, rec.Code, rec.Body)
+		}
+	}
+
+	// The same bytes uploaded three times, once as a new version, are
+	// stored once
+	first := upload("/api/v1/files", ann.AccessToken, "setup.exe")
+	second := upload("/api/v1/files", ben.AccessToken, "installer.exe")
+	upload("/api/v1/files/"+first+"/versions", ann.AccessToken, "setup.exe")
+	if stored := objects(); len(stored) != 1 || !strings.HasPrefix(stored[0].Key, "blobs/") {
+		t.Fatalf("stored objects: %+v", stored)
+	}
+
+	// Each copy is still downloaded under its own name
+	rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+second+"?download=true", ben.AccessToken, nil)
+	if !strings.Contains(rec.Body.String(), "installer.exe") {
+		t.Errorf("download of shared blob: %s", rec.Body)
+	}
+
+	// The blob outlives the first file and goes with the last
+	purge(first, ann.AccessToken)
+	if stored := objects(); len(stored) != 1 {
+		t.Fatalf("blob deleted while still in use: %+v", stored)
+	}
+	purge(second, ben.AccessToken)
+	if stored := objects(); len(stored) != 0 {
+		t.Errorf("blob kept after its last file went: %+v", stored)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	masterKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keyring, err := storage.NewKeyring(masterKey, nil)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	inner := storage.NewMemoryStorage()
+	var keys repository.DataKeyRepository
+	router, deps := newTestRouterWithStorage(t, func(db *sql.DB) storage.StorageInterface {
+		keys = repository.NewDataKeyRepository(db)
+		return storage.NewEncryptedStorage(inner, keys, keyring)
+	})
+
+	_, owner, err := deps.Auth.Signup(ctx, "pia", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	rec := doUpload(t, router, "/api/v1/files", owner.AccessToken, "diary.txt", "dear diary")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID   string `json:"id"`
+		Size int64  `json:"size"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	if file.Size != int64(len("dear diary")) {
+		t.Errorf("recorded size = %d, want the plaintext size", file.Size)
+	}
+
+	// The bucket only ever sees ciphertext, while reads are decrypted
+	objects, err := inner.ListFiles(ctx, "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("stored objects: %+v, %v", objects, err)
+	}
+	stored, err := inner.GetFile(ctx, objects[0].Key)
+	if err != nil {
+		t.Fatalf("read object: %v", err)
+	}
+	sealed, _ := io.ReadAll(stored)
+	if bytes.Contains(sealed, []byte("dear diary")) {
+		t.Fatal("object stored in plaintext")
+	}
+	report, err := deps.Versions.Verify(ctx, func(problem services.ObjectProblem) {
+		t.Errorf("verify: %s: %s", problem.Problem, problem.Detail)
+	})
+	if err != nil || report.Checked != 1 {
+		t.Fatalf("verify = %+v, %v", report, err)
+	}
+
+	// The plaintext checksum is kept with the data key, and verified too
+	sum, _, _ := storage.Checksum(strings.NewReader("dear diary"))
+	key, err := keys.GetDataKey(ctx, objects[0].Key)
+	if err != nil || key == nil || key.SHA256 != sum {
+		t.Fatalf("data key checksum: %+v, %v", key, err)
+	}
+	wrong := *key
+	wrong.SHA256 = strings.Repeat("0", 64)
+	if err := keys.PutDataKey(ctx, &wrong); err != nil {
+		t.Fatalf("put data key: %v", err)
+	}
+	found := 0
+	report, err = deps.Versions.Verify(ctx, func(problem services.ObjectProblem) {
+		if problem.Problem == services.ObjectCorrupted && strings.Contains(problem.Detail, "metadata") {
+			found++
+		}
+	})
+	if err != nil || report.Problems != 1 || found != 1 {
+		t.Errorf("verify with a mismatched checksum = %+v, %v", report, err)
+	}
+	if err := keys.PutDataKey(ctx, key); err != nil {
+		t.Fatalf("put data key: %v", err)
+	}
+
+	// The bucket would hand out ciphertext, so there are no presigned links
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID, owner.AccessToken, nil); rec.Code != http.StatusNotImplemented {
+		t.Errorf("presigned download: got %d, want 501: %s", rec.Code, rec.Body)
+	}
+
+	// ...so the content is streamed through the server, decrypted
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/files/"+file.ID+"/content", nil)
+	req.Header.Set("Authorization", "Bearer "+owner.AccessToken)
+	req.Header.Set("Range", "bytes=5-")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "diary" {
+		t.Errorf("ranged content: got %d %q, want 206 %q", rec.Code, rec.Body, "diary")
+	}
+
+	// Rotation re-wraps the data key with the new master key alone
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	rotating, err := storage.NewKeyring(newKey, []string{masterKey})
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	if rotated, err := storage.NewEncryptedStorage(inner, keys, rotating).RotateKeys(ctx); err != nil || rotated != 1 {
+		t.Fatalf("RotateKeys = %d, %v; want 1, nil", rotated, err)
+	}
+	key, err = keys.GetDataKey(ctx, objects[0].Key)
+	if err != nil || key == nil || key.MasterKeyID != rotating.CurrentKeyID() {
+		t.Fatalf("data key after rotation: %+v, %v", key, err)
+	}
+
+	// Purging the file takes its data key with it
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+file.ID, owner.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/trash/"+file.ID, owner.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("purge: got %d: %s", rec.Code, rec.Body)
+	}
+	if key, err := keys.GetDataKey(ctx, objects[0].Key); err != nil || key != nil {
+		t.Errorf("data key after purge: %+v, %v", key, err)
+	}
+
+	// Earlier versions can't be presigned either, and are streamed too
+	rec = doUpload(t, router, "/api/v1/files", owner.AccessToken, "draft.txt", "first draft")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	if rec := doUpload(t, router, "/api/v1/files/"+file.ID+"/versions", owner.AccessToken, "draft.txt", "second draft"); rec.Code != http.StatusOK {
+		t.Fatalf("upload version: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID+"/versions/1", owner.AccessToken, nil); rec.Code != http.StatusNotImplemented || !strings.Contains(rec.Body.String(), "/versions/:version/content") {
+		t.Errorf("presigned version: got %d: %s", rec.Code, rec.Body)
+	}
+	for version, want := range map[int]string{1: "first draft", 2: "second draft"} {
+		rec := doJSON(t, router, http.MethodGet, fmt.Sprintf("/api/v1/files/%s/versions/%d/content", file.ID, version), owner.AccessToken, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("version %d content: got %d %q, want %q", version, rec.Code, rec.Body, want)
+		}
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/files/"+file.ID+"/versions/1/content", nil)
+	req.Header.Set("Authorization", "Bearer "+owner.AccessToken)
+	req.Header.Set("Range", "bytes=6-")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "draft" {
+		t.Errorf("ranged version content: got %d %q, want 206 %q", rec.Code, rec.Body, "draft")
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID+"/versions/3/content", owner.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing version content: got %d", rec.Code)
+	}
+
+	// Parts sent straight to the bucket would be stored unencrypted, so
+	// direct uploads are refused before anything is created for them
+	before, err := keys.ListStaleDataKeys(ctx, "", "", 100)
+	if err != nil {
+		t.Fatalf("list data keys: %v", err)
+	}
+	rec = doJSON(t, router, http.MethodPost, "/api/v1/multipart-uploads", owner.AccessToken, map[string]any{
+		"file_name": "video.mp4", "size": 1024,
+	})
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("direct upload: got %d, want 501: %s", rec.Code, rec.Body)
+	}
+	after, err := keys.ListStaleDataKeys(ctx, "", "", 100)
+	if err != nil || len(after) != len(before) {
+		t.Errorf("data keys after a refused direct upload: %d, want %d (%v)", len(after), len(before), err)
+	}
+}
+
+func TestTusUpload(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, owner, err := deps.Auth.Signup(ctx, "tess", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "uma", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	tus := func(method, path, token string, header map[string]string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	create := func(length int) string {
+		t.Helper()
+		rec := tus(http.MethodPost, "/api/v1/uploads", owner.AccessToken, map[string]string{
+			"Upload-Length":   strconv.Itoa(length),
+			"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("big.bin")) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("application/x-test")),
+		}, nil)
+		if rec.Code != http.StatusCreated || rec.Header().Get("Upload-Offset") != "0" {
+			t.Fatalf("create: got %d: %s", rec.Code, rec.Body)
+		}
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil || !strings.HasPrefix(location.Path, "/api/v1/uploads/") {
+			t.Fatalf("create: Location = %q", rec.Header().Get("Location"))
+		}
+		return location.Path
+	}
+	patch := func(path string, offset int, chunk []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		return tus(http.MethodPatch, path, owner.AccessToken, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, chunk)
+	}
+
+	if rec := tus(http.MethodOptions, "/api/v1/uploads", "", nil, nil); rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Version") == "" {
+		t.Errorf("OPTIONS: got %d %v", rec.Code, rec.Header())
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/uploads", nil)
+	req.Header.Set("Authorization", "Bearer "+owner.AccessToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("create without Tus-Resumable: got %d", rec.Code)
+	}
+
+	// Enough for two full parts and a short last one, sent in chunks that
+	// don't line up with the parts
+	content := make([]byte, 2*storage.MinPartSize+12345)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	path := create(len(content))
+	const chunk = 3 * 1024 * 1024
+
+	if rec := patch(path, 0, content[:chunk]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(chunk) {
+		t.Fatalf("first chunk: got %d %q: %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+	if rec := patch(path, 0, content[:chunk]); rec.Code != http.StatusConflict || rec.Header().Get("Upload-Offset") != strconv.Itoa(chunk) {
+		t.Errorf("stale offset: got %d %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// A client that lost track asks where to resume
+	head := tus(http.MethodHead, path, owner.AccessToken, nil, nil)
+	if head.Code != http.StatusOK || head.Header().Get("Upload-Offset") != strconv.Itoa(chunk) || head.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("HEAD: got %d %v", head.Code, head.Header())
+	}
+	if rec := tus(http.MethodHead, path, other.AccessToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's HEAD: got %d", rec.Code)
+	}
+	if rec := patch(path, chunk, make([]byte, len(content))); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk past Upload-Length: got %d", rec.Code)
+	}
+
+	for offset := chunk; offset < len(content); offset += chunk {
+		end := min(offset+chunk, len(content))
+		if rec := patch(path, offset, content[offset:end]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(end) {
+			t.Fatalf("chunk at %d: got %d %q: %s", offset, rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+		}
+	}
+
+	// The upload has become a file with the upload's id
+	id := strings.TrimPrefix(path, "/api/v1/uploads/")
+	file, err := deps.Files.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("finished upload is not a file: %v", err)
+	}
+	if file.FileName != "big.bin" || file.ContentType != "application/x-test" || file.FileSize != int64(len(content)) {
+		t.Errorf("file = %+v", file)
+	}
+	if data := readObject(t, deps.Storage, file.StorageKey); !bytes.Equal(data, content) {
+		t.Errorf("stored object differs from what was sent (%d bytes, want %d)", len(data), len(content))
+	}
+	// The hash is carried across requests, so it covers every chunk
+	if sum, _, _ := storage.Checksum(bytes.NewReader(content)); file.SHA256 != sum {
+		t.Errorf("sha256 %q, want %q", file.SHA256, sum)
+	}
+	if rec := tus(http.MethodHead, path, owner.AccessToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD of a finished upload: got %d", rec.Code)
+	}
+
+	// Finished uploads share the blob of a file with the same content: a
+	// short one is stored as a blob, an assembled one is swapped for it
+	for _, shared := range [][]byte{[]byte("shared content"), content} {
+		rec := doUpload(t, router, "/api/v1/files", owner.AccessToken, "copy.bin", string(shared))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload copy: got %d: %s", rec.Code, rec.Body)
+		}
+		var copied struct {
+			StorageKey string `json:"storage_key"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &copied)
+
+		path := create(len(shared))
+		if rec := patch(path, 0, shared); rec.Code != http.StatusNoContent {
+			t.Fatalf("%d byte upload: got %d: %s", len(shared), rec.Code, rec.Body)
+		}
+		id := strings.TrimPrefix(path, "/api/v1/uploads/")
+		file, err := deps.Files.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("finished upload is not a file: %v", err)
+		}
+		if file.StorageKey != copied.StorageKey {
+			t.Errorf("%d byte upload stored at %q, want the shared %q", len(shared), file.StorageKey, copied.StorageKey)
+		}
+		if objects, _ := deps.Storage.ListFiles(ctx, storage.UserKey(file.OwnerID, id, "")); len(objects) != 0 {
+			t.Errorf("%d byte upload left %v behind", len(shared), objects)
+		}
+	}
+
+	// Termination frees an upload part way through
+	path = create(len(content))
+	if rec := patch(path, 0, content[:100]); rec.Code != http.StatusNoContent {
+		t.Fatalf("chunk: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := tus(http.MethodDelete, path, other.AccessToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's DELETE: got %d", rec.Code)
+	}
+	if rec := tus(http.MethodDelete, path, owner.AccessToken, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := tus(http.MethodHead, path, owner.AccessToken, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE: got %d", rec.Code)
+	}
+	if rec := patch(path, 100, content[100:200]); rec.Code != http.StatusNotFound {
+		t.Errorf("PATCH after DELETE: got %d", rec.Code)
+	}
+}
+
+func TestDirectUpload(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, owner, err := deps.Auth.Signup(ctx, "vera", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "walt", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	type part struct {
+		PartNumber int32  `json:"part_number"`
+		URL        string `json:"url,omitempty"`
+		ETag       string `json:"etag,omitempty"`
+	}
+	start := func(size int) (string, int32) {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodPost, "/api/v1/multipart-uploads", owner.AccessToken, map[string]any{
+			"file_name": "video.mp4", "content_type": "video/mp4", "size": size,
+		})
+		var upload struct {
+			ID        string `json:"id"`
+			PartCount int32  `json:"part_count"`
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &upload) != nil {
+			t.Fatalf("start: got %d: %s", rec.Code, rec.Body)
+		}
+		return "/api/v1/multipart-uploads/" + upload.ID, upload.PartCount
+	}
+	// put sends a part to its presigned URL the way a browser would
+	put := func(raw string, data []byte) string {
+		t.Helper()
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("part URL %q does not parse: %v", raw, err)
+		}
+		number, _ := strconv.Atoi(u.Query().Get("partNumber"))
+		etag, err := deps.Storage.UploadPart(ctx, strings.TrimPrefix(u.Path, "/"), u.Query().Get("uploadId"), int32(number), bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("upload part %d: %v", number, err)
+		}
+		return etag
+	}
+	complete := func(path, token string, parts []part) *httptest.ResponseRecorder {
+		t.Helper()
+		return doJSON(t, router, http.MethodPost, path+"/complete", token, map[string]any{"parts": parts})
+	}
+
+	content := make([]byte, 16*1024*1024+1000)
+	for i := range content {
+		content[i] = byte(i * 13)
+	}
+	path, count := start(len(content))
+	if count != 2 {
+		t.Fatalf("part_count = %d, want 2", count)
+	}
+
+	rec := doJSON(t, router, http.MethodPost, path+"/parts", owner.AccessToken, map[string]any{"part_numbers": []int{1, 2}})
+	var presigned struct {
+		Parts     []part `json:"parts"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &presigned) != nil || len(presigned.Parts) != 2 || presigned.ExpiresIn <= 0 {
+		t.Fatalf("presign parts: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodPost, path+"/parts", owner.AccessToken, map[string]any{"part_numbers": []int{3}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("presign a part past the end: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, path+"/parts", other.AccessToken, map[string]any{"part_numbers": []int{1}}); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's presign: got %d", rec.Code)
+	}
+
+	first := put(presigned.Parts[0].URL, content[:16*1024*1024])
+	short := put(presigned.Parts[1].URL, content[16*1024*1024:len(content)-1])
+
+	// Parts that don't match what the bucket holds leave the upload open
+	rejected := map[string][]part{
+		"missing part": {{PartNumber: 1, ETag: first}},
+		"wrong etag":   {{PartNumber: 1, ETag: "nope"}, {PartNumber: 2, ETag: short}},
+		"short part":   {{PartNumber: 1, ETag: first}, {PartNumber: 2, ETag: short}},
+	}
+	for name, parts := range rejected {
+		if rec := complete(path, owner.AccessToken, parts); rec.Code != http.StatusBadRequest {
+			t.Errorf("complete with %s: got %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	last := put(presigned.Parts[1].URL, content[16*1024*1024:])
+	parts := []part{{PartNumber: 1, ETag: `"` + first + `"`}, {PartNumber: 2, ETag: last}}
+	if rec := complete(path, other.AccessToken, parts); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's complete: got %d", rec.Code)
+	}
+	rec = complete(path, owner.AccessToken, parts)
+	var file struct {
+		ID   string `json:"id"`
+		Size int64  `json:"size"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &file) != nil || file.Size != int64(len(content)) {
+		t.Fatalf("complete: got %d: %s", rec.Code, rec.Body)
+	}
+	stored, err := deps.Files.GetByID(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("completed upload is not a file: %v", err)
+	}
+	if data := readObject(t, deps.Storage, stored.StorageKey); !bytes.Equal(data, content) {
+		t.Errorf("stored object differs from the parts sent")
+	}
+	if rec := complete(path, owner.AccessToken, parts); rec.Code != http.StatusNotFound {
+		t.Errorf("completing twice: got %d", rec.Code)
+	}
+
+	// Aborting throws the parts away
+	path, _ = start(100)
+	if rec := doJSON(t, router, http.MethodDelete, path, other.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's abort: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, path, owner.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("abort: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodPost, path+"/parts", owner.AccessToken, map[string]any{"part_numbers": []int{1}}); rec.Code != http.StatusNotFound {
+		t.Errorf("presign after abort: got %d", rec.Code)
+	}
+}
+
+func readObject(t *testing.T, s storage.StorageInterface, key string) []byte {
+	t.Helper()
+	object, err := s.GetFile(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get object %s: %v", key, err)
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatalf("read object %s: %v", key, err)
+	}
+	return data
+}
+
+func TestShares(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	owner, tokens, err := deps.Auth.Signup(ctx, "nina", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, other, err := deps.Auth.Signup(ctx, "oscar", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, "report.pdf", "quarterly numbers")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	sharesPath := "/api/v1/files/" + file.ID + "/shares"
+
+	type share struct {
+		ID            string `json:"id"`
+		Token         string `json:"token"`
+		DownloadCount int    `json:"download_count"`
+		Active        bool   `json:"active"`
+	}
+	create := func(body map[string]any) share {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodPost, sharesPath, tokens.AccessToken, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("create share: got %d: %s", rec.Code, rec.Body)
+		}
+		var created share
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return created
+	}
+	shares := func() map[string]share {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodGet, sharesPath, tokens.AccessToken, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list shares: got %d: %s", rec.Code, rec.Body)
+		}
+		var list struct {
+			Shares []share `json:"shares"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		byID := make(map[string]share)
+		for _, s := range list.Shares {
+			byID[s.ID] = s
+		}
+		return byID
+	}
+	open := func(token, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+		if password != "" {
+			req.Header.Set("X-Share-Password", password)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// bcrypt only reads 72 bytes, however few characters they make
+	for _, password := range []string{strings.Repeat("a", 73), strings.Repeat("é", 40)} {
+		if rec := doJSON(t, router, http.MethodPost, sharesPath, tokens.AccessToken, map[string]any{"password": password}); rec.Code != http.StatusBadRequest {
+			t.Errorf("%d byte password: got %d", len(password), rec.Code)
+		}
+	}
+	if rec := doJSON(t, router, http.MethodPost, sharesPath, other.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's share: got %d", rec.Code)
+	}
+
+	if rec := open("no-such-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token: got %d", rec.Code)
+	}
+
+	// The password gate turns callers away without counting a download
+	guarded := create(map[string]any{"password": "open sesame", "max_downloads": 2})
+	if rec := open(guarded.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no password: got %d", rec.Code)
+	}
+	if rec := open(guarded.Token, "open barley"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d", rec.Code)
+	}
+	if count := shares()[guarded.ID].DownloadCount; count != 0 {
+		t.Errorf("refused requests counted %d downloads", count)
+	}
+
+	rec = open(guarded.Token, "open sesame")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("open: got %d: %s", rec.Code, rec.Body)
+	}
+	// The redirect only has to last until it is followed
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Location = %q", rec.Header().Get("Location"))
+	}
+	expires, _ := strconv.ParseInt(location.Query().Get("expires"), 10, 64)
+	if lifetime := time.Until(time.Unix(expires, 0)); lifetime > time.Minute {
+		t.Errorf("redirect lasts %v", lifetime)
+	}
+
+	// A form post gets a See Other, and uses up the last download
+	req := httptest.NewRequest(http.MethodPost, "/s/"+guarded.Token, strings.NewReader("password=open+sesame"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("form post: got %d: %s", rec.Code, rec.Body)
+	}
+	if got := shares()[guarded.ID]; got.DownloadCount != 2 || got.Active {
+		t.Errorf("share after two downloads = %+v", got)
+	}
+	if rec := open(guarded.Token, "open sesame"); rec.Code != http.StatusGone {
+		t.Errorf("past max_downloads: got %d", rec.Code)
+	}
+
+	// Wrong passwords are only checked so many times
+	locked := create(map[string]any{"password": "open sesame"})
+	for range 5 {
+		if rec := open(locked.Token, "guess"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: got %d", rec.Code)
+		}
+	}
+	if rec := open(locked.Token, "open sesame"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("password after too many guesses: got %d", rec.Code)
+	}
+	if rec := open(guarded.Token, ""); rec.Code != http.StatusGone {
+		t.Errorf("other links are unaffected: got %d", rec.Code)
+	}
+
+	// An expired link is gone
+	model, err := deps.Files.GetByID(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("get file: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	_, expired, err := deps.Shares.Create(ctx, model, owner.ID, services.ShareOptions{ExpiresAt: &past})
+	if err != nil {
+		t.Fatalf("create expired share: %v", err)
+	}
+	if rec := open(expired, ""); rec.Code != http.StatusGone {
+		t.Errorf("expired link: got %d", rec.Code)
+	}
+
+	// A revoked link is gone, and only the owner can revoke it
+	revoked := create(nil)
+	if rec := doJSON(t, router, http.MethodDelete, sharesPath+"/"+revoked.ID, other.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's revoke: got %d", rec.Code)
+	}
+	if rec := open(revoked.Token, ""); rec.Code != http.StatusFound {
+		t.Errorf("open before revoke: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, sharesPath+"/"+revoked.ID, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := open(revoked.Token, ""); rec.Code != http.StatusGone {
+		t.Errorf("revoked link: got %d", rec.Code)
+	}
+
+	// A link to a trashed file doesn't use up a download
+	trashed := create(map[string]any{"max_downloads": 1})
+	if rec := doJSON(t, router, http.MethodDelete, "/api/v1/files/"+file.ID, tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := open(trashed.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("link to a trashed file: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/trash/"+file.ID+"/restore", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("restore: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := open(trashed.Token, ""); rec.Code != http.StatusFound {
+		t.Errorf("link after restore: got %d", rec.Code)
+	}
+}
+
+func TestAuthTokens(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	signup := func(username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doJSON(t, router, http.MethodPost, "/api/v1/auth/signup", "", map[string]string{
+			"username": username,
+			"password": password,
+		})
+	}
+
+	// bcrypt only reads 72 bytes, however few characters they make
+	for _, password := range []string{strings.Repeat("a", 73), strings.Repeat("é", 40)} {
+		if rec := signup("peggy", password); rec.Code != http.StatusBadRequest {
+			t.Errorf("%d byte password: got %d", len(password), rec.Code)
+		}
+	}
+	if rec := signup("peggy", strings.Repeat("a", 72)); rec.Code != http.StatusOK {
+		t.Errorf("72 byte password: got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Signups racing on an empty instance make exactly one admin
+	router, deps = newTestRouter(t)
+	roles := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := range cap(roles) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, _, err := deps.Auth.Signup(ctx, fmt.Sprintf("racer%d", i), testPassword)
+			if err != nil {
+				t.Errorf("signup: %v", err)
+				return
+			}
+			roles <- string(user.Role)
+		}()
+	}
+	wg.Wait()
+	close(roles)
+	admins := 0
+	for role := range roles {
+		if role == string(models.RoleAdmin) {
+			admins++
+		}
+	}
+	if admins != 1 {
+		t.Errorf("%d concurrent signups became admins", admins)
+	}
+
+	refresh := func(token string) (rest.TokenResponse, *httptest.ResponseRecorder) {
+		t.Helper()
+		rec := doJSON(t, router, http.MethodPost, "/api/v1/auth/refresh", "", map[string]string{"refresh_token": token})
+		var tokens rest.TokenResponse
+		json.Unmarshal(rec.Body.Bytes(), &tokens)
+		return tokens, rec
+	}
+
+	_, first, err := deps.Auth.Signin(ctx, "racer0", testPassword)
+	if err != nil {
+		t.Fatalf("signin: %v", err)
+	}
+	_, second, err := deps.Auth.Signin(ctx, "racer0", testPassword)
+	if err != nil {
+		t.Fatalf("signin: %v", err)
+	}
+
+	// Each refresh token is swapped for a new one and works once
+	rotated, rec := refresh(first.RefreshToken)
+	if rec.Code != http.StatusOK || rotated.RefreshToken == "" || rotated.RefreshToken == first.RefreshToken || rotated.AccessToken == "" {
+		t.Fatalf("refresh: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files", rotated.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("rotated access token: got %d", rec.Code)
+	}
+
+	// Reusing a rotated token revokes everything issued from it, but not
+	// other sessions
+	if _, rec := refresh(first.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: got %d", rec.Code)
+	}
+	if _, rec := refresh(rotated.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token from a revoked family: got %d", rec.Code)
+	}
+	if _, rec := refresh(second.RefreshToken); rec.Code != http.StatusOK {
+		t.Errorf("another session's refresh token: got %d", rec.Code)
+	}
+	if _, rec := refresh("not-a-token"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: got %d", rec.Code)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	router, deps := newTestRouter(t)
+
+	user, tokens, err := deps.Auth.Signup(context.Background(), "quinn", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	// Tokens signed right but already expired, or signed with another secret
+	expiredTokens, err := auth.NewTokenManager(strings.Repeat("s", 32), "test", -time.Minute)
+	if err != nil {
+		t.Fatalf("token manager: %v", err)
+	}
+	expired, _ := expiredTokens.Issue(user.ID, user.Username)
+	forgedTokens, err := auth.NewTokenManager(strings.Repeat("f", 32), "test", time.Minute)
+	if err != nil {
+		t.Fatalf("token manager: %v", err)
+	}
+	forged, _ := forgedTokens.Issue(user.ID, user.Username)
+
+	for name, header := range map[string]string{
+		"missing":        "",
+		"wrong scheme":   "Basic " + tokens.AccessToken,
+		"empty bearer":   "Bearer ",
+		"malformed":      "Bearer not.a.jwt",
+		"expired":        "Bearer " + expired,
+		"wrong secret":   "Bearer " + forged,
+		"refresh token":  "Bearer " + tokens.RefreshToken,
+		"no scheme name": tokens.AccessToken,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var body rest.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusUnauthorized || body.Code != http.StatusUnauthorized || body.Error == "" {
+			t.Errorf("%s token: got %d: %s", name, rec.Code, rec.Body)
+		}
+		if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s token: no WWW-Authenticate challenge", name)
+		}
+	}
+
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/files", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("valid token: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/health", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("health without a token: got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	router, deps := newTestRouter(t)
+	ctx := context.Background()
+
+	_, root, err := deps.Auth.Signup(ctx, "root", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	alice, member, err := deps.Auth.Signup(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	bob, viewer, err := deps.Auth.Signup(ctx, "bob", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	_, stranger, err := deps.Auth.Signup(ctx, "carol", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+
+	setRole := func(token, userID, role string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doJSON(t, router, http.MethodPut, "/api/v1/admin/users/"+userID+"/role", token, map[string]string{"role": role})
+	}
+
+	// Only admins get into /admin
+	for _, path := range []string{"/api/v1/admin/users", "/api/v1/admin/files"} {
+		if rec := doJSON(t, router, http.MethodGet, path, member.AccessToken, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("member GET %s: got %d", path, rec.Code)
+		}
+		if rec := doJSON(t, router, http.MethodGet, path, "", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %s: got %d", path, rec.Code)
+		}
+		if rec := doJSON(t, router, http.MethodGet, path, root.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Errorf("admin GET %s: got %d: %s", path, rec.Code, rec.Body)
+		}
+	}
+	if rec := setRole(member.AccessToken, alice.ID, "admin"); rec.Code != http.StatusForbidden {
+		t.Errorf("member promoting themselves: got %d", rec.Code)
+	}
+
+	// Accounts are only visible to their owner and to admins
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/users/"+alice.ID, member.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("member viewing themselves: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/users/"+bob.ID, member.AccessToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member viewing another account: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/users/no-such-user", member.AccessToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member probing for an account: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/users/"+bob.ID, root.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("admin viewing another account: got %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := setRole(root.AccessToken, bob.ID, "viewer"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"role":"viewer"`) {
+		t.Fatalf("set role: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := setRole(root.AccessToken, bob.ID, "owner"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/users/"+bob.ID+"/role", root.AccessToken, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing role: got %d", rec.Code)
+	}
+	if rec := setRole(root.AccessToken, "no-such-user", "member"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: got %d", rec.Code)
+	}
+
+	// Viewers can't add anything, however they try
+	if rec := doUpload(t, router, "/api/v1/files", viewer.AccessToken, "notes.txt", "notes"); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer upload: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/multipart-uploads", viewer.AccessToken, map[string]any{"file_name": "notes.txt", "size": 5}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer direct upload: got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/uploads", nil)
+	req.Header.Set("Authorization", "Bearer "+viewer.AccessToken)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "5")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("viewer tus upload: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/folders", viewer.AccessToken, map[string]string{"name": "notes"}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer folder: got %d", rec.Code)
+	}
+
+	// Files nobody shared with the caller don't exist as far as they know;
+	// shared ones are visible but can't be changed by a viewer
+	rec = doUpload(t, router, "/api/v1/files", member.AccessToken, "plan.txt", "the plan")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+	filePath := "/api/v1/files/" + file.ID
+
+	if rec := doJSON(t, router, http.MethodGet, filePath, viewer.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unshared file: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPost, filePath+"/grants", member.AccessToken, map[string]string{"username": "bob"}); rec.Code != http.StatusOK {
+		t.Fatalf("grant: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, filePath, viewer.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("shared file: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, filePath, viewer.AccessToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer deleting a shared file: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodDelete, filePath, stranger.AccessToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting an unshared file: got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, filePath, root.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("admin reading any file: got %d", rec.Code)
+	}
+
+	// There is always an admin left
+	rootUser, err := deps.Users.GetByUsername(ctx, "root")
+	if err != nil {
+		t.Fatalf("get root: %v", err)
+	}
+	if rec := setRole(root.AccessToken, rootUser.ID, "member"); rec.Code != http.StatusConflict {
+		t.Errorf("demoting the last admin: got %d", rec.Code)
+	}
+	if rec := setRole(root.AccessToken, alice.ID, "admin"); rec.Code != http.StatusOK {
+		t.Fatalf("promote: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := setRole(root.AccessToken, rootUser.ID, "member"); rec.Code != http.StatusOK {
+		t.Fatalf("demoting one of two admins: got %d: %s", rec.Code, rec.Body)
+	}
+	// Role changes apply to tokens already issued
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/admin/users", root.AccessToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("demoted admin: got %d", rec.Code)
+	}
+	if rec := setRole(member.AccessToken, alice.ID, "viewer"); rec.Code != http.StatusConflict {
+		t.Errorf("new last admin demoting themselves: got %d", rec.Code)
+	}
+
+	// Two admins demoting each other at once still leave one of them
+	for round := range 20 {
+		for _, id := range []string{alice.ID, rootUser.ID} {
+			if _, err := deps.UserSvc.SetRole(ctx, id, models.RoleAdmin); err != nil {
+				t.Fatalf("round %d: promote: %v", round, err)
+			}
+		}
+		errs := make(chan error, 2)
+		for _, id := range []string{alice.ID, rootUser.ID} {
+			go func() {
+				_, err := deps.UserSvc.SetRole(ctx, id, models.RoleMember)
+				errs <- err
+			}()
+		}
+		refused := 0
+		for range 2 {
+			err := <-errs
+			if errors.Is(err, services.ErrLastAdmin) {
+				refused++
+			} else if err != nil {
+				t.Fatalf("round %d: demote: %v", round, err)
+			}
+		}
+		users, err := deps.Users.List(ctx)
+		if err != nil {
+			t.Fatalf("list users: %v", err)
+		}
+		admins := 0
+		for _, user := range users {
+			if user.Role == models.RoleAdmin {
+				admins++
+			}
+		}
+		if refused != 1 || admins != 1 {
+			t.Fatalf("round %d: %d demotions refused, %d admins left", round, refused, admins)
+		}
+	}
+}
+
+func TestLocalStorageDownload(t *testing.T) {
+	router, deps := newTestRouterWithStorage(t, func(*sql.DB) storage.StorageInterface {
+		local, err := storage.NewLocalStorage(&config.StorageConfig{
+			Path:       t.TempDir(),
+			SigningKey: strings.Repeat("k", 32),
+		}, "http://archive.test")
+		if err != nil {
+			t.Fatalf("local storage: %v", err)
+		}
+		return local
+	})
+
+	_, tokens, err := deps.Auth.Signup(context.Background(), "rita", testPassword)
+	if err != nil {
+		t.Fatalf("signup: %v", err)
+	}
+	rec := doUpload(t, router, "/api/v1/files", tokens.AccessToken, "page.html", "<script>alert(document.cookie)</script>")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: got %d: %s", rec.Code, rec.Body)
+	}
+	var file struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &file)
+
+	// Signed URLs are served from the API's origin, so pages are only ever
+	// saved, whether their type comes from the name or the link
+	for _, tc := range []struct {
+		query, contentType, disposition string
+	}{
+		{"", "text/html; charset=utf-8", "attachment"},
+		{"?content_type=image/svg%2Bxml", "image/svg+xml", "attachment"},
+		{"?content_type=text/plain", "text/plain", "inline"},
+	} {
+		rec := doJSON(t, router, http.MethodGet, "/api/v1/files/"+file.ID+tc.query, tokens.AccessToken, nil)
+		var download fileDownload
+		json.Unmarshal(rec.Body.Bytes(), &download)
+		link, err := url.Parse(download.URL)
+		if rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("presign %q: got %d: %s", tc.query, rec.Code, rec.Body)
+		}
+
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("download %q: got %d: %s", tc.query, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("download %q: Content-Type %q, want %q", tc.query, got, tc.contentType)
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, tc.disposition) {
+			t.Errorf("download %q: Content-Disposition %q, want %s", tc.query, got, tc.disposition)
+		}
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("download %q: headers %v", tc.query, rec.Header())
+		}
+	}
+}
@@ -2,8 +2,13 @@ package server
 
 import (
 	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,29 +16,186 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/auth"
 	"github.com/okoye-dev/oss-archive/internal/config"
+	"github.com/okoye-dev/oss-archive/internal/database"
+	"github.com/okoye-dev/oss-archive/internal/handlers"
+	"github.com/okoye-dev/oss-archive/internal/policy"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/services"
 	"github.com/okoye-dev/oss-archive/internal/storage"
 )
 
+// abortGrace is how long before the shutdown deadline in-flight storage
+// calls are cancelled, leaving their handlers time to clean up and return
+const abortGrace = time.Second
+
+// Trash defaults used when the configuration leaves them unset
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+)
+
 // Server wraps the HTTP server with configuration
 type Server struct {
 	httpServer *http.Server
 	config     *config.Config
-	storage    storage.StorageInterface
+	db         *sql.DB
+	deps       *Dependencies
+
+	// work is the parent of every request context, so cancelling it stops
+	// storage calls that are still running at shutdown
+	work     context.Context
+	stopWork context.CancelFunc
 }
 
 // New creates a new server instance with the given configuration
 func New(cfg *config.Config) *Server {
-	// Initialize storage
-	s3Storage, err := storage.NewS3Storage(&cfg.S3)
+	// Initialize file catalog
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Refuse to serve against a schema older than this binary
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := database.CheckSchema(ctx, db); err != nil {
+		log.Fatalf("Database not ready: %v", err)
+	}
+
+	// Initialize storage, which keeps data keys in the catalog
+	fileStorage, err := NewStorage(cfg, db)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	// Initialize authentication
+	tokens, err := auth.NewTokenManager(
+		cfg.Auth.JWTSecret,
+		cfg.Auth.Issuer,
+		time.Duration(cfg.Auth.AccessTokenTTL)*time.Second,
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize auth: %v", err)
+	}
+	users := repository.NewUserRepository(db)
+	authService := services.NewAuthService(
+		users,
+		repository.NewRefreshTokenRepository(db),
+		tokens,
+		time.Duration(cfg.Auth.RefreshTokenTTL)*time.Second,
+	)
+
+	files := repository.NewFileRepository(db)
+	folders := repository.NewFolderRepository(db)
+	holds := services.NewHoldService(fileStorage, files, folders)
+	blobs := services.NewBlobService(fileStorage, repository.NewBlobRepository(db))
+	versions := services.NewVersionService(fileStorage, files, blobs, holds)
+	trash := services.NewTrashService(versions, blobs, holds, files, folders, trashRetention(cfg))
+	work, stopWork := context.WithCancel(context.Background())
+
 	return &Server{
-		config:  cfg,
-		storage: s3Storage,
+		config:   cfg,
+		db:       db,
+		work:     work,
+		stopWork: stopWork,
+		deps: &Dependencies{
+			Storage:   fileStorage,
+			Files:     files,
+			Users:     users,
+			Auth:      authService,
+			UserSvc:   services.NewUserService(users),
+			Shares:    services.NewShareService(repository.NewShareRepository(db), files),
+			Uploads:   services.NewUploadService(fileStorage, repository.NewUploadRepository(db), files, blobs),
+			Folders:   services.NewFolderService(trash, holds, folders, files),
+			Blobs:     blobs,
+			Versions:  versions,
+			Trash:     trash,
+			Holds:     holds,
+			Tokens:    tokens,
+			Policy:    policy.NewFilePolicy(files),
+			PublicURL: cfg.Server.PublicURL,
+			Presign: handlers.PresignExpiry{
+				Default: time.Duration(cfg.Storage.PresignExpiry) * time.Second,
+				Max:     time.Duration(cfg.Storage.MaxPresignExpiry) * time.Second,
+			},
+		},
+	}
+}
+
+// NewStorage builds the backend chosen by storage.backend, encrypting what
+// is written to it when storage.encryption_key is set
+func NewStorage(cfg *config.Config, db *sql.DB) (storage.StorageInterface, error) {
+	backend, err := newBackend(cfg)
+	if err != nil || cfg.Storage.EncryptionKey == "" {
+		return backend, err
+	}
+
+	keyring, err := storage.NewKeyring(cfg.Storage.EncryptionKey, cfg.Storage.PreviousEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🔒 Encrypting stored files with master key %s", keyring.CurrentKeyID())
+	return storage.NewEncryptedStorage(backend, repository.NewDataKeyRepository(db), keyring), nil
+}
+
+func newBackend(cfg *config.Config) (storage.StorageInterface, error) {
+	switch cfg.Storage.Backend {
+	case "", storage.BackendS3:
+		return storage.NewS3Storage(&cfg.S3)
+	case storage.BackendLocal:
+		localCfg := cfg.Storage
+		if localCfg.Path == "" {
+			localCfg.Path = "data/storage"
+		}
+		if localCfg.SigningKey == "" {
+			key, err := deriveSigningKey(cfg.Auth.JWTSecret)
+			if err != nil {
+				return nil, err
+			}
+			localCfg.SigningKey = key
+		}
+
+		// Signed URLs are served by this server
+		baseURL := cfg.Server.PublicURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
+		}
+
+		log.Printf("📁 Storing files on local disk at %s", localCfg.Path)
+		return storage.NewLocalStorage(&localCfg, baseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// signingKeyLabel sets the key that signs local download URLs apart from
+// the JWT secret it is derived from
+const signingKeyLabel = "oss-archive local storage URL signing"
+
+// deriveSigningKey makes a key for signing local download URLs out of the
+// JWT secret, so that neither signature can stand in for the other
+func deriveSigningKey(secret string) (string, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, signingKeyLabel, 32)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive signing key: %w", err)
 	}
+	return hex.EncodeToString(key), nil
+}
+
+func trashRetention(cfg *config.Config) time.Duration {
+	if cfg.Trash.Retention <= 0 {
+		return defaultTrashRetention
+	}
+	return time.Duration(cfg.Trash.Retention) * time.Second
+}
+
+func trashPurgeInterval(cfg *config.Config) time.Duration {
+	if cfg.Trash.PurgeInterval <= 0 {
+		return defaultTrashPurgeInterval
+	}
+	return time.Duration(cfg.Trash.PurgeInterval) * time.Second
 }
 
 // SetupRoutes configures all the application routes
@@ -41,7 +203,7 @@ func (s *Server) SetupRoutes() *gin.Engine {
 	gin.SetMode(s.config.Logging.Mode)
 	
 	router := gin.Default()
-	SetupRoutes(router, s.storage)
+	SetupRoutes(router, s.deps)
 
 	return router
 }
@@ -57,6 +219,7 @@ func (s *Server) Start() error {
 		ReadTimeout:  time.Duration(s.config.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(s.config.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(s.config.Server.IdleTimeout) * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return s.work },
 	}
 
 	// Start server in a goroutine
@@ -73,6 +236,9 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	// Expired files leave the trash in the background until shutdown
+	go s.deps.Trash.RunPurger(s.work, trashPurgeInterval(s.config))
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	return s.waitForShutdown()
 }
@@ -91,17 +257,32 @@ func (s *Server) waitForShutdown() error {
 	defer cancel()
 
 	log.Printf("...Graceful shutdown timeout: %ds...", s.config.Server.ShutdownTimeout)
+
+	// Requests that are still going near the deadline, such as a stalled
+	// upload, have their storage calls cancelled instead of outliving it
+	abortAfter := max(shutdownTimeout-abortGrace, 0)
+	abort := time.AfterFunc(abortAfter, s.stopWork)
+	defer abort.Stop()
+	defer s.stopWork()
 	
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		return fmt.Errorf("server forced to shutdown: %w", err)
 	}
 
+	if err := s.db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+
 	log.Println("✅ Server shutting down... come back next time :)")
 	return nil
 }
 
 // Stop stops the server immediately
 func (s *Server) Stop() error {
+	s.stopWork()
+	if s.db != nil {
+		defer s.db.Close()
+	}
 	if s.httpServer != nil {
 		return s.httpServer.Close()
 	}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+var (
+	ErrUsernameTaken       = errors.New("username is already taken")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// TokenPair is what a successful signin, signup or refresh hands back to the client
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// AuthService manages accounts and the tokens issued to them
+type AuthService struct {
+	users      repository.UserRepository
+	refresh    repository.RefreshTokenRepository
+	tokens     *auth.TokenManager
+	refreshTTL time.Duration
+}
+
+func NewAuthService(users repository.UserRepository, refresh repository.RefreshTokenRepository, tokens *auth.TokenManager, refreshTTL time.Duration) *AuthService {
+	return &AuthService{
+		users:      users,
+		refresh:    refresh,
+		tokens:     tokens,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// dummyHash is compared against when a username doesn't exist so signin
+// takes the same time whether or not the account is real
+var dummyHash, _ = auth.HashPassword("oss-archive-timing-equaliser")
+
+// Signup creates a new account and signs it in
+func (s *AuthService) Signup(ctx context.Context, username, password string) (*models.User, *TokenPair, error) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	user := &models.User{
+		ID:           uuid.New().String(),
+		Username:     NormalizeUsername(username),
+		PasswordHash: hash,
+		Role:         models.RoleAdmin,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// The first account administers the instance; everyone after is a member
+	first, err := s.users.CreateFirst(ctx, user)
+	if err == nil && !first {
+		user.Role = models.RoleMember
+		err = s.users.Create(ctx, user)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return nil, nil, ErrUsernameTaken
+		}
+		return nil, nil, err
+	}
+
+	tokens, err := s.issue(ctx, user, uuid.New().String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// Signin verifies a username and password and issues a fresh token pair
+func (s *AuthService) Signin(ctx context.Context, username, password string) (*models.User, *TokenPair, error) {
+	user, err := s.users.GetByUsername(ctx, NormalizeUsername(username))
+	if errors.Is(err, repository.ErrNotFound) {
+		auth.CheckPassword(dummyHash, password)
+		return nil, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !auth.CheckPassword(user.PasswordHash, password) {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	tokens, err := s.issue(ctx, user, uuid.New().String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token works
+// once; presenting one that was already rotated revokes its whole family.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.User, *TokenPair, error) {
+	stored, err := s.refresh.GetByHash(ctx, auth.HashOpaqueToken(refreshToken))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		if err := s.refresh.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if err := s.refresh.Revoke(ctx, stored.ID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			// Lost a race with another rotation of the same token
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, stored.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := s.issue(ctx, user, stored.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// GetUser loads the account behind an authenticated request
+func (s *AuthService) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	return s.users.GetByID(ctx, userID)
+}
+
+func (s *AuthService) issue(ctx context.Context, user *models.User, familyID string) (*TokenPair, error) {
+	accessToken, err := s.tokens.Issue(user.ID, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err = s.refresh.Create(ctx, &models.RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		TokenHash: auth.HashOpaqueToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: now.Add(s.refreshTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    s.tokens.TTL(),
+	}, nil
+}
+
+// NormalizeUsername is the canonical form usernames are stored and looked up in
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
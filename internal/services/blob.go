@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+// collectBatchSize is how many unused blobs Collect loads at a time
+const collectBatchSize = 100
+
+// BlobService stores uploaded content once per distinct checksum, so files
+// with the same bytes share one object. Every version stored in a blob holds
+// a reference to it, and the object is deleted once the last is given back.
+//
+// Storing and deleting the same blob are serialised within the process, so
+// a blob can't be deleted between being found and being referenced.
+type BlobService struct {
+	storage storage.StorageInterface
+	blobs   repository.BlobRepository
+	locks   [256]sync.Mutex
+}
+
+func NewBlobService(storage storage.StorageInterface, blobs repository.BlobRepository) *BlobService {
+	return &BlobService{
+		storage: storage,
+		blobs:   blobs,
+	}
+}
+
+// Store takes a reference to the blob with checksum, the hex SHA-256 of what
+// reader yields, and returns its key. The content is only written when no
+// file has it yet; otherwise reader is left unread.
+func (s *BlobService) Store(ctx context.Context, reader io.Reader, size int64, contentType, checksum string) (string, error) {
+	lock := s.lock(checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := storage.BlobKey(checksum)
+	found, err := s.blobs.Acquire(ctx, checksum)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return key, nil
+	}
+
+	if _, err := storage.UploadFileChecksummed(ctx, s.storage, key, reader, size, contentType, checksum); err != nil {
+		return "", err
+	}
+	if err := s.blobs.Add(ctx, &models.Blob{
+		SHA256:     checksum,
+		StorageKey: key,
+		FileSize:   size,
+		CreatedAt:  time.Now().UTC(),
+	}); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Retain takes another reference to a blob a version already uses
+func (s *BlobService) Retain(ctx context.Context, checksum string) error {
+	found, err := s.blobs.Acquire(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// Release gives back a reference taken for a version that was never
+// recorded, deleting the blob if nothing else uses it
+func (s *BlobService) Release(ctx context.Context, checksum string) error {
+	remaining, err := s.blobs.Release(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+
+	blob, err := s.blobs.Get(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, blob)
+}
+
+// Collect deletes every blob nothing uses any more and returns how many
+// went. A blob that fails is logged and left for the next run.
+func (s *BlobService) Collect(ctx context.Context) (int, error) {
+	collected, after := 0, ""
+	for {
+		blobs, err := s.blobs.ListUnreferenced(ctx, after, collectBatchSize)
+		if err != nil {
+			return collected, err
+		}
+
+		for i := range blobs {
+			err := s.remove(ctx, &blobs[i])
+			switch {
+			case err == nil:
+				collected++
+			case ctx.Err() != nil:
+				return collected, ctx.Err()
+			default:
+				log.Printf("Failed to delete unused blob %s: %v", blobs[i].SHA256, err)
+			}
+		}
+
+		if len(blobs) < collectBatchSize {
+			return collected, nil
+		}
+		after = blobs[len(blobs)-1].SHA256
+	}
+}
+
+// remove deletes a blob's object and then the blob, unless it has been
+// referenced again in the meantime
+func (s *BlobService) remove(ctx context.Context, blob *models.Blob) error {
+	lock := s.lock(blob.SHA256)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := s.blobs.Get(ctx, blob.SHA256)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.RefCount > 0 {
+		return nil
+	}
+
+	if err := s.deleteObject(ctx, current.StorageKey); err != nil {
+		return err
+	}
+	return s.blobs.Delete(ctx, current.SHA256)
+}
+
+// deleteObject removes key for good
+func (s *BlobService) deleteObject(ctx context.Context, key string) error {
+	versioned, ok := s.storage.(storage.VersionedStorage)
+	if !ok {
+		return s.storage.DeleteFile(ctx, key)
+	}
+
+	// A plain delete on a versioned bucket only hides the object, while some
+	// S3-compatible providers don't support versioning at all
+	enabled, err := versioned.VersioningEnabled(ctx)
+	if err != nil || !enabled {
+		return s.storage.DeleteFile(ctx, key)
+	}
+	return versioned.DeleteAllVersions(ctx, key)
+}
+
+// inBlob reports whether content stored at key with the given checksum is
+// a blob
+func inBlob(key, checksum string) bool {
+	return checksum != "" && key == storage.BlobKey(checksum)
+}
+
+// lock returns the mutex guarding checksum's blob
+func (s *BlobService) lock(checksum string) *sync.Mutex {
+	var stripe [1]byte
+	hex.Decode(stripe[:], []byte(checksum[:2]))
+	return &s.locks[stripe[0]]
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+const (
+	// directPartSize keeps the number of browser requests down for large files
+	directPartSize = 16 * 1024 * 1024
+
+	// PartURLExpiry is how long a presigned part URL stays valid
+	PartURLExpiry = time.Hour
+)
+
+var (
+	ErrUploadPartsInvalid = errors.New("uploaded parts do not match the upload")
+	ErrUploadSizeMismatch = errors.New("stored object size does not match the upload")
+)
+
+// PresignedPart is a URL the client can PUT one part to
+type PresignedPart struct {
+	PartNumber int32
+	URL        string
+}
+
+// StartDirect opens a multipart upload that the client fills by sending
+// parts straight to the bucket. Nothing is created when the backend can't
+// presign parts.
+func (s *UploadService) StartDirect(ctx context.Context, ownerID, fileName, contentType string, length int64) (*models.Upload, error) {
+	if length < 0 || length > MaxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+	if !storage.PresignUploadsSupported(s.storage) {
+		return nil, storage.ErrPresignUnsupported
+	}
+
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	upload := &models.Upload{
+		ID:          id,
+		OwnerID:     ownerID,
+		FileName:    fileName,
+		ContentType: contentType,
+		StorageKey:  storage.UserKey(ownerID, id, fileName),
+		Mode:        models.UploadModeDirect,
+		Length:      length,
+		PartSize:    partSizeFor(length, directPartSize),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	multipartID, err := s.storage.CreateMultipartUpload(ctx, upload.StorageKey, upload.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	upload.MultipartID = multipartID
+
+	if err := s.uploads.Create(ctx, upload); err != nil {
+		if abortErr := s.storage.AbortMultipartUpload(context.WithoutCancel(ctx), upload.StorageKey, multipartID); abortErr != nil {
+			log.Printf("Failed to abort multipart upload for %s: %v", upload.StorageKey, abortErr)
+		}
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// PresignParts returns upload URLs for the given part numbers
+func (s *UploadService) PresignParts(ctx context.Context, id, ownerID string, partNumbers []int32) ([]PresignedPart, error) {
+	upload, err := s.get(ctx, id, ownerID, models.UploadModeDirect)
+	if err != nil {
+		return nil, err
+	}
+
+	count := PartCount(upload)
+	parts := make([]PresignedPart, 0, len(partNumbers))
+	for _, number := range partNumbers {
+		if number < 1 || number > count {
+			return nil, fmt.Errorf("%w: part %d is outside 1-%d", ErrUploadPartsInvalid, number, count)
+		}
+
+		url, err := s.storage.PresignUploadPart(ctx, upload.StorageKey, upload.MultipartID, number, PartURLExpiry)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, PresignedPart{
+			PartNumber: number,
+			URL:        url,
+		})
+	}
+
+	return parts, nil
+}
+
+// CompleteDirect checks the client's parts against what the bucket holds,
+// assembles the object and records the file. If the parts don't line up the
+// upload stays open so the client can send the missing ones again. The
+// server never sees the bytes, so the file is recorded without a checksum.
+func (s *UploadService) CompleteDirect(ctx context.Context, id, ownerID string, parts []storage.CompletedPart) (*models.File, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.get(ctx, id, ownerID, models.UploadModeDirect)
+	if err != nil {
+		return nil, err
+	}
+
+	count := PartCount(upload)
+	if int32(len(parts)) != count {
+		return nil, fmt.Errorf("%w: expected %d parts, got %d", ErrUploadPartsInvalid, count, len(parts))
+	}
+
+	stored, err := s.storage.ListParts(ctx, upload.StorageKey, upload.MultipartID)
+	if err != nil {
+		return nil, err
+	}
+	storedByNumber := make(map[int32]storage.StoredPart, len(stored))
+	for _, part := range stored {
+		storedByNumber[part.PartNumber] = part
+	}
+
+	reported := make(map[int32]string, len(parts))
+	for _, part := range parts {
+		reported[part.PartNumber] = part.ETag
+	}
+
+	completed := make([]storage.CompletedPart, 0, count)
+	for number := int32(1); number <= count; number++ {
+		etag, ok := reported[number]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d is missing", ErrUploadPartsInvalid, number)
+		}
+
+		part, ok := storedByNumber[number]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d was never uploaded", ErrUploadPartsInvalid, number)
+		}
+		if normalizeETag(part.ETag) != normalizeETag(etag) {
+			return nil, fmt.Errorf("%w: part %d has a different ETag", ErrUploadPartsInvalid, number)
+		}
+		if part.Size != expectedPartSize(upload, number) {
+			return nil, fmt.Errorf("%w: part %d is %d bytes, expected %d", ErrUploadPartsInvalid, number, part.Size, expectedPartSize(upload, number))
+		}
+
+		completed = append(completed, storage.CompletedPart{
+			PartNumber: number,
+			ETag:       part.ETag,
+		})
+	}
+
+	if err := s.storage.CompleteMultipartUpload(ctx, upload.StorageKey, upload.MultipartID, completed); err != nil {
+		return nil, err
+	}
+
+	// The multipart upload is gone now, so a bad object can't be retried
+	size, err := s.storage.GetFileSize(ctx, upload.StorageKey)
+	if err == nil && size != upload.Length {
+		err = fmt.Errorf("%w: got %d bytes, expected %d", ErrUploadSizeMismatch, size, upload.Length)
+	}
+	if err != nil {
+		if delErr := s.storage.DeleteFile(context.WithoutCancel(ctx), upload.StorageKey); delErr != nil {
+			log.Printf("Failed to clean up %s after a bad upload: %v", upload.StorageKey, delErr)
+		}
+		s.forget(ctx, upload)
+		return nil, err
+	}
+
+	file, err := s.recordFile(ctx, upload, upload.StorageKey, "")
+	if err != nil {
+		return nil, err
+	}
+	s.forget(ctx, upload)
+
+	return file, nil
+}
+
+// AbortDirect cancels a direct upload and discards its parts
+func (s *UploadService) AbortDirect(ctx context.Context, id, ownerID string) error {
+	return s.terminate(ctx, id, ownerID, models.UploadModeDirect)
+}
+
+// PartCount is the number of parts an upload is split into. Even an empty
+// upload has one, since a multipart upload can't complete without parts.
+func PartCount(upload *models.Upload) int32 {
+	if upload.Length == 0 {
+		return 1
+	}
+	return int32((upload.Length + upload.PartSize - 1) / upload.PartSize)
+}
+
+// expectedPartSize is PartSize for every part but the last, which holds
+// whatever remains
+func expectedPartSize(upload *models.Upload, number int32) int64 {
+	if number < PartCount(upload) {
+		return upload.PartSize
+	}
+	return upload.Length - int64(number-1)*upload.PartSize
+}
+
+// normalizeETag drops the quotes S3 puts around ETags, which browsers may
+// or may not keep
+func normalizeETag(etag string) string {
+	return strings.Trim(strings.TrimSpace(etag), `"`)
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+// maxFolderNameLength matches the limit on uploaded file names
+const maxFolderNameLength = 255
+
+var (
+	ErrFolderNotFound    = errors.New("folder not found")
+	ErrFolderExists      = errors.New("a folder with that name already exists here")
+	ErrFolderNotEmpty    = errors.New("folder is not empty")
+	ErrFolderCycle       = errors.New("a folder cannot be moved inside itself")
+	ErrInvalidFolderName = errors.New("folder names must be 1-255 characters and may not contain '/' or be '.' or '..'")
+)
+
+// FolderContents counts what a folder holds, itself excluded
+type FolderContents struct {
+	Folders int
+	Files   int
+}
+
+// FolderService manages the folder tree. Folders only exist in the catalog;
+// moving files and folders around never touches the bucket.
+type FolderService struct {
+	trash   *TrashService
+	holds   *HoldService
+	folders repository.FolderRepository
+	files   repository.FileRepository
+}
+
+func NewFolderService(trash *TrashService, holds *HoldService, folders repository.FolderRepository, files repository.FileRepository) *FolderService {
+	return &FolderService{
+		trash:   trash,
+		holds:   holds,
+		folders: folders,
+		files:   files,
+	}
+}
+
+// Create adds a folder under parentID, or at the owner's root when it is empty
+func (s *FolderService) Create(ctx context.Context, ownerID, parentID, name string) (*models.Folder, error) {
+	name, err := folderName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParent(ctx, ownerID, parentID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	folder := &models.Folder{
+		ID:        uuid.New().String(),
+		OwnerID:   ownerID,
+		ParentID:  parentID,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = s.folders.Create(ctx, folder)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, ErrFolderExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+func (s *FolderService) Get(ctx context.Context, id string) (*models.Folder, error) {
+	folder, err := s.folders.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrFolderNotFound
+	}
+	return folder, err
+}
+
+// Children lists what is directly inside folderID, or the owner's root when
+// it is empty. Files come a page at a time; subfolders are all returned
+// with the first page.
+func (s *FolderService) Children(ctx context.Context, ownerID, folderID string, opts repository.ListOptions) ([]models.Folder, *repository.FilePage, error) {
+	var folders []models.Folder
+	if opts.Cursor == "" {
+		var err error
+		folders, err = s.folders.ListChildren(ctx, ownerID, folderID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	opts.Owner = ownerID
+	opts.Folder = &folderID
+	page, err := s.files.List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return folders, page, nil
+}
+
+func (s *FolderService) Rename(ctx context.Context, folder *models.Folder, name string) error {
+	name, err := folderName(name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = s.folders.Rename(ctx, folder.ID, name, now)
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrFolderExists
+	}
+	if err != nil {
+		return err
+	}
+
+	folder.Name = name
+	folder.UpdatedAt = now
+	return nil
+}
+
+// Move puts folder under parentID, or at the root when it is empty. The
+// folder keeps its contents and none of their objects are touched.
+func (s *FolderService) Move(ctx context.Context, folder *models.Folder, parentID string) error {
+	if err := s.checkParent(ctx, folder.OwnerID, parentID); err != nil {
+		return err
+	}
+	if err := s.holds.CheckFolder(ctx, folder, nil, nil); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err := s.folders.Move(ctx, folder.ID, parentID, now)
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		return ErrFolderExists
+	case errors.Is(err, repository.ErrFolderCycle):
+		return ErrFolderCycle
+	case err != nil:
+		return err
+	}
+
+	folder.ParentID = parentID
+	folder.UpdatedAt = now
+	return nil
+}
+
+// Delete removes a folder. One that isn't empty is only deleted when
+// recursive is set, in which case its subfolders go with it and its files
+// are moved to the trash; otherwise ErrFolderNotEmpty is returned with the
+// contents so the caller can confirm. Nothing is deleted if anything in the
+// folder is held.
+func (s *FolderService) Delete(ctx context.Context, folder *models.Folder, recursive bool) (*FolderContents, error) {
+	tree, err := s.folders.Tree(ctx, folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(tree))
+	for i := range tree {
+		ids[i] = tree[i].ID
+	}
+	files, err := s.files.ListInFolders(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &FolderContents{
+		Folders: len(tree) - 1,
+		Files:   len(files),
+	}
+	if !recursive && (contents.Folders > 0 || contents.Files > 0) {
+		return contents, ErrFolderNotEmpty
+	}
+	if err := s.holds.CheckFolder(ctx, folder, tree, files); err != nil {
+		return nil, err
+	}
+
+	// Files go first: if one fails the folders are still there and the
+	// delete can simply be repeated
+	for i := range files {
+		if err := s.trash.Trash(ctx, &files[i]); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	// Subfolders are removed by the cascade
+	if err := s.folders.Delete(ctx, folder.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// MoveFile puts file into folderID, or at the root when it is empty. Only
+// the catalog changes; the object keeps its key.
+func (s *FolderService) MoveFile(ctx context.Context, file *models.File, folderID string) error {
+	if err := s.checkParent(ctx, file.OwnerID, folderID); err != nil {
+		return err
+	}
+	if err := s.holds.Check(ctx, file); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := s.files.Move(ctx, file.ID, folderID, now); err != nil {
+		return err
+	}
+
+	file.FolderID = folderID
+	file.UpdatedAt = now
+	return nil
+}
+
+// checkParent makes sure a folder things are put into belongs to ownerID,
+// since every owner has a tree of their own
+func (s *FolderService) checkParent(ctx context.Context, ownerID, parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+
+	parent, err := s.Get(ctx, parentID)
+	if err != nil {
+		return err
+	}
+	if parent.OwnerID != ownerID {
+		return ErrFolderNotFound
+	}
+
+	return nil
+}
+
+// folderName trims a name and checks it can't be confused with a path
+func folderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/\\\x00") ||
+		utf8.RuneCountInString(name) > maxFolderNameLength {
+		return "", ErrInvalidFolderName
+	}
+	return name, nil
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+var (
+	ErrHeld               = errors.New("under retention or legal hold")
+	ErrRetentionShortened = errors.New("retention can be extended but not shortened")
+)
+
+// HoldService manages retention and legal holds. A hold on a folder covers
+// everything below it, and while any hold covering a file is active the
+// file can't be deleted, overwritten or moved out from under it.
+//
+// When the bucket has Object Lock enabled, holds set on files, and on the
+// files in a folder when the folder's hold is set, are also applied to
+// their objects so the bucket refuses to delete them too. Files with the
+// same content share a blob, so an object is locked for as long as any file
+// stored in it is held.
+type HoldService struct {
+	storage storage.StorageInterface
+	files   repository.FileRepository
+	folders repository.FolderRepository
+}
+
+func NewHoldService(storage storage.StorageInterface, files repository.FileRepository, folders repository.FolderRepository) *HoldService {
+	return &HoldService{
+		storage: storage,
+		files:   files,
+		folders: folders,
+	}
+}
+
+// Check returns ErrHeld if the file, or a folder it is in, is held
+func (s *HoldService) Check(ctx context.Context, file *models.File) error {
+	now := time.Now()
+	if file.Hold.Active(now) {
+		return ErrHeld
+	}
+	if file.FolderID == "" {
+		return nil
+	}
+
+	// A trashed file may still point at a folder that has since gone
+	return s.checkAncestors(ctx, file.FolderID, now)
+}
+
+// CheckFolder returns ErrHeld if the folder, a folder above or below it, or
+// one of files, the files in its tree, is held. Tree and files can be nil
+// when only what is above the folder matters, as when moving it.
+func (s *HoldService) CheckFolder(ctx context.Context, folder *models.Folder, tree []models.Folder, files []models.File) error {
+	now := time.Now()
+	if err := s.checkAncestors(ctx, folder.ID, now); err != nil {
+		return err
+	}
+
+	for i := range tree {
+		if tree[i].Hold.Active(now) {
+			return ErrHeld
+		}
+	}
+	for i := range files {
+		if files[i].Hold.Active(now) {
+			return ErrHeld
+		}
+	}
+
+	return nil
+}
+
+// SetFileHold replaces a file's hold
+func (s *HoldService) SetFileHold(ctx context.Context, file *models.File, hold models.Hold) error {
+	if err := checkRetention(file.Hold, hold); err != nil {
+		return err
+	}
+
+	// The bucket goes first so a catalog failure can only leave the object
+	// better protected than it should be
+	if err := s.lock(ctx, []models.File{*file}, holdChange{fileID: file.ID, hold: hold}); err != nil {
+		return err
+	}
+	if err := s.files.SetHold(ctx, file.ID, hold); err != nil {
+		return err
+	}
+
+	file.Hold = hold
+	return nil
+}
+
+// SetFolderHold replaces a folder's hold
+func (s *HoldService) SetFolderHold(ctx context.Context, folder *models.Folder, hold models.Hold) error {
+	if err := checkRetention(folder.Hold, hold); err != nil {
+		return err
+	}
+
+	tree, err := s.folders.Tree(ctx, folder.ID)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(tree))
+	for i := range tree {
+		ids[i] = tree[i].ID
+	}
+	files, err := s.files.ListInFolders(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	// Objects keep a file's own hold when the folder's is lifted
+	if err := s.lock(ctx, files, holdChange{folderID: folder.ID, hold: hold}); err != nil {
+		return err
+	}
+
+	err = s.folders.SetHold(ctx, folder.ID, hold)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrFolderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	folder.Hold = hold
+	return nil
+}
+
+// checkAncestors returns ErrHeld if folderID or a folder above it is held
+func (s *HoldService) checkAncestors(ctx context.Context, folderID string, now time.Time) error {
+	folders, err := s.folders.Ancestors(ctx, folderID)
+	if err != nil {
+		return err
+	}
+
+	for i := range folders {
+		if folders[i].Hold.Active(now) {
+			return ErrHeld
+		}
+	}
+
+	return nil
+}
+
+// holdChange is a hold about to replace the one on a file or a folder
+type holdChange struct {
+	fileID   string
+	folderID string
+	hold     models.Hold
+}
+
+// lock brings the lock on every stored version of files up to date with
+// change when the bucket supports Object Lock
+func (s *HoldService) lock(ctx context.Context, files []models.File, change holdChange) error {
+	locking, ok := s.storage.(storage.LockingStorage)
+	if !ok {
+		return nil
+	}
+	enabled, err := locking.ObjectLockEnabled(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	now := time.Now()
+	for _, file := range files {
+		versions, err := s.files.ListVersions(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+
+		// Restored versions can share an object with the one they restore
+		seen := make(map[[2]string]bool)
+		for _, version := range versions {
+			object := [2]string{version.StorageKey, version.StorageVersionID}
+			if seen[object] {
+				continue
+			}
+			seen[object] = true
+
+			hold, err := s.objectHold(ctx, version.StorageKey, change)
+			if err != nil {
+				return err
+			}
+
+			// The bucket rejects retention dates in the past
+			if hold.RetainUntil != nil && hold.RetainUntil.After(now) {
+				if err := locking.SetRetention(ctx, version.StorageKey, version.StorageVersionID, *hold.RetainUntil); err != nil {
+					return err
+				}
+			}
+			if err := locking.SetLegalHold(ctx, version.StorageKey, version.StorageVersionID, hold.LegalHold); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// objectHold is the hold the object at key needs once change is made: the
+// latest retention and any legal hold of every file stored in it, counting
+// the holds of the folders those files are in
+func (s *HoldService) objectHold(ctx context.Context, key string, change holdChange) (models.Hold, error) {
+	files, err := s.files.ListByStorageKey(ctx, key)
+	if err != nil {
+		return models.Hold{}, err
+	}
+
+	var hold models.Hold
+	for i := range files {
+		if files[i].ID == change.fileID {
+			hold = joinHolds(hold, change.hold)
+		} else {
+			hold = joinHolds(hold, files[i].Hold)
+		}
+		if files[i].FolderID == "" {
+			continue
+		}
+
+		folders, err := s.folders.Ancestors(ctx, files[i].FolderID)
+		if err != nil {
+			return models.Hold{}, err
+		}
+		for j := range folders {
+			if folders[j].ID == change.folderID {
+				hold = joinHolds(hold, change.hold)
+			} else {
+				hold = joinHolds(hold, folders[j].Hold)
+			}
+		}
+	}
+
+	return hold, nil
+}
+
+// joinHolds returns a hold that lasts as long as both a and b
+func joinHolds(a, b models.Hold) models.Hold {
+	if b.RetainUntil != nil && (a.RetainUntil == nil || b.RetainUntil.After(*a.RetainUntil)) {
+		a.RetainUntil = b.RetainUntil
+	}
+	a.LegalHold = a.LegalHold || b.LegalHold
+	return a
+}
+
+// checkRetention refuses to bring an active retention period forward
+func checkRetention(current, next models.Hold) error {
+	if current.RetainUntil == nil || !current.RetainUntil.After(time.Now()) {
+		return nil
+	}
+	if next.RetainUntil == nil || next.RetainUntil.Before(*current.RetainUntil) {
+		return ErrRetentionShortened
+	}
+	return nil
+}
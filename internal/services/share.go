@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/auth"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+// shareTokenLength gives ~68 bits of entropy with the short token alphabet
+const shareTokenLength = 12
+
+const (
+	// sharePasswordAttempts is how many wrong passwords a link takes within
+	// sharePasswordWindow before it stops checking them
+	sharePasswordAttempts = 5
+	sharePasswordWindow   = 15 * time.Minute
+)
+
+var (
+	ErrShareNotFound         = errors.New("share link not found")
+	ErrShareUnavailable      = errors.New("share link has expired, been revoked or reached its download limit")
+	ErrSharePasswordRequired = errors.New("this link is password protected")
+	ErrSharePasswordInvalid  = errors.New("incorrect password")
+	ErrSharePasswordLocked   = errors.New("too many incorrect passwords, try again later")
+)
+
+// ShareOptions are the optional restrictions on a new share link
+type ShareOptions struct {
+	ExpiresAt    *time.Time
+	Password     string
+	MaxDownloads *int
+}
+
+// ShareService creates, resolves and revokes public share links. Wrong
+// passwords are counted per link within this process, so a link's password
+// can't be guessed faster than sharePasswordAttempts per window.
+type ShareService struct {
+	shares repository.ShareRepository
+	files  repository.FileRepository
+
+	mu       sync.Mutex
+	failures map[string]*passwordFailures // link id -> recent wrong passwords
+}
+
+// passwordFailures counts a link's wrong passwords since the window began
+type passwordFailures struct {
+	count int
+	since time.Time
+}
+
+func NewShareService(shares repository.ShareRepository, files repository.FileRepository) *ShareService {
+	return &ShareService{
+		shares:   shares,
+		files:    files,
+		failures: make(map[string]*passwordFailures),
+	}
+}
+
+// Create makes a new link to file. The plaintext token is only returned here.
+func (s *ShareService) Create(ctx context.Context, file *models.File, createdBy string, opts ShareOptions) (*models.ShareLink, string, error) {
+	token, err := auth.NewShortToken(shareTokenLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	link := &models.ShareLink{
+		ID:           uuid.New().String(),
+		FileID:       file.ID,
+		CreatedBy:    createdBy,
+		TokenHash:    auth.HashOpaqueToken(token),
+		ExpiresAt:    opts.ExpiresAt,
+		MaxDownloads: opts.MaxDownloads,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if opts.Password != "" {
+		link.PasswordHash, err = auth.HashPassword(opts.Password)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := s.shares.Create(ctx, link); err != nil {
+		return nil, "", err
+	}
+
+	return link, token, nil
+}
+
+func (s *ShareService) List(ctx context.Context, fileID string) ([]models.ShareLink, error) {
+	return s.shares.ListByFile(ctx, fileID)
+}
+
+// Revoke disables a link on fileID so it can no longer be opened
+func (s *ShareService) Revoke(ctx context.Context, fileID, shareID string) error {
+	link, err := s.shares.GetByID(ctx, shareID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && link.FileID != fileID) {
+		return ErrShareNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.shares.Revoke(ctx, link.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Already revoked; revoking again is a no-op
+		return nil
+	}
+	return err
+}
+
+// Open checks a link's rules and password and returns it with the shared
+// file. The download isn't counted until Consume, so one that can't be
+// served doesn't use the link up.
+func (s *ShareService) Open(ctx context.Context, token, password string) (*models.ShareLink, *models.File, error) {
+	link, err := s.shares.GetByTokenHash(ctx, auth.HashOpaqueToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if !ShareActive(link, now) {
+		return nil, nil, ErrShareUnavailable
+	}
+
+	if link.PasswordHash != "" {
+		if password == "" {
+			return nil, nil, ErrSharePasswordRequired
+		}
+		if err := s.checkPassword(link, password, now); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	file, err := s.files.GetByID(ctx, link.FileID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return link, file, nil
+}
+
+// Consume counts a download against an opened link. It fails with
+// ErrShareUnavailable if the link ran out or was revoked in the meantime.
+func (s *ShareService) Consume(ctx context.Context, link *models.ShareLink) error {
+	err := s.shares.ConsumeDownload(ctx, link.ID, time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrShareUnavailable
+	}
+	return err
+}
+
+// checkPassword compares password with the link's, refusing to once the
+// link has seen too many wrong ones
+func (s *ShareService) checkPassword(link *models.ShareLink, password string, now time.Time) error {
+	s.mu.Lock()
+	failures := s.failures[link.ID]
+	if failures != nil && now.Sub(failures.since) >= sharePasswordWindow {
+		delete(s.failures, link.ID)
+		failures = nil
+	}
+	locked := failures != nil && failures.count >= sharePasswordAttempts
+	s.mu.Unlock()
+	if locked {
+		return ErrSharePasswordLocked
+	}
+
+	if auth.CheckPassword(link.PasswordHash, password) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failures = s.failures[link.ID]; failures == nil {
+		s.forgetFailures(now)
+		failures = &passwordFailures{since: now}
+		s.failures[link.ID] = failures
+	}
+	failures.count++
+	return ErrSharePasswordInvalid
+}
+
+// forgetFailures drops counts whose window has passed. Callers hold s.mu.
+func (s *ShareService) forgetFailures(now time.Time) {
+	for id, failures := range s.failures {
+		if now.Sub(failures.since) >= sharePasswordWindow {
+			delete(s.failures, id)
+		}
+	}
+}
+
+// ShareActive reports whether a link can still be opened at now
+func ShareActive(link *models.ShareLink, now time.Time) bool {
+	if link.RevokedAt != nil {
+		return false
+	}
+	if link.ExpiresAt != nil && !now.Before(*link.ExpiresAt) {
+		return false
+	}
+	if link.MaxDownloads != nil && link.DownloadCount >= *link.MaxDownloads {
+		return false
+	}
+	return true
+}
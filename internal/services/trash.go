@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+// purgeBatchSize is how many expired files the purger loads at a time
+const purgeBatchSize = 100
+
+var ErrNotInTrash = errors.New("file not found in trash")
+
+// TrashService keeps deleted files restorable for the retention period.
+// Their objects stay in storage until the purger, or an explicit delete
+// from the trash, removes them for good.
+type TrashService struct {
+	versions  *VersionService
+	blobs     *BlobService
+	holds     *HoldService
+	files     repository.FileRepository
+	folders   repository.FolderRepository
+	retention time.Duration
+}
+
+func NewTrashService(versions *VersionService, blobs *BlobService, holds *HoldService, files repository.FileRepository, folders repository.FolderRepository, retention time.Duration) *TrashService {
+	return &TrashService{
+		versions:  versions,
+		blobs:     blobs,
+		holds:     holds,
+		files:     files,
+		folders:   folders,
+		retention: retention,
+	}
+}
+
+// Trash moves a file to its owner's trash
+func (s *TrashService) Trash(ctx context.Context, file *models.File) error {
+	if err := s.holds.Check(ctx, file); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := s.files.Trash(ctx, file.ID, now); err != nil {
+		return err
+	}
+
+	file.DeletedAt = &now
+	return nil
+}
+
+// Get returns a file that is in the trash
+func (s *TrashService) Get(ctx context.Context, id string) (*models.File, error) {
+	file, err := s.files.GetTrashed(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrNotInTrash
+	}
+	return file, err
+}
+
+// List returns a page of ownerID's trash
+func (s *TrashService) List(ctx context.Context, ownerID string, opts repository.ListOptions) (*repository.FilePage, error) {
+	opts.Owner = ownerID
+	opts.Trashed = true
+	return s.files.List(ctx, opts)
+}
+
+// PurgeAt is when a trashed file will be removed for good
+func (s *TrashService) PurgeAt(file *models.File) time.Time {
+	if file.DeletedAt == nil {
+		return time.Time{}
+	}
+	return file.DeletedAt.Add(s.retention)
+}
+
+// Restore takes a file out of the trash, back into its folder if that still
+// exists and to the owner's root otherwise
+func (s *TrashService) Restore(ctx context.Context, file *models.File) error {
+	folderID := file.FolderID
+	if folderID != "" {
+		_, err := s.folders.GetByID(ctx, folderID)
+		if errors.Is(err, repository.ErrNotFound) {
+			folderID = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	err := s.files.Restore(ctx, file.ID, folderID, now)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNotInTrash
+	}
+	if err != nil {
+		return err
+	}
+
+	file.FolderID = folderID
+	file.DeletedAt = nil
+	file.UpdatedAt = now
+	return nil
+}
+
+// Delete removes a file and every version of it for good
+func (s *TrashService) Delete(ctx context.Context, file *models.File) error {
+	if err := s.holds.Check(ctx, file); err != nil {
+		return err
+	}
+
+	if err := s.versions.Purge(ctx, file); err != nil {
+		return err
+	}
+
+	if err := s.files.Delete(ctx, file.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	// The file is gone either way; a blob that can't be deleted now is
+	// picked up by the purger
+	if _, err := s.blobs.Collect(ctx); err != nil {
+		log.Printf("Failed to delete unused blobs after deleting file %s: %v", file.ID, err)
+	}
+
+	return nil
+}
+
+// PurgeExpired deletes every file whose retention ran out before now and
+// returns how many went. Held files are kept, and a file that fails is
+// logged and left for the next run so it can't hold up the rest.
+func (s *TrashService) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	before := now.Add(-s.retention)
+	purged, skipped := 0, 0
+	for {
+		// Files that were skipped are still at the front of the list
+		files, err := s.files.ListTrashedBefore(ctx, before, skipped, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		for i := range files {
+			err := s.Delete(ctx, &files[i])
+			switch {
+			case err == nil:
+				purged++
+				continue
+			case ctx.Err() != nil:
+				return purged, ctx.Err()
+			case !errors.Is(err, ErrHeld):
+				log.Printf("Failed to purge file %s from trash: %v", files[i].ID, err)
+			}
+			skipped++
+		}
+
+		if len(files) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RunPurger calls PurgeExpired every interval until ctx is cancelled, and
+// deletes any blob a failed delete left behind
+func (s *TrashService) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.PurgeExpired(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to purge trash: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("🗑️  Purged %d expired files from trash", purged)
+		}
+		if _, err := s.blobs.Collect(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to delete unused blobs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
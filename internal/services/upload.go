@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+// MaxUploadSize is the largest object S3 will store
+const MaxUploadSize int64 = 5 << 40
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadTooLarge       = errors.New("upload exceeds its declared length")
+)
+
+// UploadService stores resumable uploads. Bytes arrive in chunks of any size
+// and are cut into parts of a multipart upload; whatever is left over after
+// a chunk is kept as a tail object until the next chunk completes the part.
+// Parts are gathered in a temp file rather than in memory, since they grow
+// to hundreds of MiB for the largest uploads. The bytes are hashed as they
+// arrive, with the hash state saved alongside the offset, so the finished
+// file gets a checksum without being read back.
+//
+// Finished uploads share blobs with other files where they can. One that
+// never filled a part is stored as a blob outright. A multipart one is
+// assembled under its own key, so it is swapped for a blob only when one
+// with the same content already exists; otherwise it stays where it is,
+// since moving it would mean copying the whole object.
+type UploadService struct {
+	storage storage.StorageInterface
+	uploads repository.UploadRepository
+	files   repository.FileRepository
+	blobs   *BlobService
+	locks   sync.Map // upload id -> *sync.Mutex
+}
+
+func NewUploadService(storage storage.StorageInterface, uploads repository.UploadRepository, files repository.FileRepository, blobs *BlobService) *UploadService {
+	return &UploadService{
+		storage: storage,
+		uploads: uploads,
+		files:   files,
+		blobs:   blobs,
+	}
+}
+
+// Create starts an upload of length bytes. An empty upload is finished at
+// once, so the returned upload's Offset already equals its Length.
+func (s *UploadService) Create(ctx context.Context, ownerID, fileName, contentType string, length int64) (*models.Upload, error) {
+	if length < 0 || length > MaxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	upload := &models.Upload{
+		ID:          id,
+		OwnerID:     ownerID,
+		FileName:    fileName,
+		ContentType: contentType,
+		StorageKey:  storage.UserKey(ownerID, id, fileName),
+		Mode:        models.UploadModeTus,
+		Length:      length,
+		PartSize:    partSizeFor(length, storage.MinPartSize),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if length == 0 {
+		if _, err := s.finish(ctx, upload, nil, strings.NewReader(""), 0, sha256.New()); err != nil {
+			return nil, err
+		}
+		return upload, nil
+	}
+
+	if err := s.uploads.Create(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// Get returns a tus upload owned by ownerID
+func (s *UploadService) Get(ctx context.Context, id, ownerID string) (*models.Upload, error) {
+	return s.get(ctx, id, ownerID, models.UploadModeTus)
+}
+
+func (s *UploadService) get(ctx context.Context, id, ownerID string, mode models.UploadMode) (*models.Upload, error) {
+	upload, err := s.uploads.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && (upload.OwnerID != ownerID || upload.Mode != mode)) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// Write appends body to the upload at offset. size is the length of body
+// when known, or -1. Progress is saved even if body fails part way, so the
+// client can ask for the offset and carry on. Once the last byte arrives the
+// object is assembled and the new file is returned.
+func (s *UploadService) Write(ctx context.Context, id, ownerID string, offset, size int64, body io.Reader) (*models.Upload, *models.File, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.Get(ctx, id, ownerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != upload.Offset {
+		return upload, nil, ErrUploadOffsetMismatch
+	}
+	if size > upload.Length-upload.Offset {
+		return upload, nil, ErrUploadTooLarge
+	}
+
+	parts, err := s.uploads.ListParts(ctx, upload.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stored int64
+	for _, part := range parts {
+		stored += part.Size
+	}
+
+	digest, err := resumeHash(upload.HashState)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spool, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	pending := upload.Offset - stored
+	if pending > 0 {
+		if err := s.readTail(ctx, upload, spool, pending); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	n := pending
+	// The tail was hashed when it arrived, so only new bytes go into the hash
+	src := io.TeeReader(io.LimitReader(body, upload.Length-upload.Offset), digest)
+	var readErr error
+	for {
+		copied, err := io.CopyN(spool, src, upload.PartSize-n)
+		n += copied
+
+		// Only full parts are sent as they fill up; the final part waits
+		// for finish so it can complete the upload in the same step
+		if n == upload.PartSize && stored+n < upload.Length {
+			part, err := s.storePart(ctx, upload, int32(len(parts)+1), io.NewSectionReader(spool, 0, n), n, stored+n, digest)
+			if err != nil {
+				return nil, nil, err
+			}
+			parts = append(parts, *part)
+			stored += part.Size
+			n = 0
+			if err := rewind(spool); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			readErr = err
+		}
+		break
+	}
+
+	newOffset := stored + n
+	if newOffset == upload.Length {
+		file, err := s.finish(ctx, upload, parts, io.NewSectionReader(spool, 0, n), n, digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		upload.Offset = newOffset
+		return upload, file, nil
+	}
+
+	if newOffset != upload.Offset {
+		if n > 0 {
+			tail := io.NewSectionReader(spool, 0, n)
+			if err := s.storage.UploadFile(ctx, tailKey(upload.ID), tail, n, "application/octet-stream"); err != nil {
+				return nil, nil, err
+			}
+		} else if pending > 0 {
+			// The old tail went into a part
+			s.deleteTail(ctx, upload.ID)
+		}
+
+		state, err := marshalHash(digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s.uploads.UpdateOffset(ctx, upload.ID, newOffset, state); err != nil {
+			return nil, nil, err
+		}
+		upload.Offset = newOffset
+	}
+
+	if readErr != nil {
+		return upload, nil, fmt.Errorf("upload interrupted at offset %d: %w", upload.Offset, readErr)
+	}
+
+	return upload, nil, nil
+}
+
+// Terminate abandons a tus upload and frees everything stored for it
+func (s *UploadService) Terminate(ctx context.Context, id, ownerID string) error {
+	return s.terminate(ctx, id, ownerID, models.UploadModeTus)
+}
+
+func (s *UploadService) terminate(ctx context.Context, id, ownerID string, mode models.UploadMode) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.get(ctx, id, ownerID, mode)
+	if err != nil {
+		return err
+	}
+
+	if upload.MultipartID != "" {
+		if err := s.storage.AbortMultipartUpload(ctx, upload.StorageKey, upload.MultipartID); err != nil {
+			return err
+		}
+	}
+	if upload.Mode == models.UploadModeTus {
+		s.deleteTail(ctx, upload.ID)
+	}
+
+	if err := s.uploads.Delete(ctx, upload.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	s.locks.Delete(upload.ID)
+
+	return nil
+}
+
+// storePart sends one full part, starting the multipart upload if needed,
+// and records it along with the offset it brings the upload to and the hash
+// of everything before that offset
+func (s *UploadService) storePart(ctx context.Context, upload *models.Upload, number int32, data io.Reader, size, offset int64, digest hash.Hash) (*models.UploadPart, error) {
+	if upload.MultipartID == "" {
+		multipartID, err := s.storage.CreateMultipartUpload(ctx, upload.StorageKey, upload.ContentType)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.uploads.SetMultipartID(ctx, upload.ID, multipartID); err != nil {
+			return nil, err
+		}
+		upload.MultipartID = multipartID
+	}
+
+	etag, err := s.storage.UploadPart(ctx, upload.StorageKey, upload.MultipartID, number, data, size)
+	if err != nil {
+		return nil, err
+	}
+
+	part := &models.UploadPart{
+		UploadID:   upload.ID,
+		PartNumber: number,
+		ETag:       etag,
+		Size:       size,
+	}
+	state, err := marshalHash(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.uploads.AddPart(ctx, part, offset, state); err != nil {
+		return nil, err
+	}
+
+	return part, nil
+}
+
+// finish writes the last size bytes, assembles the object and records the
+// file with the checksum of everything hashed. Uploads that never filled a
+// part are written with a single PUT.
+func (s *UploadService) finish(ctx context.Context, upload *models.Upload, parts []models.UploadPart, last io.Reader, size int64, digest hash.Hash) (*models.File, error) {
+	checksum := hex.EncodeToString(digest.Sum(nil))
+
+	key := upload.StorageKey
+	if upload.MultipartID == "" {
+		var err error
+		key, err = s.blobs.Store(ctx, last, size, upload.ContentType, checksum)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if size > 0 {
+			part, err := s.storePart(ctx, upload, int32(len(parts)+1), last, size, upload.Length, digest)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, *part)
+		}
+
+		completed := make([]storage.CompletedPart, 0, len(parts))
+		for _, part := range parts {
+			completed = append(completed, storage.CompletedPart{
+				PartNumber: part.PartNumber,
+				ETag:       part.ETag,
+			})
+		}
+		if err := s.storage.CompleteMultipartUpload(ctx, upload.StorageKey, upload.MultipartID, completed); err != nil {
+			return nil, err
+		}
+		key = s.adopt(ctx, upload, checksum)
+	}
+
+	file, err := s.recordFile(ctx, upload, key, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.Length > 0 {
+		s.deleteTail(ctx, upload.ID)
+		s.forget(ctx, upload)
+	}
+
+	return file, nil
+}
+
+// adopt swaps an assembled upload for the blob that already holds the same
+// content, if there is one, and returns the key the file should use
+func (s *UploadService) adopt(ctx context.Context, upload *models.Upload, checksum string) string {
+	err := s.blobs.Retain(ctx, checksum)
+	if errors.Is(err, repository.ErrNotFound) {
+		return upload.StorageKey
+	}
+	if err != nil {
+		log.Printf("Failed to look up blob for upload %s: %v", upload.ID, err)
+		return upload.StorageKey
+	}
+
+	if err := s.blobs.deleteObject(ctx, upload.StorageKey); err != nil {
+		log.Printf("Failed to delete %s after finding its blob: %v", upload.StorageKey, err)
+	}
+	return storage.BlobKey(checksum)
+}
+
+// recordFile adds a finished upload's object, stored at key, to the catalog.
+// checksum is empty when the server never saw the content.
+func (s *UploadService) recordFile(ctx context.Context, upload *models.Upload, key, checksum string) (*models.File, error) {
+	now := time.Now().UTC()
+	file := &models.File{
+		ID:          upload.ID,
+		FileName:    upload.FileName,
+		StorageKey:  key,
+		FileSize:    upload.Length,
+		ContentType: upload.ContentType,
+		SHA256:      checksum,
+		OwnerID:     upload.OwnerID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.files.Create(ctx, file); err != nil {
+		// Don't leave an object behind that the catalog knows nothing about,
+		// even when the request has gone away
+		cleanupCtx := context.WithoutCancel(ctx)
+		if inBlob(key, checksum) {
+			if relErr := s.blobs.Release(cleanupCtx, checksum); relErr != nil {
+				log.Printf("Failed to release blob %s after catalog error: %v", checksum, relErr)
+			}
+		} else if delErr := s.storage.DeleteFile(cleanupCtx, key); delErr != nil {
+			log.Printf("Failed to clean up %s after catalog error: %v", key, delErr)
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// forget drops the session of an upload that has become a file
+func (s *UploadService) forget(ctx context.Context, upload *models.Upload) {
+	if err := s.uploads.Delete(ctx, upload.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to remove finished upload %s: %v", upload.ID, err)
+	}
+	s.locks.Delete(upload.ID)
+}
+
+// readTail copies the size bytes waiting in the upload's tail to dst
+func (s *UploadService) readTail(ctx context.Context, upload *models.Upload, dst io.Writer, size int64) error {
+	tail, err := s.storage.GetFile(ctx, tailKey(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+
+	if _, err := io.CopyN(dst, tail, size); err != nil {
+		return fmt.Errorf("failed to read upload tail: %w", err)
+	}
+
+	return nil
+}
+
+// resumeHash picks up a SHA-256 from the state saved with an upload's offset
+func resumeHash(state []byte) (hash.Hash, error) {
+	digest := sha256.New()
+	if len(state) == 0 {
+		return digest, nil
+	}
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("failed to resume upload hash: %w", err)
+	}
+	return digest, nil
+}
+
+// marshalHash saves a hash's state so a later request can carry it on
+func marshalHash(digest hash.Hash) ([]byte, error) {
+	state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload hash: %w", err)
+	}
+	return state, nil
+}
+
+// rewind empties a spool file to gather the next part
+func rewind(spool *os.File) error {
+	if err := spool.Truncate(0); err != nil {
+		return err
+	}
+	_, err := spool.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *UploadService) deleteTail(ctx context.Context, uploadID string) {
+	if err := s.storage.DeleteFile(ctx, tailKey(uploadID)); err != nil {
+		log.Printf("Failed to delete tail of upload %s: %v", uploadID, err)
+	}
+}
+
+// lock serializes writes to one upload within this process
+func (s *UploadService) lock(id string) func() {
+	value, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// tailKey is where bytes that don't yet fill a part wait between requests
+func tailKey(uploadID string) string {
+	return fmt.Sprintf("uploads/%s.tail", uploadID)
+}
+
+// partSizeFor picks the smallest part size of at least minSize, in whole
+// MiB, that fits length into the multipart part limit
+func partSizeFor(length, minSize int64) int64 {
+	size := minSize
+	if needed := (length + storage.MaxParts - 1) / storage.MaxParts; needed > size {
+		const mib = 1024 * 1024
+		size = (needed + mib - 1) / mib * mib
+	}
+	return size
+}
@@ -1 +1,59 @@
-package services
\ No newline at end of file
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+)
+
+var (
+	ErrInvalidRole  = errors.New("role must be admin, member or viewer")
+	ErrLastAdmin    = errors.New("cannot remove the last admin")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// UserService handles account administration
+type UserService struct {
+	users repository.UserRepository
+}
+
+func NewUserService(users repository.UserRepository) *UserService {
+	return &UserService{
+		users: users,
+	}
+}
+
+func (s *UserService) List(ctx context.Context) ([]models.User, error) {
+	return s.users.List(ctx)
+}
+
+// SetRole changes a user's role, refusing to demote the only remaining admin
+func (s *UserService) SetRole(ctx context.Context, userID string, role models.Role) (*models.User, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := s.users.UpdateRole(ctx, user.ID, role)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return nil, ErrLastAdmin
+	}
+
+	user.Role = role
+	return user, nil
+}
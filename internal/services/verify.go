@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+// verifyBatchSize is how many versions Verify reads from the catalog at once
+const verifyBatchSize = 500
+
+// What Verify can find wrong with a stored version
+const (
+	ObjectMissing    = "missing"
+	ObjectCorrupted  = "corrupted"
+	ObjectUnreadable = "unreadable"
+)
+
+// ObjectProblem is a stored version whose object isn't what the catalog
+// says it should be
+type ObjectProblem struct {
+	Version models.FileVersion
+	Problem string // ObjectMissing, ObjectCorrupted or ObjectUnreadable
+	Detail  string
+}
+
+// VerifyReport sums up a Verify run
+type VerifyReport struct {
+	Checked   int // versions whose content was compared with their checksum
+	Unchecked int // versions stored without a checksum, only checked to exist at the right size
+	Problems  int
+}
+
+// Verify re-reads the object behind every version in the catalog, trashed
+// files included, and calls found for each one that is missing, can't be
+// read or doesn't match its recorded size and checksum
+func (s *VersionService) Verify(ctx context.Context, found func(ObjectProblem)) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	afterFileID, afterVersion := "", 0
+	for {
+		versions, err := s.files.ListAllVersions(ctx, afterFileID, afterVersion, verifyBatchSize)
+		if err != nil {
+			return report, err
+		}
+
+		for i := range versions {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			if versions[i].SHA256 == "" {
+				report.Unchecked++
+			} else {
+				report.Checked++
+			}
+			if problem := s.verify(ctx, &versions[i]); problem != nil {
+				report.Problems++
+				found(*problem)
+			}
+		}
+
+		if len(versions) < verifyBatchSize {
+			return report, nil
+		}
+		last := versions[len(versions)-1]
+		afterFileID, afterVersion = last.FileID, last.Version
+	}
+}
+
+// verify checks one version's object, returning nil when it is intact
+func (s *VersionService) verify(ctx context.Context, version *models.FileVersion) *ObjectProblem {
+	problem := func(kind, format string, args ...any) *ObjectProblem {
+		return &ObjectProblem{Version: *version, Problem: kind, Detail: fmt.Sprintf(format, args...)}
+	}
+
+	reader, err := s.open(ctx, version)
+	if errors.Is(err, storage.ErrNotFound) {
+		return problem(ObjectMissing, "object %s not found", version.StorageKey)
+	}
+	if err != nil {
+		return problem(ObjectUnreadable, "%v", err)
+	}
+	checksum, size, err := storage.Checksum(reader)
+	reader.Close()
+	if err != nil {
+		return problem(ObjectUnreadable, "%v", err)
+	}
+
+	if size != version.FileSize {
+		return problem(ObjectCorrupted, "object is %d bytes, expected %d", size, version.FileSize)
+	}
+	if version.SHA256 == "" {
+		return nil
+	}
+	if checksum != version.SHA256 {
+		return problem(ObjectCorrupted, "content has sha256 %s, expected %s", checksum, version.SHA256)
+	}
+
+	// The digest kept with the object should agree with the catalog's too
+	if checksums, ok := s.storage.(storage.ChecksumStorage); ok {
+		stored, err := checksums.Checksum(ctx, version.StorageKey, version.StorageVersionID)
+		if err != nil {
+			return problem(ObjectUnreadable, "%v", err)
+		}
+		if stored != "" && stored != version.SHA256 {
+			return problem(ObjectCorrupted, "object metadata has sha256 %s, expected %s", stored, version.SHA256)
+		}
+	}
+
+	return nil
+}
+
+// open reads a version's object, from the bucket's version when it has one
+func (s *VersionService) open(ctx context.Context, version *models.FileVersion) (io.ReadCloser, error) {
+	if version.StorageVersionID == "" {
+		return s.storage.GetFile(ctx, version.StorageKey)
+	}
+
+	versioned, ok := s.storage.(storage.VersionedStorage)
+	if !ok {
+		return nil, fmt.Errorf("version %d of file %s is kept by a bucket version this backend can't read", version.Version, version.FileID)
+	}
+	return versioned.GetFileVersion(ctx, version.StorageKey, version.StorageVersionID)
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/repository"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+var (
+	ErrVersionNotFound = errors.New("version not found")
+	ErrVersionConflict = errors.New("another version of this file was saved at the same time")
+)
+
+// VersionService keeps the history of each file's content. Revisions whose
+// checksum is known are stored in blobs shared with any other file that has
+// the same content. Otherwise, when the bucket keeps versions itself every
+// revision is written to the file's one key and the catalog records the
+// bucket's version ids, and without that each revision gets a key of its own.
+type VersionService struct {
+	storage storage.StorageInterface
+	files   repository.FileRepository
+	blobs   *BlobService
+	holds   *HoldService
+}
+
+func NewVersionService(storage storage.StorageInterface, files repository.FileRepository, blobs *BlobService, holds *HoldService) *VersionService {
+	return &VersionService{
+		storage: storage,
+		files:   files,
+		blobs:   blobs,
+		holds:   holds,
+	}
+}
+
+// Upload stores new content for file and makes it the current version.
+// checksum is the content's hex SHA-256, or empty when it isn't known.
+func (s *VersionService) Upload(ctx context.Context, file *models.File, uploaderID string, reader io.Reader, size int64, contentType, checksum string) (*models.FileVersion, error) {
+	if err := s.holds.Check(ctx, file); err != nil {
+		return nil, err
+	}
+
+	version := &models.FileVersion{
+		FileID:      file.ID,
+		Version:     file.Version + 1,
+		FileSize:    size,
+		ContentType: contentType,
+		SHA256:      checksum,
+		UploadedBy:  uploaderID,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if checksum != "" {
+		key, err := s.blobs.Store(ctx, reader, size, contentType, checksum)
+		if err != nil {
+			return nil, err
+		}
+		version.StorageKey = key
+	} else if versioned := s.versioned(ctx); versioned != nil {
+		if err := s.recordCurrentVersion(ctx, versioned, file.ID, file.StorageKey); err != nil {
+			return nil, err
+		}
+		versionID, err := versioned.UploadFileVersion(ctx, file.StorageKey, reader, size, contentType)
+		if err != nil {
+			return nil, err
+		}
+		version.StorageKey = file.StorageKey
+		version.StorageVersionID = versionID
+	} else {
+		// A fresh id keeps racing uploads from writing to the same key
+		version.StorageKey = storage.UserKey(file.OwnerID, uuid.New().String(), file.FileName)
+		if err := s.storage.UploadFile(ctx, version.StorageKey, reader, size, contentType); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.add(ctx, file, version); err != nil {
+		s.discard(context.WithoutCancel(ctx), version)
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// List returns a file's history, newest first
+func (s *VersionService) List(ctx context.Context, fileID string) ([]models.FileVersion, error) {
+	return s.files.ListVersions(ctx, fileID)
+}
+
+func (s *VersionService) Get(ctx context.Context, fileID string, version int) (*models.FileVersion, error) {
+	fileVersion, err := s.files.GetVersion(ctx, fileID, version)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	return fileVersion, err
+}
+
+// URL returns a presigned link to one version's content
+func (s *VersionService) URL(ctx context.Context, version *models.FileVersion, opts storage.PresignOptions) (string, error) {
+	if version.StorageVersionID == "" {
+		return s.storage.GetPresignedURL(ctx, version.StorageKey, opts)
+	}
+
+	versioned, ok := s.storage.(storage.VersionedStorage)
+	if !ok {
+		return "", fmt.Errorf("version %d of file %s is kept by a bucket version this backend can't read", version.Version, version.FileID)
+	}
+	return versioned.GetPresignedVersionURL(ctx, version.StorageKey, version.StorageVersionID, opts)
+}
+
+// Restore makes an earlier version current again by adding a copy of it to
+// the top of the history, so nothing in between is lost. Without bucket
+// versioning the copy shares the earlier version's object, or its blob.
+func (s *VersionService) Restore(ctx context.Context, file *models.File, previous *models.FileVersion, uploaderID string) (*models.FileVersion, error) {
+	if err := s.holds.Check(ctx, file); err != nil {
+		return nil, err
+	}
+
+	version := &models.FileVersion{
+		FileID:      file.ID,
+		Version:     file.Version + 1,
+		StorageKey:  previous.StorageKey,
+		FileSize:    previous.FileSize,
+		ContentType: previous.ContentType,
+		SHA256:      previous.SHA256,
+		UploadedBy:  uploaderID,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if previous.StorageVersionID != "" {
+		versioned, ok := s.storage.(storage.VersionedStorage)
+		if !ok {
+			return nil, fmt.Errorf("version %d of file %s is kept by a bucket version this backend can't read", previous.Version, file.ID)
+		}
+		if err := s.recordCurrentVersion(ctx, versioned, file.ID, previous.StorageKey); err != nil {
+			return nil, err
+		}
+		versionID, err := versioned.RestoreVersion(ctx, previous.StorageKey, previous.StorageVersionID)
+		if err != nil {
+			return nil, err
+		}
+		version.StorageVersionID = versionID
+	} else if inBlob(previous.StorageKey, previous.SHA256) {
+		if err := s.blobs.Retain(ctx, previous.SHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.add(ctx, file, version); err != nil {
+		if version.StorageVersionID != "" || inBlob(version.StorageKey, version.SHA256) {
+			s.discard(context.WithoutCancel(ctx), version)
+		}
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// Purge deletes every object holding any version of file, other than the
+// blobs, which are given back when the caller removes the catalog rows
+func (s *VersionService) Purge(ctx context.Context, file *models.File) error {
+	versions, err := s.files.ListVersions(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+
+	// Restored versions may share an object, and the current one is always
+	// included in case the history is missing
+	keys := make(map[string]bool)
+	if !inBlob(file.StorageKey, file.SHA256) {
+		keys[file.StorageKey] = false
+	}
+	for _, version := range versions {
+		if inBlob(version.StorageKey, version.SHA256) {
+			continue
+		}
+		keys[version.StorageKey] = keys[version.StorageKey] || version.StorageVersionID != ""
+	}
+
+	versioned, _ := s.storage.(storage.VersionedStorage)
+	enabled := s.versioned(ctx) != nil
+	for key, hasVersions := range keys {
+		// A plain delete on a versioned bucket only hides the object
+		if versioned != nil && (enabled || hasVersions) {
+			err = versioned.DeleteAllVersions(ctx, key)
+		} else {
+			err = s.storage.DeleteFile(ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add records version and updates file to match
+func (s *VersionService) add(ctx context.Context, file *models.File, version *models.FileVersion) error {
+	err := s.files.AddVersion(ctx, version)
+	if errors.Is(err, repository.ErrConflict) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	file.Version = version.Version
+	file.StorageKey = version.StorageKey
+	file.FileSize = version.FileSize
+	file.ContentType = version.ContentType
+	file.SHA256 = version.SHA256
+	file.UpdatedAt = version.CreatedAt
+	return nil
+}
+
+// discard removes the object written for a version the catalog turned down,
+// or gives back its reference to a blob
+func (s *VersionService) discard(ctx context.Context, version *models.FileVersion) {
+	var err error
+	if inBlob(version.StorageKey, version.SHA256) {
+		err = s.blobs.Release(ctx, version.SHA256)
+	} else if versioned, ok := s.storage.(storage.VersionedStorage); ok && version.StorageVersionID != "" {
+		err = versioned.DeleteVersion(ctx, version.StorageKey, version.StorageVersionID)
+	} else {
+		err = s.storage.DeleteFile(ctx, version.StorageKey)
+	}
+	if err != nil {
+		log.Printf("Failed to clean up version %d of file %s after catalog error: %v", version.Version, version.FileID, err)
+	}
+}
+
+// recordCurrentVersion notes the bucket's id for the object at key before it
+// is overwritten. Files uploaded in one piece, or before versioning was
+// turned on, don't have it in the catalog yet.
+func (s *VersionService) recordCurrentVersion(ctx context.Context, versioned storage.VersionedStorage, fileID, key string) error {
+	versionID, err := versioned.CurrentVersion(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.files.SetStorageVersionID(ctx, fileID, key, versionID)
+}
+
+// versioned returns the backend if its bucket currently keeps versions
+func (s *VersionService) versioned(ctx context.Context) storage.VersionedStorage {
+	versioned, ok := s.storage.(storage.VersionedStorage)
+	if !ok {
+		return nil
+	}
+
+	enabled, err := versioned.VersioningEnabled(ctx)
+	if err != nil {
+		// Some S3-compatible providers don't support versioning at all
+		log.Printf("Failed to check bucket versioning, keeping versions in the catalog: %v", err)
+		return nil
+	}
+	if !enabled {
+		return nil
+	}
+
+	return versioned
+}
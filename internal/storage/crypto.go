@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorrupted is returned, possibly wrapped, when an encrypted object fails
+// authentication: it was damaged, truncated or sealed with another key
+var ErrCorrupted = errors.New("encrypted object is damaged")
+
+// keySize is the length of master and data keys, for AES-256
+const keySize = 32
+
+// Objects are sealed in segments so they can be streamed both ways without
+// holding them in memory. Every stream starts with a random nonce prefix;
+// each segment's nonce is that prefix, the segment's number and a flag set
+// on the last one, so segments can't be reordered, dropped or cut short
+// without the object failing to open.
+const (
+	segmentSize     = 64 * 1024
+	noncePrefixSize = 7
+	tagSize         = 16
+)
+
+// Keyring holds the master keys data keys are wrapped with. New keys are
+// always wrapped with the current one; the previous ones can still unwrap
+// keys that haven't been rotated yet.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring takes base64 encoded 32 byte keys
+func NewKeyring(current string, previous []string) (*Keyring, error) {
+	ring := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	id, err := ring.add(current)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	ring.current = id
+
+	for i, key := range previous {
+		if _, err := ring.add(key); err != nil {
+			return nil, fmt.Errorf("invalid previous encryption key %d: %w", i+1, err)
+		}
+	}
+
+	return ring, nil
+}
+
+// CurrentKeyID names the master key new data keys are wrapped with
+func (r *Keyring) CurrentKeyID() string {
+	return r.current
+}
+
+func (r *Keyring) add(encoded string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("not valid base64")
+	}
+	if len(key) != keySize {
+		return "", fmt.Errorf("got %d bytes, want %d", len(key), keySize)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	id := MasterKeyID(key)
+	r.keys[id] = aead
+	return id, nil
+}
+
+// MasterKeyID identifies a master key without giving anything away about it
+func MasterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// wrap seals a data key with the current master key. It is bound to the
+// object it belongs to, so a wrapped key can't be moved to another object.
+func (r *Keyring) wrap(storageKey string, dataKey []byte) (string, error) {
+	aead := r.keys[r.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(storageKey))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrap opens a data key wrapped by wrap with the named master key
+func (r *Keyring) unwrap(storageKey, wrapped, masterKeyID string) ([]byte, error) {
+	aead, ok := r.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("data key of %s is wrapped by master key %s, which is not configured", storageKey, masterKeyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("data key of %s is malformed", storageKey)
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(storageKey))
+	if err != nil {
+		return nil, fmt.Errorf("data key of %s does not open with master key %s", storageKey, masterKeyID)
+	}
+
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newDataKey returns a fresh random data key
+func newDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sealedSize is how large size bytes of plaintext become once sealed as one
+// stream. Even an empty stream has a segment, so that it can be authenticated.
+func sealedSize(size int64) int64 {
+	segments := (size + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return noncePrefixSize + size + segments*tagSize
+}
+
+// openedSize undoes sealedSize, returning -1 if no stream is that large
+func openedSize(size int64) int64 {
+	body := size - noncePrefixSize
+	if body < tagSize {
+		return -1
+	}
+	segments := (body + segmentSize + tagSize - 1) / (segmentSize + tagSize)
+	return body - segments*tagSize
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealingReader yields the sealed stream of what src yields
+type sealingReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	size    int64 // plaintext sealed so far
+
+	plain  []byte // the next segment's plaintext, read ahead by one byte
+	sealed []byte
+	out    []byte // sealed bytes not yet returned
+	done   bool
+}
+
+func newSealingReader(src io.Reader, dataKey []byte) (*sealingReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	return &sealingReader{
+		src:    src,
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, 0, segmentSize+1),
+		sealed: make([]byte, 0, segmentSize+tagSize),
+		out:    prefix,
+	}, nil
+}
+
+func (r *sealingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// seal reads and seals the next segment. A segment is only known to be the
+// last one once the byte after it turns out not to exist.
+func (r *sealingReader) seal() error {
+	n, err := io.ReadFull(r.src, r.plain[len(r.plain):segmentSize+1])
+	r.plain = r.plain[:len(r.plain)+n]
+	last := false
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	}
+
+	segment := r.plain
+	if !last {
+		segment = r.plain[:segmentSize]
+	}
+	if r.counter == ^uint32(0) {
+		return errors.New("object is too large to encrypt")
+	}
+	r.out = r.aead.Seal(r.sealed[:0], segmentNonce(r.prefix, r.counter, last), segment, nil)
+	r.counter++
+	r.size += int64(len(segment))
+
+	if last {
+		r.done = true
+		return nil
+	}
+	// Keep the byte read ahead for the next segment
+	r.plain = append(r.plain[:0], r.plain[segmentSize])
+	return nil
+}
+
+// openingReader yields the plaintext of one or more sealed streams laid end
+// to end, as a multipart upload of sealed parts leaves them. Since whole
+// streams could be dropped from the end unnoticed, the plaintext has to come
+// to the size the object was recorded with.
+type openingReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	size    int64 // plaintext still expected
+	started bool  // a stream has been opened; it may end at EOF once closed
+	open    bool  // inside a stream whose last segment is still to come
+
+	sealed []byte
+	plain  []byte
+	out    []byte // plaintext not yet returned
+	done   bool
+}
+
+func newOpeningReader(src io.Reader, dataKey []byte, size int64) (*openingReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openingReader{
+		src:    src,
+		aead:   aead,
+		prefix: make([]byte, noncePrefixSize),
+		size:   size,
+		sealed: make([]byte, segmentSize+tagSize),
+		plain:  make([]byte, 0, segmentSize),
+	}, nil
+}
+
+// resume starts the reader partway into a stream with the given nonce
+// prefix, at segment counter
+func (r *openingReader) resume(prefix []byte, counter uint32) {
+	copy(r.prefix, prefix)
+	r.counter = counter
+	r.started, r.open = true, true
+}
+
+func (r *openingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// next opens the next segment, reading a stream's nonce prefix first when
+// the previous stream has ended
+func (r *openingReader) next() error {
+	if !r.open {
+		_, err := io.ReadFull(r.src, r.prefix)
+		if errors.Is(err, io.EOF) && r.started && r.size == 0 {
+			r.done = true
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated", ErrCorrupted)
+		}
+		if err != nil {
+			return err
+		}
+		r.started, r.open, r.counter = true, true, 0
+	}
+
+	n, err := io.ReadFull(r.src, r.sealed)
+	full := err == nil
+	if !full && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if n < tagSize {
+		return fmt.Errorf("%w: truncated", ErrCorrupted)
+	}
+
+	// A full segment may or may not be the last of its stream, while a short
+	// one has to be
+	var plain []byte
+	last := true
+	if full {
+		plain, err = r.aead.Open(r.plain[:0], segmentNonce(r.prefix, r.counter, false), r.sealed[:n], nil)
+		last = err != nil
+	}
+	if last {
+		plain, err = r.aead.Open(r.plain[:0], segmentNonce(r.prefix, r.counter, true), r.sealed[:n], nil)
+		if err != nil {
+			return ErrCorrupted
+		}
+	}
+
+	if int64(len(plain)) > r.size {
+		return fmt.Errorf("%w: longer than recorded", ErrCorrupted)
+	}
+	r.size -= int64(len(plain))
+	r.out = plain
+	r.counter++
+	r.open = !last
+	return nil
+}
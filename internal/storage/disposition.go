@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+// fallbackFileName is offered when nothing of a name survives
+const fallbackFileName = "download"
+
+// AttachmentDisposition is the Content-Disposition that makes browsers save
+// the object under fileName
+func AttachmentDisposition(fileName string) string {
+	return ContentDisposition("attachment", fileName)
+}
+
+// InlineDisposition is the Content-Disposition that lets browsers show the
+// object, and save it under fileName if asked to
+func InlineDisposition(fileName string) string {
+	return ContentDisposition("inline", fileName)
+}
+
+// ContentDisposition builds a Content-Disposition header as RFC 6266 has it.
+// Every client gets a plain ASCII filename; names that don't survive that
+// intact are also given in full as UTF-8 in filename*, which clients prefer
+// when they understand it. Control characters and path separators are
+// dropped, so no name can break out of the header or into a directory.
+func ContentDisposition(disposition, fileName string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, fileName)
+	if strings.TrimSpace(name) == "" {
+		name = fallbackFileName
+	}
+
+	fallback := asciiFileName(name)
+	header := disposition + `; filename="` + fallback + `"`
+	if fallback != name {
+		header += "; filename*=UTF-8''" + encodeExtValue(name)
+	}
+	return header
+}
+
+// asciiFileName stands in for name where only ASCII can go. Quotes and
+// backslashes would need escaping that clients disagree on, and some clients
+// percent-decode the plain filename, so those are replaced too.
+func asciiFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// encodeExtValue percent-encodes the UTF-8 of s as RFC 8187 ext-values need,
+// leaving only attr-chars as they are
+func encodeExtValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,46 @@
+package storage_test
+
+import (
+	"mime"
+	"testing"
+
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{"naïve café.txt", `attachment; filename="na_ve caf_.txt"; filename*=UTF-8''na%C3%AFve%20caf%C3%A9.txt`},
+		{"日本語.txt", `attachment; filename="___.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%E8%AA%9E.txt`},
+		{`say "hi".txt`, `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+		{"100%.txt", `attachment; filename="100_.txt"; filename*=UTF-8''100%25.txt`},
+		{"evil\r\nSet-Cookie: x=1.txt", `attachment; filename="evilSet-Cookie: x=1.txt"`},
+		{"../../etc/passwd", `attachment; filename="....etcpasswd"`},
+		{"", `attachment; filename="download"`},
+		{"\x00\x01", `attachment; filename="download"`},
+	}
+
+	for _, tt := range tests {
+		got := storage.AttachmentDisposition(tt.name)
+		if got != tt.want {
+			t.Errorf("AttachmentDisposition(%q)\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+
+		// Whatever the name, the header has to parse, and say the same thing
+		// in filename* as it was given
+		disposition, params, err := mime.ParseMediaType(got)
+		if err != nil || disposition != "attachment" {
+			t.Errorf("AttachmentDisposition(%q) = %s does not parse: %v", tt.name, got, err)
+		}
+		if tt.name == "naïve café.txt" && params["filename"] != tt.name {
+			t.Errorf("filename* decodes to %q, want %q", params["filename"], tt.name)
+		}
+	}
+
+	if got := storage.InlineDisposition("a.txt"); got != `inline; filename="a.txt"` {
+		t.Errorf("InlineDisposition = %s", got)
+	}
+}
@@ -0,0 +1,603 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+)
+
+// KeyStore keeps the data keys of encrypted objects, apart from the objects
+// themselves so that master keys can be rotated without rewriting them
+type KeyStore interface {
+	// GetDataKey returns nil when the object has no data key
+	GetDataKey(ctx context.Context, storageKey string) (*models.DataKey, error)
+	// PutDataKey records an object's data key, replacing any it had
+	PutDataKey(ctx context.Context, key *models.DataKey) error
+	SetDataKeySize(ctx context.Context, storageKey string, size int64) error
+	// RewrapDataKey swaps wrapped key previous for wrapped, reporting false
+	// if the object has had its key replaced in the meantime
+	RewrapDataKey(ctx context.Context, storageKey, previous, wrapped, masterKeyID string) (bool, error)
+	// ListStaleDataKeys returns up to limit keys not wrapped by masterKeyID,
+	// in storage key order starting after the given one
+	ListStaleDataKeys(ctx context.Context, masterKeyID, after string, limit int) ([]models.DataKey, error)
+	DeleteDataKey(ctx context.Context, storageKey string) error
+}
+
+// ErrPresignUnsupported is returned for presigned URLs to encrypted
+// objects, which the bucket would hand out still encrypted, and for
+// presigned part uploads, which would be stored unencrypted
+var ErrPresignUnsupported = errors.New("presigned URLs are not available for encrypted objects")
+
+// ErrPartMisaligned is returned for a part of an encrypted multipart upload
+// that isn't a whole number of segments but is followed by another part.
+// Each part is sealed as a stream of its own, and streams can only be told
+// apart when every one but the last fills its final segment.
+var ErrPartMisaligned = fmt.Errorf("only the last part of an encrypted upload may be other than a multiple of %d bytes", segmentSize)
+
+// rotateBatchSize is how many data keys RotateKeys reads at once
+const rotateBatchSize = 500
+
+// EncryptedStorage encrypts objects before they reach another backend and
+// decrypts them as they are read back. Every object is sealed with AES-256-GCM
+// under a random data key of its own, which is kept in a KeyStore wrapped by
+// the current master key.
+//
+// Objects without a data key, written before encryption was turned on, are
+// passed through as they are, and so are versions the bucket kept of them.
+// New writes never become bucket versions: VersioningEnabled always reports
+// false, so the archive keeps versions under keys of their own instead.
+type EncryptedStorage struct {
+	inner   StorageInterface
+	keys    KeyStore
+	keyring *Keyring
+}
+
+func NewEncryptedStorage(inner StorageInterface, keys KeyStore, keyring *Keyring) *EncryptedStorage {
+	return &EncryptedStorage{
+		inner:   inner,
+		keys:    keys,
+		keyring: keyring,
+	}
+}
+
+// Unwrap returns the backend objects are stored in
+func (s *EncryptedStorage) Unwrap() StorageInterface {
+	return s.inner
+}
+
+func (s *EncryptedStorage) UploadFile(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) error {
+	return s.upload(ctx, fileName, reader, fileSize, contentType, "")
+}
+
+// UploadFileWithChecksum keeps checksum with the data key, since the bucket
+// only ever sees ciphertext. It never creates a bucket version.
+func (s *EncryptedStorage) UploadFileWithChecksum(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) (string, error) {
+	return "", s.upload(ctx, fileName, reader, fileSize, contentType, checksum)
+}
+
+// Checksum returns the checksum kept with the data key, and for objects
+// written before encryption was turned on, whatever the backend kept
+func (s *EncryptedStorage) Checksum(ctx context.Context, fileName, versionID string) (string, error) {
+	// New writes never become bucket versions, so those predate encryption
+	if versionID == "" {
+		key, err := s.keys.GetDataKey(ctx, fileName)
+		if err != nil {
+			return "", fmt.Errorf("failed to get checksum: %w", err)
+		}
+		if key != nil {
+			return key.SHA256, nil
+		}
+	}
+
+	if checksums, ok := s.inner.(ChecksumStorage); ok {
+		return checksums.Checksum(ctx, fileName, versionID)
+	}
+	if _, err := s.inner.GetFileSize(ctx, fileName); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (s *EncryptedStorage) upload(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) error {
+	dataKey, wrapped, err := s.newDataKey(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	sealing, err := newSealingReader(reader, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	sealed := int64(-1)
+	if fileSize >= 0 {
+		sealed = sealedSize(fileSize)
+	}
+	if err := s.inner.UploadFile(ctx, fileName, sealing, sealed, contentType); err != nil {
+		return err
+	}
+
+	// The key is recorded once the object is in place, so an upload that
+	// fails can't leave the object that was there without its key
+	now := time.Now().UTC()
+	err = s.keys.PutDataKey(ctx, &models.DataKey{
+		StorageKey:  fileName,
+		WrappedKey:  wrapped,
+		MasterKeyID: s.keyring.CurrentKeyID(),
+		Size:        sealing.size,
+		SHA256:      checksum,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	if err != nil {
+		if delErr := s.inner.DeleteFile(context.WithoutCancel(ctx), fileName); delErr != nil {
+			log.Printf("Failed to clean up %s after its data key was lost: %v", fileName, delErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *EncryptedStorage) GetFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	key, err := s.keys.GetDataKey(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	if key == nil {
+		return s.inner.GetFile(ctx, fileName)
+	}
+
+	dataKey, err := s.keyring.unwrap(fileName, key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	body, err := s.inner.GetFile(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	opening, err := newOpeningReader(body, dataKey, key.Size)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{opening, body}, nil
+}
+
+// GetFileRange decrypts only the segments the range falls in when the
+// object was sealed as a single stream, and otherwise decrypts from the start
+func (s *EncryptedStorage) GetFileRange(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	key, err := s.keys.GetDataKey(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	if key == nil {
+		return GetFileRange(ctx, s.inner, fileName, offset, length)
+	}
+	if length < 0 {
+		length = key.Size - offset
+	}
+	if offset < 0 || offset+length > key.Size {
+		return nil, fmt.Errorf("failed to get file range: %d bytes at %d is outside the object", length, offset)
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	// Objects put together from parts are several streams, whose segments
+	// can't be found without reading them in order
+	sealed, err := s.inner.GetFileSize(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if sealed != sealedSize(key.Size) {
+		body, err := s.GetFile(ctx, fileName)
+		if err != nil {
+			return nil, err
+		}
+		return skipTo(body, offset, length)
+	}
+
+	dataKey, err := s.keyring.unwrap(fileName, key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	prefix, err := s.readRange(ctx, fileName, 0, noncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	first := offset / segmentSize
+	last := (offset + length - 1) / segmentSize
+	start := noncePrefixSize + first*(segmentSize+tagSize)
+	end := min(noncePrefixSize+(last+1)*(segmentSize+tagSize), sealed)
+	body, err := GetFileRange(ctx, s.inner, fileName, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	opening, err := newOpeningReader(body, dataKey, key.Size-first*segmentSize)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	opening.resume(prefix, uint32(first))
+	return skipTo(struct {
+		io.Reader
+		io.Closer
+	}{opening, body}, offset-first*segmentSize, length)
+}
+
+// readRange reads a few bytes of a sealed object
+func (s *EncryptedStorage) readRange(ctx context.Context, fileName string, offset, length int64) ([]byte, error) {
+	body, err := GetFileRange(ctx, s.inner, fileName, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	return data, nil
+}
+
+// DeleteFile removes the data key along with the object. Anything the
+// bucket still keeps of the object, such as earlier versions, can no longer
+// be decrypted.
+func (s *EncryptedStorage) DeleteFile(ctx context.Context, fileName string) error {
+	if err := s.inner.DeleteFile(ctx, fileName); err != nil {
+		return err
+	}
+	return s.keys.DeleteDataKey(ctx, fileName)
+}
+
+func (s *EncryptedStorage) ListFiles(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	files, err := s.inner.ListFiles(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		key, err := s.keys.GetDataKey(ctx, files[i].Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		if key != nil {
+			files[i].Size = key.Size
+		}
+	}
+
+	return files, nil
+}
+
+func (s *EncryptedStorage) GetFileSize(ctx context.Context, fileName string) (int64, error) {
+	size, err := s.inner.GetFileSize(ctx, fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := s.keys.GetDataKey(ctx, fileName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file size: %w", err)
+	}
+	if key != nil {
+		size = key.Size
+	}
+
+	return size, nil
+}
+
+func (s *EncryptedStorage) GetPresignedURL(ctx context.Context, fileName string, opts PresignOptions) (string, error) {
+	key, err := s.keys.GetDataKey(ctx, fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+	if key != nil {
+		return "", ErrPresignUnsupported
+	}
+
+	return s.inner.GetPresignedURL(ctx, fileName, opts)
+}
+
+// CreateMultipartUpload gives the object its data key straight away, since
+// every part is sealed with it
+func (s *EncryptedStorage) CreateMultipartUpload(ctx context.Context, fileName, contentType string) (string, error) {
+	_, wrapped, err := s.newDataKey(fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	now := time.Now().UTC()
+	err = s.keys.PutDataKey(ctx, &models.DataKey{
+		StorageKey:  fileName,
+		WrappedKey:  wrapped,
+		MasterKeyID: s.keyring.CurrentKeyID(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	uploadID, err := s.inner.CreateMultipartUpload(ctx, fileName, contentType)
+	if err != nil {
+		if delErr := s.keys.DeleteDataKey(context.WithoutCancel(ctx), fileName); delErr != nil {
+			log.Printf("Failed to remove the data key of %s: %v", fileName, delErr)
+		}
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart seals each part as a stream of its own, so parts can arrive in
+// any order. A part that isn't a whole number of segments has to be the last
+// one, so it is refused when a later part is already there, as is any part
+// after one that is known to be misaligned.
+func (s *EncryptedStorage) UploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, reader io.Reader, size int64) (string, error) {
+	stored, err := s.ListParts(ctx, fileName, uploadID)
+	if err != nil {
+		return "", err
+	}
+	for _, part := range stored {
+		earlier := part.PartNumber < partNumber && !wholeSegments(part.Size)
+		later := part.PartNumber > partNumber && size >= 0 && !wholeSegments(size)
+		if earlier || later {
+			return "", fmt.Errorf("failed to upload part %d: %w", partNumber, ErrPartMisaligned)
+		}
+	}
+
+	dataKey, err := s.dataKey(ctx, fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	sealing, err := newSealingReader(reader, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+
+	sealed := int64(-1)
+	if size >= 0 {
+		sealed = sealedSize(size)
+	}
+	return s.inner.UploadPart(ctx, fileName, uploadID, partNumber, sealing, sealed)
+}
+
+func (s *EncryptedStorage) CompleteMultipartUpload(ctx context.Context, fileName, uploadID string, parts []CompletedPart) error {
+	// Part sizes can't be listed once the upload is complete
+	stored, err := s.ListParts(ctx, fileName, uploadID)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[int32]int64, len(stored))
+	for _, part := range stored {
+		sizes[part.PartNumber] = part.Size
+	}
+	var size int64
+	for i, part := range parts {
+		if i < len(parts)-1 && !wholeSegments(sizes[part.PartNumber]) {
+			return fmt.Errorf("failed to complete multipart upload: part %d: %w", part.PartNumber, ErrPartMisaligned)
+		}
+		size += sizes[part.PartNumber]
+	}
+
+	if err := s.inner.CompleteMultipartUpload(ctx, fileName, uploadID, parts); err != nil {
+		return err
+	}
+	return s.keys.SetDataKeySize(ctx, fileName, size)
+}
+
+func (s *EncryptedStorage) AbortMultipartUpload(ctx context.Context, fileName, uploadID string) error {
+	if err := s.inner.AbortMultipartUpload(ctx, fileName, uploadID); err != nil {
+		return err
+	}
+
+	// The key stays if an object was completed under it all the same
+	if _, err := s.inner.GetFileSize(ctx, fileName); !errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return s.keys.DeleteDataKey(ctx, fileName)
+}
+
+// ListParts reports the size of each part before it was sealed
+func (s *EncryptedStorage) ListParts(ctx context.Context, fileName, uploadID string) ([]StoredPart, error) {
+	parts, err := s.inner.ListParts(ctx, fileName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range parts {
+		parts[i].Size = openedSize(parts[i].Size)
+	}
+	return parts, nil
+}
+
+// PresignsUploads is false, since parts sent straight to the bucket would
+// be stored unencrypted
+func (s *EncryptedStorage) PresignsUploads() bool {
+	return false
+}
+
+func (s *EncryptedStorage) PresignUploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// VersioningEnabled is always false, so that new versions are written, and
+// encrypted, under keys of their own
+func (s *EncryptedStorage) VersioningEnabled(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// UploadFileVersion encrypts like UploadFile and so never creates a bucket
+// version
+func (s *EncryptedStorage) UploadFileVersion(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) (string, error) {
+	return "", s.UploadFile(ctx, fileName, reader, fileSize, contentType)
+}
+
+func (s *EncryptedStorage) CurrentVersion(ctx context.Context, fileName string) (string, error) {
+	versioned, err := s.versioned()
+	if err != nil {
+		return "", err
+	}
+	return versioned.CurrentVersion(ctx, fileName)
+}
+
+func (s *EncryptedStorage) GetFileVersion(ctx context.Context, fileName, versionID string) (io.ReadCloser, error) {
+	versioned, err := s.versioned()
+	if err != nil {
+		return nil, err
+	}
+	return versioned.GetFileVersion(ctx, fileName, versionID)
+}
+
+func (s *EncryptedStorage) GetPresignedVersionURL(ctx context.Context, fileName, versionID string, opts PresignOptions) (string, error) {
+	versioned, err := s.versioned()
+	if err != nil {
+		return "", err
+	}
+	return versioned.GetPresignedVersionURL(ctx, fileName, versionID, opts)
+}
+
+func (s *EncryptedStorage) RestoreVersion(ctx context.Context, fileName, versionID string) (string, error) {
+	versioned, err := s.versioned()
+	if err != nil {
+		return "", err
+	}
+	return versioned.RestoreVersion(ctx, fileName, versionID)
+}
+
+func (s *EncryptedStorage) DeleteVersion(ctx context.Context, fileName, versionID string) error {
+	versioned, err := s.versioned()
+	if err != nil {
+		return err
+	}
+	return versioned.DeleteVersion(ctx, fileName, versionID)
+}
+
+func (s *EncryptedStorage) DeleteAllVersions(ctx context.Context, fileName string) error {
+	versioned, ok := s.inner.(VersionedStorage)
+	if !ok {
+		return s.DeleteFile(ctx, fileName)
+	}
+	if err := versioned.DeleteAllVersions(ctx, fileName); err != nil {
+		return err
+	}
+	return s.keys.DeleteDataKey(ctx, fileName)
+}
+
+// versioned returns the backend for versions the bucket kept before
+// encryption was turned on
+func (s *EncryptedStorage) versioned() (VersionedStorage, error) {
+	versioned, ok := s.inner.(VersionedStorage)
+	if !ok {
+		return nil, errors.New("storage backend does not keep versions")
+	}
+	return versioned, nil
+}
+
+func (s *EncryptedStorage) ObjectLockEnabled(ctx context.Context) (bool, error) {
+	locking, ok := s.inner.(LockingStorage)
+	if !ok {
+		return false, nil
+	}
+	return locking.ObjectLockEnabled(ctx)
+}
+
+func (s *EncryptedStorage) SetRetention(ctx context.Context, fileName, versionID string, until time.Time) error {
+	locking, ok := s.inner.(LockingStorage)
+	if !ok {
+		return errors.New("storage backend does not support Object Lock")
+	}
+	return locking.SetRetention(ctx, fileName, versionID, until)
+}
+
+func (s *EncryptedStorage) SetLegalHold(ctx context.Context, fileName, versionID string, on bool) error {
+	locking, ok := s.inner.(LockingStorage)
+	if !ok {
+		return errors.New("storage backend does not support Object Lock")
+	}
+	return locking.SetLegalHold(ctx, fileName, versionID, on)
+}
+
+// RotateKeys re-wraps every data key that isn't wrapped by the current
+// master key, leaving the objects themselves untouched. It returns how many
+// keys it re-wrapped. Once it succeeds, the previous master keys are no
+// longer needed.
+func (s *EncryptedStorage) RotateKeys(ctx context.Context) (int, error) {
+	current := s.keyring.CurrentKeyID()
+	rotated, after := 0, ""
+	for {
+		keys, err := s.keys.ListStaleDataKeys(ctx, current, after, rotateBatchSize)
+		if err != nil {
+			return rotated, err
+		}
+
+		for _, key := range keys {
+			dataKey, err := s.keyring.unwrap(key.StorageKey, key.WrappedKey, key.MasterKeyID)
+			if err != nil {
+				return rotated, err
+			}
+			wrapped, err := s.keyring.wrap(key.StorageKey, dataKey)
+			if err != nil {
+				return rotated, err
+			}
+
+			// A key replaced since it was listed is already wrapped by the
+			// current master key
+			ok, err := s.keys.RewrapDataKey(ctx, key.StorageKey, key.WrappedKey, wrapped, current)
+			if err != nil {
+				return rotated, err
+			}
+			if ok {
+				rotated++
+			}
+		}
+
+		if len(keys) < rotateBatchSize {
+			return rotated, nil
+		}
+		after = keys[len(keys)-1].StorageKey
+	}
+}
+
+// newDataKey returns a fresh data key for fileName along with its wrapped form
+func (s *EncryptedStorage) newDataKey(fileName string) ([]byte, string, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := s.keyring.wrap(fileName, dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// wholeSegments reports whether size bytes of plaintext fill their last
+// segment, so that another stream can follow them
+func wholeSegments(size int64) bool {
+	return size%segmentSize == 0
+}
+
+// dataKey unwraps the data key fileName is being written with
+func (s *EncryptedStorage) dataKey(ctx context.Context, fileName string) ([]byte, error) {
+	key, err := s.keys.GetDataKey(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: data key for %s", ErrNotFound, fileName)
+	}
+	return s.keyring.unwrap(fileName, key.WrappedKey, key.MasterKeyID)
+}
@@ -0,0 +1,288 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/okoye-dev/oss-archive/internal/models"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/storage/storagetest"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	keyring := newKeyring(t, masterKey(t))
+	storagetest.Run(t, func(t *testing.T) storage.StorageInterface {
+		return storage.NewEncryptedStorage(storage.NewMemoryStorage(), newMemoryKeyStore(), keyring)
+	})
+}
+
+func TestEncryptedStorageAtRest(t *testing.T) {
+	ctx := t.Context()
+	inner := storage.NewMemoryStorage()
+	s := storage.NewEncryptedStorage(inner, newMemoryKeyStore(), newKeyring(t, masterKey(t)))
+
+	// Several segments, so reordering them can be tried too
+	plaintext := bytes.Repeat([]byte("confidential "), 20000)
+	if err := s.UploadFile(ctx, "secret.txt", bytes.NewReader(plaintext), int64(len(plaintext)), "text/plain"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	sealed := read(t, inner, "secret.txt")
+	if bytes.Contains(sealed, []byte("confidential")) {
+		t.Fatal("the bucket holds the plaintext")
+	}
+	if got := read(t, s, "secret.txt"); !bytes.Equal(got, plaintext) {
+		t.Fatal("decrypted content differs from what was uploaded")
+	}
+	if _, err := s.GetPresignedURL(ctx, "secret.txt", storage.PresignOptions{}); !errors.Is(err, storage.ErrPresignUnsupported) {
+		t.Errorf("GetPresignedURL of an encrypted object: got %v, want ErrPresignUnsupported", err)
+	}
+
+	tampered := map[string][]byte{
+		"flipped byte": func() []byte {
+			data := bytes.Clone(sealed)
+			data[len(data)/2] ^= 1
+			return data
+		}(),
+		"truncated":        sealed[:len(sealed)-100],
+		"last segment cut": sealed[:len(sealed)-(len(plaintext)%(64*1024)+16)],
+		"segments swapped": func() []byte {
+			const segment = 64*1024 + 16
+			data := bytes.Clone(sealed)
+			first := bytes.Clone(data[7 : 7+segment])
+			copy(data[7:], data[7+segment:7+2*segment])
+			copy(data[7+segment:], first)
+			return data
+		}(),
+	}
+	for name, data := range tampered {
+		if err := inner.UploadFile(ctx, "secret.txt", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		file, err := s.GetFile(ctx, "secret.txt")
+		if err != nil {
+			t.Fatalf("GetFile: %v", err)
+		}
+		_, err = io.ReadAll(file)
+		file.Close()
+		if !errors.Is(err, storage.ErrCorrupted) {
+			t.Errorf("reading a %s object: got %v, want ErrCorrupted", name, err)
+		}
+	}
+
+	// Objects stored before encryption was turned on are read as they are
+	if err := inner.UploadFile(ctx, "legacy.txt", strings.NewReader("plain"), 5, "text/plain"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got := read(t, s, "legacy.txt"); string(got) != "plain" {
+		t.Errorf("legacy object = %q, want %q", got, "plain")
+	}
+	if _, err := s.GetPresignedURL(ctx, "legacy.txt", storage.PresignOptions{}); err != nil {
+		t.Errorf("GetPresignedURL of a legacy object: %v", err)
+	}
+}
+
+func TestEncryptedStorageRotateKeys(t *testing.T) {
+	ctx := t.Context()
+	inner := storage.NewMemoryStorage()
+	keys := newMemoryKeyStore()
+	oldKey, newKey := masterKey(t), masterKey(t)
+
+	s := storage.NewEncryptedStorage(inner, keys, newKeyring(t, oldKey))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := s.UploadFile(ctx, name, strings.NewReader(name), 5, "text/plain"); err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+	}
+	before := read(t, inner, "a.txt")
+
+	// Keys wrapped by the old master key stay readable until rotated
+	s = storage.NewEncryptedStorage(inner, keys, newKeyring(t, newKey, oldKey))
+	if got := read(t, s, "a.txt"); string(got) != "a.txt" {
+		t.Fatalf("before rotation a.txt = %q", got)
+	}
+	rotated, err := s.RotateKeys(ctx)
+	if err != nil || rotated != 2 {
+		t.Fatalf("RotateKeys = %d, %v; want 2, nil", rotated, err)
+	}
+	if rotated, err := s.RotateKeys(ctx); err != nil || rotated != 0 {
+		t.Fatalf("RotateKeys again = %d, %v; want 0, nil", rotated, err)
+	}
+
+	s = storage.NewEncryptedStorage(inner, keys, newKeyring(t, newKey))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got := read(t, s, name); string(got) != name {
+			t.Errorf("after rotation %s = %q", name, got)
+		}
+	}
+	if !bytes.Equal(read(t, inner, "a.txt"), before) {
+		t.Error("rotation rewrote the object")
+	}
+
+	s = storage.NewEncryptedStorage(inner, keys, newKeyring(t, oldKey))
+	if _, err := s.GetFile(ctx, "a.txt"); err == nil {
+		t.Error("the old master key still opens a rotated data key")
+	}
+}
+
+func TestEncryptedStorageMisalignedPart(t *testing.T) {
+	ctx := t.Context()
+	s := storage.NewEncryptedStorage(storage.NewMemoryStorage(), newMemoryKeyStore(), newKeyring(t, masterKey(t)))
+
+	uploadID, err := s.CreateMultipartUpload(ctx, "parts.bin", "application/octet-stream")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	uploadPart := func(number int32, data []byte) (string, error) {
+		return s.UploadPart(ctx, "parts.bin", uploadID, number, bytes.NewReader(data), int64(len(data)))
+	}
+
+	last := bytes.Repeat([]byte("z"), 1000)
+	lastETag, err := uploadPart(2, last)
+	if err != nil {
+		t.Fatalf("UploadPart 2: %v", err)
+	}
+
+	// With part 2 there, part 1 can't end partway through a segment
+	if _, err := uploadPart(1, bytes.Repeat([]byte("a"), storage.MinPartSize+1)); !errors.Is(err, storage.ErrPartMisaligned) {
+		t.Fatalf("misaligned part before the last: got %v, want ErrPartMisaligned", err)
+	}
+	first := bytes.Repeat([]byte("a"), storage.MinPartSize)
+	firstETag, err := uploadPart(1, first)
+	if err != nil {
+		t.Fatalf("UploadPart 1: %v", err)
+	}
+
+	// Nor can a part follow one that is already known to end the object
+	if _, err := uploadPart(3, last); !errors.Is(err, storage.ErrPartMisaligned) {
+		t.Fatalf("part after a misaligned one: got %v, want ErrPartMisaligned", err)
+	}
+
+	err = s.CompleteMultipartUpload(ctx, "parts.bin", uploadID, []storage.CompletedPart{
+		{PartNumber: 1, ETag: firstETag},
+		{PartNumber: 2, ETag: lastETag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if got := read(t, s, "parts.bin"); !bytes.Equal(got, append(first, last...)) {
+		t.Error("assembled object differs from the uploaded parts")
+	}
+}
+
+func masterKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating master key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newKeyring(t *testing.T, current string, previous ...string) *storage.Keyring {
+	t.Helper()
+	keyring, err := storage.NewKeyring(current, previous)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keyring
+}
+
+func read(t *testing.T, s storage.StorageInterface, key string) []byte {
+	t.Helper()
+	file, err := s.GetFile(t.Context(), key)
+	if err != nil {
+		t.Fatalf("GetFile(%q): %v", key, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading %q: %v", key, err)
+	}
+	return data
+}
+
+// memoryKeyStore keeps data keys in a map, standing in for the catalog
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]models.DataKey
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{keys: make(map[string]models.DataKey)}
+}
+
+func (m *memoryKeyStore) GetDataKey(ctx context.Context, storageKey string) (*models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[storageKey]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (m *memoryKeyStore) PutDataKey(ctx context.Context, key *models.DataKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.StorageKey] = *key
+	return nil
+}
+
+func (m *memoryKeyStore) SetDataKeySize(ctx context.Context, storageKey string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[storageKey]
+	if !ok {
+		return errors.New("no data key")
+	}
+	key.Size = size
+	m.keys[storageKey] = key
+	return nil
+}
+
+func (m *memoryKeyStore) RewrapDataKey(ctx context.Context, storageKey, previous, wrapped, masterKeyID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[storageKey]
+	if !ok || key.WrappedKey != previous {
+		return false, nil
+	}
+	key.WrappedKey, key.MasterKeyID = wrapped, masterKeyID
+	m.keys[storageKey] = key
+	return true, nil
+}
+
+func (m *memoryKeyStore) ListStaleDataKeys(ctx context.Context, masterKeyID, after string, limit int) ([]models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []models.DataKey
+	for _, key := range m.keys {
+		if key.MasterKeyID != masterKeyID && key.StorageKey > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].StorageKey < keys[j].StorageKey
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (m *memoryKeyStore) DeleteDataKey(ctx context.Context, storageKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, storageKey)
+	return nil
+}
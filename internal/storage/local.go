@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	appConfig "github.com/okoye-dev/oss-archive/internal/config"
+)
+
+// LocalRoutePrefix is where the server serves signed local storage URLs
+const LocalRoutePrefix = "/storage"
+
+// Directories under the storage root. Temp files share the filesystem with
+// objects so the final rename is atomic.
+const (
+	objectsDir   = "objects"
+	multipartDir = "multipart"
+	tmpDir       = "tmp"
+)
+
+var (
+	ErrInvalidKey       = errors.New("invalid storage key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrURLExpired       = errors.New("signed URL has expired")
+)
+
+// LocalStorage keeps objects in a directory tree. Its presigned URLs point
+// back at the server, which checks the HMAC signature before serving them.
+type LocalStorage struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+func NewLocalStorage(cfg *appConfig.StorageConfig, baseURL string) (StorageInterface, error) {
+	if cfg.SigningKey == "" {
+		return nil, errors.New("local storage needs a signing key")
+	}
+
+	root, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage path: %w", err)
+	}
+
+	for _, dir := range []string{objectsDir, multipartDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+
+	return &LocalStorage{
+		root:       root,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		signingKey: []byte(cfg.SigningKey),
+	}, nil
+}
+
+func (s *LocalStorage) UploadFile(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) error {
+	target, err := s.objectPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.writeAtomic(ctx, target, reader, fileSize); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	log.Printf("Successfully uploaded file: %s", fileName)
+	return nil
+}
+
+func (s *LocalStorage) GetFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	file, _, err := s.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (s *LocalStorage) GetFileRange(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	file, info, err := s.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	if length < 0 {
+		length = info.Size() - offset
+	}
+	if offset < 0 || offset+length > info.Size() {
+		file.Close()
+		return nil, fmt.Errorf("failed to get file range: %d bytes at %d is outside the object", length, offset)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+
+	return limitedReadCloser(file, length), nil
+}
+
+// Open returns an object along with its size and modification time
+func (s *LocalStorage) Open(fileName string) (*os.File, fs.FileInfo, error) {
+	target, err := s.objectPath(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		return nil, nil, notExist(err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, info, nil
+}
+
+func (s *LocalStorage) DeleteFile(ctx context.Context, fileName string) error {
+	target, err := s.objectPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	// Like S3, deleting a missing object is not an error
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	s.pruneEmptyDirs(filepath.Dir(target))
+
+	log.Printf("Successfully deleted file: %s", fileName)
+	return nil
+}
+
+func (s *LocalStorage) ListFiles(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := filepath.Join(s.root, objectsDir)
+
+	// Only walk the directory the prefix falls in
+	start := objects
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && path.Clean("/"+dir) == "/"+dir {
+		start = filepath.Join(objects, filepath.FromSlash(dir))
+	}
+	if info, err := os.Stat(start); errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) || (err == nil && !info.IsDir()) {
+		return nil, nil
+	}
+
+	var files []ObjectInfo
+	err := filepath.WalkDir(start, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(objects, current)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// WalkDir goes in lexical order per directory, which isn't key order
+	// when a name sorts after the "/" that follows a shorter one
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	return files, nil
+}
+
+func (s *LocalStorage) GetFileSize(ctx context.Context, fileName string) (int64, error) {
+	target, err := s.objectPath(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file size: %w", notExist(err))
+	}
+
+	return info.Size(), nil
+}
+
+func (s *LocalStorage) GetPresignedURL(ctx context.Context, fileName string, opts PresignOptions) (string, error) {
+	if _, err := s.objectPath(fileName); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if opts.Download {
+		query.Set("download", "1")
+	}
+	if opts.FileName != "" {
+		query.Set("filename", opts.FileName)
+	}
+	if opts.ContentType != "" {
+		query.Set("content_type", opts.ContentType)
+	}
+
+	return s.signedURL(http.MethodGet, fileName, query, opts.Lifetime()), nil
+}
+
+func (s *LocalStorage) CreateMultipartUpload(ctx context.Context, fileName, contentType string) (string, error) {
+	if _, err := s.objectPath(fileName); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir := filepath.Join(s.root, multipartDir, uploadID)
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	// Remember the key so parts can't be sent to someone else's upload
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(fileName), 0o640); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+func (s *LocalStorage) UploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, reader io.Reader, size int64) (string, error) {
+	dir, err := s.multipartPath(fileName, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if partNumber < 1 || partNumber > MaxParts {
+		return "", fmt.Errorf("failed to upload part %d: part number out of range", partNumber)
+	}
+
+	sum, err := s.writeAtomic(ctx, partPath(dir, partNumber), reader, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+
+	return quoteETag(sum), nil
+}
+
+func (s *LocalStorage) CompleteMultipartUpload(ctx context.Context, fileName, uploadID string, parts []CompletedPart) error {
+	dir, err := s.multipartPath(fileName, uploadID)
+	if err != nil {
+		return err
+	}
+	target, err := s.objectPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	// Check every part before touching the object, as S3 does
+	if len(parts) == 0 {
+		return errors.New("failed to complete multipart upload: no parts given")
+	}
+	paths := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errors.New("failed to complete multipart upload: parts must be in ascending order")
+		}
+
+		partFile := partPath(dir, part.PartNumber)
+		sum, size, err := fileMD5(partFile)
+		if err != nil {
+			return fmt.Errorf("failed to complete multipart upload: part %d: %w", part.PartNumber, err)
+		}
+		if quoteETag(sum) != quoteETag(strings.Trim(part.ETag, `"`)) {
+			return fmt.Errorf("failed to complete multipart upload: part %d ETag does not match", part.PartNumber)
+		}
+		if i < len(parts)-1 && size < MinPartSize {
+			return fmt.Errorf("failed to complete multipart upload: part %d is smaller than the minimum part size", part.PartNumber)
+		}
+		paths = append(paths, partFile)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, partFile := range paths {
+			file, err := os.Open(partFile)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, file)
+			file.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	_, err = s.writeAtomic(ctx, target, pr, -1)
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to clean up multipart upload %s: %v", uploadID, err)
+	}
+
+	log.Printf("Successfully uploaded file: %s", fileName)
+	return nil
+}
+
+func (s *LocalStorage) AbortMultipartUpload(ctx context.Context, fileName, uploadID string) error {
+	dir, err := s.multipartPath(fileName, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) ListParts(ctx context.Context, fileName, uploadID string) ([]StoredPart, error) {
+	dir, err := s.multipartPath(fileName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+
+	var parts []StoredPart
+	for _, entry := range entries {
+		number, ok := strings.CutPrefix(entry.Name(), "part-")
+		if !ok {
+			continue
+		}
+		partNumber, err := strconv.ParseInt(number, 10, 32)
+		if err != nil {
+			continue
+		}
+
+		sum, size, err := fileMD5(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts: %w", err)
+		}
+		parts = append(parts, StoredPart{
+			PartNumber: int32(partNumber),
+			ETag:       quoteETag(sum),
+			Size:       size,
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (s *LocalStorage) PresignUploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if _, err := s.multipartPath(fileName, uploadID); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	query.Set("partNumber", strconv.Itoa(int(partNumber)))
+
+	return s.signedURL(http.MethodPut, fileName, query, expires), nil
+}
+
+// Verify checks that query carries an unexpired signature issued for this
+// method and key
+func (s *LocalStorage) Verify(method, fileName string, query url.Values) error {
+	signature := query.Get("signature")
+
+	unsigned := url.Values{}
+	for name, values := range query {
+		if name != "signature" {
+			unsigned[name] = values
+		}
+	}
+
+	expected := s.sign(method, fileName, unsigned)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrURLExpired
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) signedURL(method, fileName string, query url.Values, expires time.Duration) string {
+	query.Set("expires", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	query.Set("signature", s.sign(method, fileName, query))
+
+	segments := strings.Split(fileName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return s.baseURL + LocalRoutePrefix + "/" + strings.Join(segments, "/") + "?" + query.Encode()
+}
+
+// sign covers the method, key and every query parameter; Encode sorts the
+// parameters so the order they arrive in doesn't matter
+func (s *LocalStorage) sign(method, fileName string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(method + "\n" + fileName + "\n" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// objectPath maps a key into the objects directory, refusing anything that
+// could resolve outside it
+func (s *LocalStorage) objectPath(fileName string) (string, error) {
+	if fileName == "" || strings.ContainsRune(fileName, 0) || path.Clean("/"+fileName) != "/"+fileName {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, fileName)
+	}
+
+	return filepath.Join(s.root, objectsDir, filepath.FromSlash(fileName)), nil
+}
+
+// multipartPath returns the directory of an open multipart upload for key
+func (s *LocalStorage) multipartPath(fileName, uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+
+	dir := filepath.Join(s.root, multipartDir, uploadID)
+	key, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil || string(key) != fileName {
+		return "", fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+
+	return dir, nil
+}
+
+// writeAtomic copies reader into a temp file and renames it over target, so
+// readers only ever see a complete object. A size of -1 skips the length
+// check. It returns the hex MD5 of what was written, and gives up part way if
+// ctx is cancelled.
+func (s *LocalStorage) writeAtomic(ctx context.Context, target string, reader io.Reader, size int64) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "write-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), contextReader{ctx, reader})
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("wrote %d bytes, expected %d", written, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// pruneEmptyDirs removes directories left empty by a delete, stopping at
+// the objects root
+func (s *LocalStorage) pruneEmptyDirs(dir string) {
+	objects := filepath.Join(s.root, objectsDir)
+	for dir != objects && strings.HasPrefix(dir, objects) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// notExist marks a missing file as ErrNotFound
+func notExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+func partPath(dir string, partNumber int32) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+func fileMD5(name string) (string, int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// quoteETag formats an MD5 the way S3 returns ETags
+func quoteETag(sum string) string {
+	return `"` + sum + `"`
+}
@@ -0,0 +1,23 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/okoye-dev/oss-archive/internal/config"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/storage/storagetest"
+)
+
+func TestLocalStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.StorageInterface {
+		s, err := storage.NewLocalStorage(&config.StorageConfig{
+			Backend:    storage.BackendLocal,
+			Path:       t.TempDir(),
+			SigningKey: "storagetest",
+		}, "http://localhost:6060")
+		if err != nil {
+			t.Fatalf("NewLocalStorage: %v", err)
+		}
+		return s
+	})
+}
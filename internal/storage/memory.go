@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in a map. It is meant for tests: its presigned
+// URLs use a memory:// scheme and can't be fetched.
+type MemoryStorage struct {
+	mu         sync.RWMutex
+	objects    map[string]memoryObject
+	multiparts map[string]*memoryMultipart
+}
+
+type memoryObject struct {
+	data     []byte
+	checksum string
+	modified time.Time
+}
+
+type memoryMultipart struct {
+	key   string
+	parts map[int32][]byte
+}
+
+func NewMemoryStorage() StorageInterface {
+	return &MemoryStorage{
+		objects:    make(map[string]memoryObject),
+		multiparts: make(map[string]*memoryMultipart),
+	}
+}
+
+func (s *MemoryStorage) UploadFile(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) error {
+	_, err := s.UploadFileWithChecksum(ctx, fileName, reader, fileSize, contentType, "")
+	return err
+}
+
+func (s *MemoryStorage) UploadFileWithChecksum(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) (string, error) {
+	data, err := io.ReadAll(contextReader{ctx, reader})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	if fileSize >= 0 && int64(len(data)) != fileSize {
+		return "", fmt.Errorf("failed to upload file: read %d bytes, expected %d", len(data), fileSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[fileName] = memoryObject{data: data, checksum: checksum, modified: time.Now().UTC()}
+
+	return "", nil
+}
+
+// Checksum ignores versionID, since only the current version is kept
+func (s *MemoryStorage) Checksum(ctx context.Context, fileName, versionID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[fileName]
+	if !ok {
+		return "", fmt.Errorf("failed to get checksum: %w: %s", ErrNotFound, fileName)
+	}
+
+	return object.checksum, nil
+}
+
+func (s *MemoryStorage) GetFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[fileName]
+	if !ok {
+		return nil, fmt.Errorf("failed to get file: %w: %s", ErrNotFound, fileName)
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (s *MemoryStorage) GetFileRange(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[fileName]
+	if !ok {
+		return nil, fmt.Errorf("failed to get file range: %w: %s", ErrNotFound, fileName)
+	}
+	size := int64(len(object.data))
+	if length < 0 {
+		length = size - offset
+	}
+	if offset < 0 || offset+length > size {
+		return nil, fmt.Errorf("failed to get file range: %d bytes at %d is outside the object", length, offset)
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data[offset : offset+length])), nil
+}
+
+func (s *MemoryStorage) DeleteFile(ctx context.Context, fileName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, fileName)
+
+	return nil
+}
+
+func (s *MemoryStorage) ListFiles(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var files []ObjectInfo
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, ObjectInfo{
+				Key:          key,
+				Size:         int64(len(object.data)),
+				LastModified: object.modified,
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	return files, nil
+}
+
+func (s *MemoryStorage) GetFileSize(ctx context.Context, fileName string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[fileName]
+	if !ok {
+		return 0, fmt.Errorf("failed to get file size: %w: %s", ErrNotFound, fileName)
+	}
+
+	return int64(len(object.data)), nil
+}
+
+func (s *MemoryStorage) GetPresignedURL(ctx context.Context, fileName string, opts PresignOptions) (string, error) {
+	query := url.Values{}
+	if disposition := opts.Disposition(fileName); disposition != "" {
+		query.Set("response-content-disposition", disposition)
+	}
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+
+	return memoryURL(fileName, query, opts.Lifetime()), nil
+}
+
+func (s *MemoryStorage) CreateMultipartUpload(ctx context.Context, fileName, contentType string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	uploadID := hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.multiparts[uploadID] = &memoryMultipart{
+		key:   fileName,
+		parts: make(map[int32][]byte),
+	}
+
+	return uploadID, nil
+}
+
+func (s *MemoryStorage) UploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, reader io.Reader, size int64) (string, error) {
+	if partNumber < 1 || partNumber > MaxParts {
+		return "", fmt.Errorf("failed to upload part %d: part number out of range", partNumber)
+	}
+
+	data, err := io.ReadAll(contextReader{ctx, reader})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return "", fmt.Errorf("failed to upload part %d: read %d bytes, expected %d", partNumber, len(data), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.multipart(fileName, uploadID)
+	if err != nil {
+		return "", err
+	}
+	upload.parts[partNumber] = data
+
+	return memoryETag(data), nil
+}
+
+func (s *MemoryStorage) CompleteMultipartUpload(ctx context.Context, fileName, uploadID string, parts []CompletedPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.multipart(fileName, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.New("failed to complete multipart upload: no parts given")
+	}
+
+	var object bytes.Buffer
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errors.New("failed to complete multipart upload: parts must be in ascending order")
+		}
+
+		data, ok := upload.parts[part.PartNumber]
+		if !ok || memoryETag(data) != quoteETag(strings.Trim(part.ETag, `"`)) {
+			return fmt.Errorf("failed to complete multipart upload: part %d does not match", part.PartNumber)
+		}
+		if i < len(parts)-1 && len(data) < MinPartSize {
+			return fmt.Errorf("failed to complete multipart upload: part %d is smaller than the minimum part size", part.PartNumber)
+		}
+		object.Write(data)
+	}
+
+	s.objects[fileName] = memoryObject{data: object.Bytes(), modified: time.Now().UTC()}
+	delete(s.multiparts, uploadID)
+
+	return nil
+}
+
+func (s *MemoryStorage) AbortMultipartUpload(ctx context.Context, fileName, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.multipart(fileName, uploadID); err != nil {
+		return err
+	}
+	delete(s.multiparts, uploadID)
+
+	return nil
+}
+
+func (s *MemoryStorage) ListParts(ctx context.Context, fileName, uploadID string) ([]StoredPart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	upload, err := s.multipart(fileName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]StoredPart, 0, len(upload.parts))
+	for number, data := range upload.parts {
+		parts = append(parts, StoredPart{
+			PartNumber: number,
+			ETag:       memoryETag(data),
+			Size:       int64(len(data)),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (s *MemoryStorage) PresignUploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.multipart(fileName, uploadID); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	query.Set("partNumber", strconv.Itoa(int(partNumber)))
+
+	return memoryURL(fileName, query, expires), nil
+}
+
+// multipart looks up an open upload for key; callers hold the lock
+func (s *MemoryStorage) multipart(fileName, uploadID string) (*memoryMultipart, error) {
+	upload, ok := s.multiparts[uploadID]
+	if !ok || upload.key != fileName {
+		return nil, fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+	return upload, nil
+}
+
+func memoryURL(fileName string, query url.Values, expires time.Duration) string {
+	query.Set("expires", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	u := url.URL{
+		Scheme:   "memory",
+		Host:     "storage",
+		Path:     "/" + fileName,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func memoryETag(data []byte) string {
+	sum := md5.Sum(data)
+	return quoteETag(hex.EncodeToString(sum[:]))
+}
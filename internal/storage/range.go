@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// GetFileRange reads length bytes of fileName starting at offset, or
+// everything from offset on when length is negative. Backends that can't
+// read ranges have what comes before offset read and thrown away.
+func GetFileRange(ctx context.Context, s StorageInterface, fileName string, offset, length int64) (io.ReadCloser, error) {
+	if ranged, ok := s.(RangeStorage); ok {
+		return ranged.GetFileRange(ctx, fileName, offset, length)
+	}
+
+	body, err := s.GetFile(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(body, offset, length)
+}
+
+// skipTo discards the first offset bytes of body and ends it length bytes
+// later, or leaves the rest when length is negative
+func skipTo(body io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+		body.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to get file range: offset %d is past the end of the object", offset)
+		}
+		return nil, fmt.Errorf("failed to get file range: %w", err)
+	}
+	if length < 0 {
+		return body, nil
+	}
+	return limitedReadCloser(body, length), nil
+}
+
+// limitedReadCloser stops reading body after n bytes and closes it when done
+func limitedReadCloser(body io.ReadCloser, n int64) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, n), body}
+}
+
+// ObjectReader reads an object of known size from wherever it is sought to,
+// fetching only the ranges that are read. It is what http.ServeContent needs
+// to answer range requests for an object without downloading all of it.
+type ObjectReader struct {
+	ctx       context.Context
+	storage   StorageInterface
+	fileName  string
+	versionID string // a version the bucket keeps, or empty for the current object
+	size      int64
+
+	offset int64
+	body   io.ReadCloser // open at offset, if anything has been read
+}
+
+// OpenObject returns a reader for fileName, which must be size bytes long.
+// Nothing is fetched until the first read.
+func OpenObject(ctx context.Context, s StorageInterface, fileName string, size int64) *ObjectReader {
+	return &ObjectReader{
+		ctx:      ctx,
+		storage:  s,
+		fileName: fileName,
+		size:     size,
+	}
+}
+
+// OpenObjectVersion is OpenObject for a version the bucket keeps of
+// fileName. Bucket versions can't be read in ranges, so what comes before
+// the offset read from is thrown away.
+func OpenObjectVersion(ctx context.Context, s StorageInterface, fileName, versionID string, size int64) *ObjectReader {
+	object := OpenObject(ctx, s, fileName, size)
+	object.versionID = versionID
+	return object
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// open starts reading the object at the current offset
+func (r *ObjectReader) open() (io.ReadCloser, error) {
+	if r.versionID == "" {
+		return GetFileRange(r.ctx, r.storage, r.fileName, r.offset, -1)
+	}
+
+	versioned, ok := r.storage.(VersionedStorage)
+	if !ok {
+		return nil, fmt.Errorf("failed to get version %s of %s: storage backend does not keep versions", r.versionID, r.fileName)
+	}
+	body, err := versioned.GetFileVersion(r.ctx, r.fileName, r.versionID)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(body, r.offset, -1)
+}
+
+// Seek moves to a new offset, dropping the open range if it has to
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -2,10 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -14,20 +16,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	appConfig "github.com/okoye-dev/oss-archive/internal/config"
 )
 
-type StorageInterface interface {
-	UploadFile(fileName string, reader io.Reader, fileSize int64, contentType string) error
-	DeleteFile(fileName string) error
-	ListFiles() ([]string, error)
-	GetFileSize(fileName string) (int64, error)
-	GetPresignedURL(fileName string, forceDownload bool) (string, error)
-}
-
 type S3Storage struct {
 	client     *s3.Client
 	bucketName string
+	lockMode   types.ObjectLockRetentionMode
 }
 
 func NewS3Storage(cfg *appConfig.S3Config) (StorageInterface, error) {
@@ -79,56 +75,87 @@ func NewS3Storage(cfg *appConfig.S3Config) (StorageInterface, error) {
 		o.UsePathStyle = cfg.ForcePathStyle
 	})
 
+	lockMode := types.ObjectLockRetentionModeGovernance
+	if cfg.ObjectLockMode != "" {
+		lockMode = types.ObjectLockRetentionMode(strings.ToUpper(cfg.ObjectLockMode))
+		if lockMode != types.ObjectLockRetentionModeGovernance && lockMode != types.ObjectLockRetentionModeCompliance {
+			return nil, fmt.Errorf("unknown object lock mode %q", cfg.ObjectLockMode)
+		}
+	}
+
 	storage := &S3Storage{
 		client:     s3Client,
 		bucketName: cfg.BucketName,
+		lockMode:   lockMode,
 	}
     // Bucket must already exist (especially for Cloudflare R2)
 
 	return storage, nil
 }
 
-func (s *S3Storage) UploadFile(fileName string, reader io.Reader, fileSize int64, contentType string) error {
-	ctx := context.Background()
-	
+func (s *S3Storage) UploadFile(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) error {
+	_, err := s.upload(ctx, fileName, reader, contentType, nil)
+	return err
+}
+
+func (s *S3Storage) upload(ctx context.Context, fileName string, reader io.Reader, contentType string, metadata map[string]string) (*manager.UploadOutput, error) {
 	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
 		u.PartSize = 16 * 1024 * 1024 
 		u.Concurrency = 8            
 	})
 
-	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+	result, err := uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(s.bucketName),
 		Key:         aws.String(fileName),
 		Body:        reader,
 		ContentType: aws.String(contentType),
+		Metadata:    metadata,
 	})
 	
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
 
 	log.Printf("Successfully uploaded file: %s", fileName)
-	return nil
+	return result, nil
 }
 
-func (s *S3Storage) GetFile(fileName string) (io.ReadCloser, error) {
-	ctx := context.Background()
-	
+func (s *S3Storage) GetFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
 	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(fileName),
 	})
 	
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file: %w", err)
+		return nil, fmt.Errorf("failed to get file: %w", notFound(err))
 	}
 
 	return result.Body, nil
 }
 
-func (s *S3Storage) DeleteFile(fileName string) error {
-	ctx := context.Background()
-	
+func (s *S3Storage) GetFileRange(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += fmt.Sprint(offset + length - 1)
+	}
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(fileName),
+		Range:  aws.String(byteRange),
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file range: %w", notFound(err))
+	}
+
+	return result.Body, nil
+}
+
+func (s *S3Storage) DeleteFile(ctx context.Context, fileName string) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(fileName),
@@ -142,43 +169,50 @@ func (s *S3Storage) DeleteFile(fileName string) error {
 	return nil
 }
 
-func (s *S3Storage) ListFiles() ([]string, error) {
-	ctx := context.Background()
-	
-	result, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+func (s *S3Storage) ListFiles(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucketName),
-	})
-	
-	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
 	}
 
-	var files []string
-	for _, obj := range result.Contents {
-		files = append(files, aws.ToString(obj.Key))
+	// Each page holds at most 1000 keys; the paginator follows the
+	// continuation tokens through the rest
+	var files []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			files = append(files, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
 	}
 
 	return files, nil
 }
 
-func (s *S3Storage) GetFileSize(fileName string) (int64, error) {
-	ctx := context.Background()
-	
+func (s *S3Storage) GetFileSize(ctx context.Context, fileName string) (int64, error) {
 	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(fileName),
 	})
 	
 	if err != nil {
-		return 0, fmt.Errorf("failed to get file size: %w", err)
+		return 0, fmt.Errorf("failed to get file size: %w", notFound(err))
 	}
 
 	return aws.ToInt64(result.ContentLength), nil
 }
 
-func (s *S3Storage) GetPresignedURL(fileName string, forceDownload bool) (string, error) {
-	ctx := context.Background()
-	
+func (s *S3Storage) GetPresignedURL(ctx context.Context, fileName string, opts PresignOptions) (string, error) {
 	// Create presigned client
 	presignClient := s3.NewPresignClient(s.client)
 	
@@ -188,23 +222,11 @@ func (s *S3Storage) GetPresignedURL(fileName string, forceDownload bool) (string
 		Key:    aws.String(fileName),
 	}
 	
-	// Only add Content-Disposition header if forcing download
-	if forceDownload {
-		// Extract original filename for Content-Disposition header
-		originalFilename := fileName
-		if strings.Contains(fileName, "_") {
-			// Remove UUID prefix to get original filename
-			parts := strings.SplitN(fileName, "_", 2)
-			if len(parts) == 2 {
-				originalFilename = parts[1]
-			}
-		}
-		input.ResponseContentDisposition = aws.String(fmt.Sprintf("attachment; filename=\"%s\"", originalFilename))
-	}
+	presignResponse(input, fileName, opts)
 	
 	// Create presigned URL
-	request, err := presignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
-		opts.Expires = time.Duration(1 * time.Hour)
+	request, err := presignClient.PresignGetObject(ctx, input, func(presign *s3.PresignOptions) {
+		presign.Expires = opts.Lifetime()
 	})
 	
 	if err != nil {
@@ -213,3 +235,416 @@ func (s *S3Storage) GetPresignedURL(fileName string, forceDownload bool) (string
 	
 	return request.URL, nil
 }
+
+func (s *S3Storage) CreateMultipartUpload(ctx context.Context, fileName, contentType string) (string, error) {
+	result, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(fileName),
+		ContentType: aws.String(contentType),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return aws.ToString(result.UploadId), nil
+}
+
+func (s *S3Storage) UploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, reader io.Reader, size int64) (string, error) {
+	result, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucketName),
+		Key:           aws.String(fileName),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          reader,
+		ContentLength: aws.Int64(size),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, notFound(err))
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+func (s *S3Storage) CompleteMultipartUpload(ctx context.Context, fileName, uploadID string, parts []CompletedPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucketName),
+		Key:             aws.String(fileName),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", notFound(err))
+	}
+
+	log.Printf("Successfully uploaded file: %s", fileName)
+	return nil
+}
+
+func (s *S3Storage) AbortMultipartUpload(ctx context.Context, fileName, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucketName),
+		Key:      aws.String(fileName),
+		UploadId: aws.String(uploadID),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", notFound(err))
+	}
+
+	return nil
+}
+
+func (s *S3Storage) ListParts(ctx context.Context, fileName, uploadID string) ([]StoredPart, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucketName),
+		Key:      aws.String(fileName),
+		UploadId: aws.String(uploadID),
+	})
+
+	var parts []StoredPart
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts: %w", notFound(err))
+		}
+
+		for _, part := range page.Parts {
+			parts = append(parts, StoredPart{
+				PartNumber: aws.ToInt32(part.PartNumber),
+				ETag:       aws.ToString(part.ETag),
+				Size:       aws.ToInt64(part.Size),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+func (s *S3Storage) PresignUploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client)
+	request, err := presignClient.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucketName),
+		Key:        aws.String(fileName),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expires
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to presign part %d: %w", partNumber, err)
+	}
+
+	return request.URL, nil
+}
+
+// maxCopySize is the largest object CopyObject copies in one request; bigger
+// ones are copied in parts
+const maxCopySize = 5 << 30
+
+// copyPartSize is the size of each part when copying a larger object
+const copyPartSize = 512 << 20
+
+func (s *S3Storage) VersioningEnabled(ctx context.Context) (bool, error) {
+	result, err := s.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.bucketName),
+	})
+
+	if err != nil {
+		return false, fmt.Errorf("failed to get bucket versioning: %w", err)
+	}
+
+	return result.Status == types.BucketVersioningStatusEnabled, nil
+}
+
+func (s *S3Storage) UploadFileVersion(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) (string, error) {
+	result, err := s.upload(ctx, fileName, reader, contentType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(result.VersionID), nil
+}
+
+func (s *S3Storage) UploadFileWithChecksum(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) (string, error) {
+	result, err := s.upload(ctx, fileName, reader, contentType, map[string]string{ChecksumMetadata: checksum})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(result.VersionID), nil
+}
+
+func (s *S3Storage) Checksum(ctx context.Context, fileName, versionID string) (string, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: optionalString(versionID),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksum: %w", notFound(err))
+	}
+
+	return result.Metadata[ChecksumMetadata], nil
+}
+
+func (s *S3Storage) GetFileVersion(ctx context.Context, fileName, versionID string) (io.ReadCloser, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: aws.String(versionID),
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %s: %w", versionID, notFound(err))
+	}
+
+	return result.Body, nil
+}
+
+func (s *S3Storage) CurrentVersion(ctx context.Context, fileName string) (string, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(fileName),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to get current version: %w", notFound(err))
+	}
+
+	return aws.ToString(result.VersionId), nil
+}
+
+func (s *S3Storage) GetPresignedVersionURL(ctx context.Context, fileName, versionID string, opts PresignOptions) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: aws.String(versionID),
+	}
+	presignResponse(input, fileName, opts)
+
+	request, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, input, func(presign *s3.PresignOptions) {
+		presign.Expires = opts.Lifetime()
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to create presigned URL: %w", err)
+	}
+
+	return request.URL, nil
+}
+
+// presignResponse asks S3 to override the response headers opts call for.
+// The overrides are part of what is signed.
+func presignResponse(input *s3.GetObjectInput, fileName string, opts PresignOptions) {
+	if disposition := opts.Disposition(fileName); disposition != "" {
+		input.ResponseContentDisposition = aws.String(disposition)
+	}
+	if opts.ContentType != "" {
+		input.ResponseContentType = aws.String(opts.ContentType)
+	}
+}
+
+func (s *S3Storage) RestoreVersion(ctx context.Context, fileName, versionID string) (string, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get version %s: %w", versionID, notFound(err))
+	}
+
+	source := url.PathEscape(s.bucketName+"/"+fileName) + "?versionId=" + url.QueryEscape(versionID)
+	size := aws.ToInt64(head.ContentLength)
+	if size <= maxCopySize {
+		result, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucketName),
+			Key:        aws.String(fileName),
+			CopySource: aws.String(source),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to restore version %s: %w", versionID, notFound(err))
+		}
+
+		return aws.ToString(result.VersionId), nil
+	}
+
+	// Unlike CopyObject, a multipart copy has to be told to keep the metadata
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(fileName),
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	uploadID := aws.ToString(created.UploadId)
+
+	var parts []CompletedPart
+	for offset := int64(0); offset < size; offset += copyPartSize {
+		partNumber := int32(len(parts) + 1)
+		last := min(offset+copyPartSize, size) - 1
+		result, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucketName),
+			Key:             aws.String(fileName),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+		})
+		if err != nil {
+			if abortErr := s.AbortMultipartUpload(context.WithoutCancel(ctx), fileName, uploadID); abortErr != nil {
+				log.Printf("Failed to abort copy of %s: %v", fileName, abortErr)
+			}
+			return "", fmt.Errorf("failed to restore version %s: %w", versionID, err)
+		}
+
+		parts = append(parts, CompletedPart{
+			PartNumber: partNumber,
+			ETag:       aws.ToString(result.CopyPartResult.ETag),
+		})
+	}
+
+	if err := s.CompleteMultipartUpload(ctx, fileName, uploadID, parts); err != nil {
+		return "", err
+	}
+
+	return s.CurrentVersion(ctx, fileName)
+}
+
+func (s *S3Storage) DeleteVersion(ctx context.Context, fileName, versionID string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: aws.String(versionID),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to delete version %s: %w", versionID, err)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) DeleteAllVersions(ctx context.Context, fileName string) error {
+	// The prefix also matches longer keys, so only exact matches are deleted
+	paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(fileName),
+	})
+
+	var versionIDs []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list versions: %w", err)
+		}
+
+		for _, version := range page.Versions {
+			if aws.ToString(version.Key) == fileName {
+				versionIDs = append(versionIDs, aws.ToString(version.VersionId))
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.ToString(marker.Key) == fileName {
+				versionIDs = append(versionIDs, aws.ToString(marker.VersionId))
+			}
+		}
+	}
+
+	for _, versionID := range versionIDs {
+		if err := s.DeleteVersion(ctx, fileName, versionID); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Successfully deleted file: %s (%d versions)", fileName, len(versionIDs))
+	return nil
+}
+
+func (s *S3Storage) ObjectLockEnabled(ctx context.Context) (bool, error) {
+	result, err := s.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s.bucketName),
+	})
+
+	// Buckets created without Object Lock have no configuration at all
+	if errors.Is(notFound(err), ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get object lock configuration: %w", err)
+	}
+
+	config := result.ObjectLockConfiguration
+	return config != nil && config.ObjectLockEnabled == types.ObjectLockEnabledEnabled, nil
+}
+
+func (s *S3Storage) SetRetention(ctx context.Context, fileName, versionID string, until time.Time) error {
+	_, err := s.client.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: optionalString(versionID),
+		Retention: &types.ObjectLockRetention{
+			Mode:            s.lockMode,
+			RetainUntilDate: aws.Time(until),
+		},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set retention on %s: %w", fileName, notFound(err))
+	}
+
+	return nil
+}
+
+func (s *S3Storage) SetLegalHold(ctx context.Context, fileName, versionID string, on bool) error {
+	status := types.ObjectLockLegalHoldStatusOff
+	if on {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := s.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(s.bucketName),
+		Key:       aws.String(fileName),
+		VersionId: optionalString(versionID),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set legal hold on %s: %w", fileName, notFound(err))
+	}
+
+	return nil
+}
+
+// optionalString leaves an empty value out of a request
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+// notFound marks S3 404s, whether for a missing key or an unknown multipart
+// upload, as ErrNotFound
+func notFound(err error) error {
+	var responseErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
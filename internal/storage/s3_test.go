@@ -0,0 +1,41 @@
+package storage_test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/okoye-dev/oss-archive/internal/config"
+	"github.com/okoye-dev/oss-archive/internal/storage"
+	"github.com/okoye-dev/oss-archive/internal/storage/storagetest"
+)
+
+// TestS3Storage needs a real bucket, so it only runs with STORAGETEST_S3=1
+// and the usual S3_* variables; `make test-s3` runs it against the dev MinIO.
+// Everything it writes lives under storagetest/ and is removed afterwards.
+func TestS3Storage(t *testing.T) {
+	if os.Getenv("STORAGETEST_S3") != "1" {
+		t.Skip("set STORAGETEST_S3=1 and S3_* to run against a bucket")
+	}
+
+	useSSL, _ := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+	forcePathStyle, _ := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+	cfg := &config.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		UseSSL:          useSSL,
+		BucketName:      os.Getenv("S3_BUCKET_NAME"),
+		ForcePathStyle:  forcePathStyle,
+	}
+
+	s, err := storage.NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.StorageInterface {
+		return s
+	})
+}
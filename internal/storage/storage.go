@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Backends that can be chosen with the storage.backend setting
+const (
+	BackendS3    = "s3"
+	BackendLocal = "local"
+)
+
+// ErrNotFound is returned, possibly wrapped, when an object or multipart
+// upload does not exist
+var ErrNotFound = errors.New("not found")
+
+// StorageInterface is implemented by every backend. Each call stops early
+// once its context is cancelled, so a client hanging up or the server shutting
+// down doesn't leave work running against the bucket.
+type StorageInterface interface {
+	UploadFile(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) error
+	GetFile(ctx context.Context, fileName string) (io.ReadCloser, error)
+	DeleteFile(ctx context.Context, fileName string) error
+	// ListFiles returns every object whose key starts with prefix, in key order
+	ListFiles(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	GetFileSize(ctx context.Context, fileName string) (int64, error)
+	GetPresignedURL(ctx context.Context, fileName string, opts PresignOptions) (string, error)
+
+	// Multipart uploads let a large object be written in parts over many
+	// requests. Every part but the last must be at least MinPartSize.
+	CreateMultipartUpload(ctx context.Context, fileName, contentType string) (string, error)
+	UploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, reader io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(ctx context.Context, fileName, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, fileName, uploadID string) error
+	ListParts(ctx context.Context, fileName, uploadID string) ([]StoredPart, error)
+	PresignUploadPart(ctx context.Context, fileName, uploadID string, partNumber int32, expires time.Duration) (string, error)
+}
+
+// VersionedStorage is implemented by backends whose buckets can keep every
+// write to a key as a separate version. Callers check VersioningEnabled first
+// and fall back to a key per version when it is off.
+type VersionedStorage interface {
+	StorageInterface
+
+	VersioningEnabled(ctx context.Context) (bool, error)
+	// UploadFileVersion writes fileName and returns the id of the new version
+	UploadFileVersion(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType string) (string, error)
+	// CurrentVersion returns the id of the version a plain read of fileName gets
+	CurrentVersion(ctx context.Context, fileName string) (string, error)
+	GetFileVersion(ctx context.Context, fileName, versionID string) (io.ReadCloser, error)
+	GetPresignedVersionURL(ctx context.Context, fileName, versionID string, opts PresignOptions) (string, error)
+	// RestoreVersion copies an earlier version on top of fileName and returns
+	// the id of the copy
+	RestoreVersion(ctx context.Context, fileName, versionID string) (string, error)
+	DeleteVersion(ctx context.Context, fileName, versionID string) error
+	// DeleteAllVersions removes fileName for good, every version and delete
+	// marker included
+	DeleteAllVersions(ctx context.Context, fileName string) error
+}
+
+// ChecksumStorage is implemented by backends that can keep the SHA-256 of
+// an object's contents with it, as S3 object metadata does
+type ChecksumStorage interface {
+	StorageInterface
+
+	// UploadFileWithChecksum writes fileName along with checksum, the hex
+	// SHA-256 of what reader yields. It returns the id of the new version
+	// when the bucket keeps versions.
+	UploadFileWithChecksum(ctx context.Context, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) (string, error)
+	// Checksum returns the checksum stored with a version of fileName, or ""
+	// if it was written without one. An empty versionID is the current version.
+	Checksum(ctx context.Context, fileName, versionID string) (string, error)
+}
+
+// ChecksumMetadata is the object metadata entry checksums are kept under
+const ChecksumMetadata = "sha256"
+
+// LockingStorage is implemented by backends whose buckets can refuse to
+// delete or overwrite objects themselves, as S3 Object Lock does. Callers
+// check ObjectLockEnabled first; without it holds are only enforced by the
+// archive. An empty versionID is the current version of fileName.
+type LockingStorage interface {
+	StorageInterface
+
+	ObjectLockEnabled(ctx context.Context) (bool, error)
+	// SetRetention keeps a version from being deleted until the given time.
+	// Retention can be extended but not shortened.
+	SetRetention(ctx context.Context, fileName, versionID string, until time.Time) error
+	SetLegalHold(ctx context.Context, fileName, versionID string, on bool) error
+}
+
+// RangeStorage is implemented by backends that can read part of an object
+// without fetching what comes before it. Use GetFileRange, which falls back
+// to skipping ahead for the others.
+type RangeStorage interface {
+	StorageInterface
+
+	// GetFileRange reads length bytes of fileName starting at offset, or
+	// everything from offset on when length is negative. The range must lie
+	// within the object.
+	GetFileRange(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Presigned download URLs last DefaultPresignExpiry unless asked otherwise,
+// and no longer than MaxPresignExpiry, the most S3 signatures allow
+const (
+	DefaultPresignExpiry = time.Hour
+	MaxPresignExpiry     = 7 * 24 * time.Hour
+)
+
+// PresignOptions shape the response a presigned download URL gets
+type PresignOptions struct {
+	Download    bool          // make browsers save the object rather than show it
+	FileName    string        // name to save it under; recovered from the key when empty
+	ContentType string        // served instead of the stored content type when set
+	Expires     time.Duration // how long the URL stays valid; DefaultPresignExpiry when zero
+}
+
+// Lifetime is how long a URL signed with these options stays valid
+func (o PresignOptions) Lifetime() time.Duration {
+	if o.Expires > 0 {
+		return o.Expires
+	}
+	return DefaultPresignExpiry
+}
+
+// Disposition is the Content-Disposition a URL signed with these options
+// serves the object at key with, or "" to leave the stored one
+func (o PresignOptions) Disposition(key string) string {
+	switch {
+	case o.Download:
+		return AttachmentDisposition(o.Name(key))
+	case o.FileName != "":
+		return InlineDisposition(o.FileName)
+	}
+	return ""
+}
+
+// Name is what the object at key should be saved as
+func (o PresignOptions) Name(key string) string {
+	if o.FileName != "" {
+		return o.FileName
+	}
+	return DownloadName(key)
+}
+
+// MinPartSize is the smallest part S3 accepts other than the last one
+const MinPartSize = 5 * 1024 * 1024
+
+// MaxParts is the most parts a single multipart upload can have
+const MaxParts = 10000
+
+// ObjectInfo describes a stored object as returned by a listing
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// CompletedPart identifies a stored part when completing a multipart upload
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+}
+
+// StoredPart is a part the bucket holds for an unfinished multipart upload
+type StoredPart struct {
+	PartNumber int32
+	ETag       string
+	Size       int64
+}
+
+// contextReader stops a copy once ctx is cancelled, for backends whose
+// writes don't otherwise watch the context
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// Checksum reads reader to the end and returns the hex SHA-256 of what it
+// yielded along with how many bytes that was
+func Checksum(reader io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// UploadFileChecksummed writes fileName, keeping checksum with it when the
+// backend can. Like UploadFileVersion it returns the id of the new version
+// when the bucket keeps versions.
+func UploadFileChecksummed(ctx context.Context, s StorageInterface, fileName string, reader io.Reader, fileSize int64, contentType, checksum string) (string, error) {
+	if checksums, ok := s.(ChecksumStorage); ok {
+		return checksums.UploadFileWithChecksum(ctx, fileName, reader, fileSize, contentType, checksum)
+	}
+	if versioned, ok := s.(VersionedStorage); ok {
+		return versioned.UploadFileVersion(ctx, fileName, reader, fileSize, contentType)
+	}
+	return "", s.UploadFile(ctx, fileName, reader, fileSize, contentType)
+}
+
+// PresignUploadsSupported reports whether clients can be handed URLs to
+// upload parts to. Backends that have to see every byte, as EncryptedStorage
+// does, say so by implementing PresignsUploads.
+func PresignUploadsSupported(s StorageInterface) bool {
+	probe, ok := s.(interface{ PresignsUploads() bool })
+	return !ok || probe.PresignsUploads()
+}
+
+// Unwrap returns the backend objects end up in when s is layered over
+// another one, as EncryptedStorage is
+func Unwrap(s StorageInterface) StorageInterface {
+	for {
+		wrapper, ok := s.(interface{ Unwrap() StorageInterface })
+		if !ok {
+			return s
+		}
+		s = wrapper.Unwrap()
+	}
+}
+
+// UserKey namespaces objects under their owner so one user's keys
+// can never collide with or be guessed from another's
+func UserKey(ownerID, fileID, fileName string) string {
+	return fmt.Sprintf("users/%s/%s/%s", ownerID, fileID, fileName)
+}
+
+// BlobKey is where content with the given hex SHA-256 is stored when it is
+// kept once for every file that has it
+func BlobKey(checksum string) string {
+	return fmt.Sprintf("blobs/%s/%s", checksum[:2], checksum)
+}
+
+// DownloadName recovers the original filename from a storage key.
+// Namespaced keys end in the original name: users/<owner>/<id>/<name>
+func DownloadName(key string) string {
+	originalFilename := path.Base(key)
+	if !strings.Contains(key, "/") && strings.Contains(key, "_") {
+		// Remove UUID prefix from legacy flat keys to get original filename
+		parts := strings.SplitN(key, "_", 2)
+		if len(parts) == 2 {
+			originalFilename = parts[1]
+		}
+	}
+	return originalFilename
+}
@@ -0,0 +1,596 @@
+// Package storagetest is a conformance suite for storage backends. Every
+// StorageInterface implementation should pass Run, so the rest of the code
+// can rely on the same behaviour whichever backend is configured.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okoye-dev/oss-archive/internal/storage"
+)
+
+// Factory returns the backend under test. It is called once per subtest;
+// backends may be shared between calls since every subtest uses its own keys.
+type Factory func(t *testing.T) storage.StorageInterface
+
+// Run checks the behaviour every backend must share
+func Run(t *testing.T, newStorage Factory) {
+	prefix := fmt.Sprintf("storagetest/%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.StorageInterface, key string)
+	}{
+		{"UploadAndGet", testUploadAndGet},
+		{"Overwrite", testOverwrite},
+		{"EmptyObject", testEmptyObject},
+		{"Size", testSize},
+		{"MissingObject", testMissingObject},
+		{"List", testList},
+		{"ListManyKeys", testListManyKeys},
+		{"Delete", testDelete},
+		{"PresignedURL", testPresignedURL},
+		{"Multipart", testMultipart},
+		{"MultipartPartTooSmall", testMultipartPartTooSmall},
+		{"MultipartWrongETag", testMultipartWrongETag},
+		{"MultipartAbort", testMultipartAbort},
+		{"Range", testRange},
+		{"Cancelled", testCancelled},
+		{"Versions", testVersions},
+		{"Checksum", testChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage(t)
+			// Spaces and non-ASCII characters are common in uploaded names
+			key := fmt.Sprintf("%s/%s/users/owner/id/report %s é.txt", prefix, tt.name, tt.name)
+			t.Cleanup(func() {
+				if err := s.DeleteFile(context.Background(), key); err != nil {
+					t.Errorf("cleanup: DeleteFile(%q): %v", key, err)
+				}
+			})
+			tt.test(t, s, key)
+		})
+	}
+}
+
+func testUploadAndGet(t *testing.T, s storage.StorageInterface, key string) {
+	data := randomBytes(t, 1024)
+	upload(t, s, key, data)
+
+	if got := download(t, s, key); !bytes.Equal(got, data) {
+		t.Fatalf("GetFile returned %d bytes that differ from the %d uploaded", len(got), len(data))
+	}
+}
+
+func testOverwrite(t *testing.T, s storage.StorageInterface, key string) {
+	upload(t, s, key, []byte("first version, which is longer"))
+	upload(t, s, key, []byte("second"))
+
+	if got := download(t, s, key); string(got) != "second" {
+		t.Fatalf("GetFile after overwrite = %q, want %q", got, "second")
+	}
+}
+
+func testEmptyObject(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	upload(t, s, key, nil)
+
+	if got := download(t, s, key); len(got) != 0 {
+		t.Fatalf("GetFile returned %d bytes for an empty object", len(got))
+	}
+	if size, err := s.GetFileSize(ctx, key); err != nil || size != 0 {
+		t.Fatalf("GetFileSize = %d, %v; want 0, nil", size, err)
+	}
+}
+
+func testSize(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	upload(t, s, key, randomBytes(t, 4321))
+
+	size, err := s.GetFileSize(ctx, key)
+	if err != nil {
+		t.Fatalf("GetFileSize: %v", err)
+	}
+	if size != 4321 {
+		t.Fatalf("GetFileSize = %d, want 4321", size)
+	}
+}
+
+func testMissingObject(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	if _, err := s.GetFileSize(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetFileSize of a missing key: got %v, want ErrNotFound", err)
+	}
+
+	file, err := s.GetFile(ctx, key)
+	if err == nil {
+		// Some backends only find out once the body is read
+		_, err = io.ReadAll(file)
+		file.Close()
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetFile of a missing key: got %v, want ErrNotFound", err)
+	}
+}
+
+func testList(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	other := key + ".other"
+	t.Cleanup(func() { s.DeleteFile(context.Background(), other) })
+
+	before := time.Now()
+	upload(t, s, key, []byte("listed"))
+	upload(t, s, other, []byte("also listed"))
+
+	files, err := s.ListFiles(ctx, path.Dir(key)+"/")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got, want := objectKeys(files), []string{key, other}; !slices.Equal(got, want) {
+		t.Fatalf("ListFiles = %q, want %q", got, want)
+	}
+	for i, size := range []int64{6, 11} {
+		if files[i].Size != size {
+			t.Errorf("ListFiles size of %q = %d, want %d", files[i].Key, files[i].Size, size)
+		}
+		// Allow for a bucket whose clock is a little off ours
+		if files[i].LastModified.Before(before.Add(-time.Minute)) || files[i].LastModified.After(time.Now().Add(time.Minute)) {
+			t.Errorf("ListFiles last modified of %q = %v, want around %v", files[i].Key, files[i].LastModified, before)
+		}
+	}
+
+	// Prefixes needn't end at a "/"
+	files, err = s.ListFiles(ctx, key+".o")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got, want := objectKeys(files), []string{other}; !slices.Equal(got, want) {
+		t.Errorf("ListFiles with a partial name = %q, want %q", got, want)
+	}
+
+	if err := s.DeleteFile(ctx, other); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	files, err = s.ListFiles(ctx, path.Dir(key)+"/")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got, want := objectKeys(files), []string{key}; !slices.Equal(got, want) {
+		t.Errorf("ListFiles after delete = %q, want %q", got, want)
+	}
+
+	files, err = s.ListFiles(ctx, key+"/nothing/here")
+	if err != nil {
+		t.Fatalf("ListFiles of an unused prefix: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("ListFiles of an unused prefix = %q, want nothing", objectKeys(files))
+	}
+}
+
+// testListManyKeys goes past the 1000 keys S3 returns per request
+func testListManyKeys(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	dir := path.Dir(key) + "/many/"
+
+	const count = 1001
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%04d", dir, i)
+	}
+	t.Cleanup(func() {
+		for _, k := range keys {
+			s.DeleteFile(context.Background(), k)
+		}
+	})
+	for _, k := range keys {
+		upload(t, s, k, []byte("x"))
+	}
+
+	files, err := s.ListFiles(ctx, dir)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got := objectKeys(files); !slices.Equal(got, keys) {
+		t.Fatalf("ListFiles returned %d keys, want all %d in order", len(got), count)
+	}
+}
+
+func testDelete(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	upload(t, s, key, []byte("short lived"))
+
+	if err := s.DeleteFile(ctx, key); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, err := s.GetFileSize(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetFileSize after delete: got %v, want ErrNotFound", err)
+	}
+
+	// Deleting is idempotent, as it is in S3
+	if err := s.DeleteFile(ctx, key); err != nil {
+		t.Fatalf("DeleteFile of a missing key: %v", err)
+	}
+}
+
+func testPresignedURL(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	upload(t, s, key, []byte("presigned"))
+
+	inline, err := s.GetPresignedURL(ctx, key, storage.PresignOptions{})
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		t.Skip("backend can't presign this object")
+	}
+	if err != nil {
+		t.Fatalf("GetPresignedURL: %v", err)
+	}
+	attachment, err := s.GetPresignedURL(ctx, key, storage.PresignOptions{Download: true})
+	if err != nil {
+		t.Fatalf("GetPresignedURL with Download: %v", err)
+	}
+
+	for _, raw := range []string{inline, attachment} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("presigned URL %q does not parse: %v", raw, err)
+		}
+		if !strings.HasSuffix(u.Path, "/"+key) {
+			t.Errorf("presigned URL path %q does not end in the key %q", u.Path, key)
+		}
+	}
+	if inline == attachment {
+		t.Errorf("Download did not change the presigned URL")
+	}
+}
+
+func testMultipart(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	uploadID := createMultipart(t, s, key)
+
+	first := randomBytes(t, storage.MinPartSize)
+	last := randomBytes(t, 1000)
+
+	// Parts may arrive in any order
+	lastETag := uploadPart(t, s, key, uploadID, 2, last)
+	firstETag := uploadPart(t, s, key, uploadID, 1, first)
+
+	parts, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		t.Fatalf("ListParts: %v", err)
+	}
+	want := []storage.StoredPart{
+		{PartNumber: 1, ETag: firstETag, Size: int64(len(first))},
+		{PartNumber: 2, ETag: lastETag, Size: int64(len(last))},
+	}
+	if !slices.Equal(parts, want) {
+		t.Fatalf("ListParts = %+v, want %+v", parts, want)
+	}
+
+	partURL, err := s.PresignUploadPart(ctx, key, uploadID, 3, time.Minute)
+	if err != nil && !errors.Is(err, storage.ErrPresignUnsupported) {
+		t.Fatalf("PresignUploadPart: %v", err)
+	}
+	if _, err := url.Parse(partURL); err != nil {
+		t.Fatalf("presigned part URL %q does not parse: %v", partURL, err)
+	}
+
+	err = s.CompleteMultipartUpload(ctx, key, uploadID, []storage.CompletedPart{
+		{PartNumber: 1, ETag: firstETag},
+		{PartNumber: 2, ETag: lastETag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+
+	if got := download(t, s, key); !bytes.Equal(got, append(first, last...)) {
+		t.Fatalf("assembled object differs from the uploaded parts")
+	}
+	if _, err := s.ListParts(ctx, key, uploadID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ListParts after complete: got %v, want ErrNotFound", err)
+	}
+}
+
+func testMultipartPartTooSmall(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	uploadID := createMultipart(t, s, key)
+	t.Cleanup(func() { s.AbortMultipartUpload(context.Background(), key, uploadID) })
+
+	first := uploadPart(t, s, key, uploadID, 1, []byte("too small to be followed"))
+
+	// Some backends turn the second part away before the upload is completed
+	data := []byte("by another part")
+	second, err := s.UploadPart(ctx, key, uploadID, 2, bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		err = s.CompleteMultipartUpload(ctx, key, uploadID, []storage.CompletedPart{
+			{PartNumber: 1, ETag: first},
+			{PartNumber: 2, ETag: second},
+		})
+	}
+	if err == nil {
+		t.Fatalf("CompleteMultipartUpload accepted a part below MinPartSize that is not the last")
+	}
+	if _, err := s.GetFileSize(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("a failed complete left an object behind: %v", err)
+	}
+}
+
+func testMultipartWrongETag(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	uploadID := createMultipart(t, s, key)
+	t.Cleanup(func() { s.AbortMultipartUpload(context.Background(), key, uploadID) })
+
+	uploadPart(t, s, key, uploadID, 1, []byte("the only part"))
+
+	err := s.CompleteMultipartUpload(ctx, key, uploadID, []storage.CompletedPart{
+		{PartNumber: 1, ETag: `"00000000000000000000000000000000"`},
+	})
+	if err == nil {
+		t.Fatalf("CompleteMultipartUpload accepted an ETag that does not match the part")
+	}
+	if _, err := s.GetFileSize(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("a failed complete left an object behind: %v", err)
+	}
+}
+
+func testMultipartAbort(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	uploadID := createMultipart(t, s, key)
+	uploadPart(t, s, key, uploadID, 1, []byte("discarded"))
+
+	if err := s.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload: %v", err)
+	}
+	if _, err := s.ListParts(ctx, key, uploadID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ListParts after abort: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetFileSize(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("an aborted upload left an object behind: %v", err)
+	}
+}
+
+// testRange reads parts of an object through GetFileRange, which every
+// backend supports whether or not it implements RangeStorage
+func testRange(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	data := randomBytes(t, 200000)
+	upload(t, s, key, data)
+
+	ranges := []struct{ offset, length int64 }{
+		{0, 10},
+		{65530, 20}, // across a 64 KiB boundary
+		{150000, -1},
+		{int64(len(data)) - 1, 1},
+		{0, -1},
+		{1000, 0},
+	}
+	for _, r := range ranges {
+		body, err := storage.GetFileRange(ctx, s, key, r.offset, r.length)
+		if err != nil {
+			t.Fatalf("GetFileRange(%d, %d): %v", r.offset, r.length, err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatalf("reading range %d, %d: %v", r.offset, r.length, err)
+		}
+
+		end := int64(len(data))
+		if r.length >= 0 {
+			end = r.offset + r.length
+		}
+		if !bytes.Equal(got, data[r.offset:end]) {
+			t.Errorf("GetFileRange(%d, %d) returned %d bytes that differ from the object", r.offset, r.length, len(got))
+		}
+	}
+
+	// ObjectReader fetches from wherever it is sought to
+	reader := storage.OpenObject(ctx, s, key, int64(len(data)))
+	defer reader.Close()
+	part := make([]byte, 100)
+	for _, offset := range []int64{100000, 5, 199900} {
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d): %v", offset, err)
+		}
+		if _, err := io.ReadFull(reader, part); err != nil {
+			t.Fatalf("reading at %d: %v", offset, err)
+		}
+		if !bytes.Equal(part, data[offset:offset+100]) {
+			t.Errorf("ObjectReader at %d returned bytes that differ from the object", offset)
+		}
+	}
+	if end, err := reader.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+		t.Errorf("Seek to the end = %d, %v; want %d", end, err, len(data))
+	}
+}
+
+func testCancelled(t *testing.T, s storage.StorageInterface, key string) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	data := []byte("never stored")
+	if err := s.UploadFile(ctx, key, bytes.NewReader(data), int64(len(data)), "text/plain"); err == nil {
+		t.Fatalf("UploadFile succeeded with a cancelled context")
+	}
+	if _, err := s.GetFileSize(t.Context(), key); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("a cancelled upload left an object behind: %v", err)
+	}
+}
+
+func objectKeys(files []storage.ObjectInfo) []string {
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.Key)
+	}
+	return keys
+}
+
+func upload(t *testing.T, s storage.StorageInterface, key string, data []byte) {
+	t.Helper()
+	ctx := t.Context()
+	if err := s.UploadFile(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+		t.Fatalf("UploadFile(%q): %v", key, err)
+	}
+}
+
+func download(t *testing.T, s storage.StorageInterface, key string) []byte {
+	t.Helper()
+	ctx := t.Context()
+	file, err := s.GetFile(ctx, key)
+	if err != nil {
+		t.Fatalf("GetFile(%q): %v", key, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading %q: %v", key, err)
+	}
+	return data
+}
+
+func createMultipart(t *testing.T, s storage.StorageInterface, key string) string {
+	t.Helper()
+	ctx := t.Context()
+	uploadID, err := s.CreateMultipartUpload(ctx, key, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	if uploadID == "" {
+		t.Fatalf("CreateMultipartUpload returned an empty upload ID")
+	}
+	return uploadID
+}
+
+func uploadPart(t *testing.T, s storage.StorageInterface, key, uploadID string, number int32, data []byte) string {
+	t.Helper()
+	ctx := t.Context()
+	etag, err := s.UploadPart(ctx, key, uploadID, number, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("UploadPart %d: %v", number, err)
+	}
+	if etag == "" {
+		t.Fatalf("UploadPart %d returned an empty ETag", number)
+	}
+	return etag
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("generating test data: %v", err)
+	}
+	return data
+}
+
+// testVersions only runs against backends that keep versions themselves
+func testVersions(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	versioned, ok := s.(storage.VersionedStorage)
+	if !ok {
+		t.Skip("backend does not keep versions")
+	}
+	enabled, err := versioned.VersioningEnabled(ctx)
+	if err != nil {
+		t.Fatalf("VersioningEnabled: %v", err)
+	}
+	if !enabled {
+		t.Skip("versioning is not enabled on the bucket")
+	}
+	t.Cleanup(func() {
+		if err := versioned.DeleteAllVersions(context.Background(), key); err != nil {
+			t.Errorf("cleanup: DeleteAllVersions(%q): %v", key, err)
+		}
+	})
+
+	first, err := versioned.UploadFileVersion(ctx, key, strings.NewReader("first"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("UploadFileVersion: %v", err)
+	}
+	second, err := versioned.UploadFileVersion(ctx, key, strings.NewReader("second"), 6, "text/plain")
+	if err != nil {
+		t.Fatalf("UploadFileVersion: %v", err)
+	}
+	if first == "" || first == second {
+		t.Fatalf("version ids %q and %q should be distinct and non-empty", first, second)
+	}
+	if current, err := versioned.CurrentVersion(ctx, key); err != nil || current != second {
+		t.Fatalf("CurrentVersion = %q, %v; want %q", current, err, second)
+	}
+
+	if _, err := versioned.GetPresignedVersionURL(ctx, key, first, storage.PresignOptions{Download: true}); err != nil {
+		t.Fatalf("GetPresignedVersionURL: %v", err)
+	}
+
+	restored, err := versioned.RestoreVersion(ctx, key, first)
+	if err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if restored == first || restored == second {
+		t.Errorf("restoring should create a new version, got %q", restored)
+	}
+	if got := download(t, s, key); string(got) != "first" {
+		t.Errorf("GetFile after restore = %q, want %q", got, "first")
+	}
+
+	if err := versioned.DeleteVersion(ctx, key, restored); err != nil {
+		t.Fatalf("DeleteVersion: %v", err)
+	}
+	if got := download(t, s, key); string(got) != "second" {
+		t.Errorf("GetFile after deleting the newest version = %q, want %q", got, "second")
+	}
+
+	if err := versioned.DeleteAllVersions(ctx, key); err != nil {
+		t.Fatalf("DeleteAllVersions: %v", err)
+	}
+	if _, err := s.GetFile(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetFile after DeleteAllVersions: got %v, want ErrNotFound", err)
+	}
+}
+
+func testChecksum(t *testing.T, s storage.StorageInterface, key string) {
+	ctx := t.Context()
+	checksums, ok := s.(storage.ChecksumStorage)
+	if !ok {
+		t.Skip("backend does not keep checksums")
+	}
+
+	data := randomBytes(t, 1024)
+	checksum, n, err := storage.Checksum(bytes.NewReader(data))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Checksum = %d bytes, %v", n, err)
+	}
+	if _, err := checksums.UploadFileWithChecksum(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream", checksum); err != nil {
+		t.Fatalf("UploadFileWithChecksum: %v", err)
+	}
+	if got, err := checksums.Checksum(ctx, key, ""); err != nil || got != checksum {
+		t.Errorf("Checksum = %q, %v; want %q", got, err, checksum)
+	}
+	if !bytes.Equal(download(t, s, key), data) {
+		t.Error("content differs from what was uploaded")
+	}
+
+	// A plain upload replaces the object and its checksum
+	upload(t, s, key, []byte("plain"))
+	if got, err := checksums.Checksum(ctx, key, ""); err != nil || got != "" {
+		t.Errorf("Checksum after a plain upload = %q, %v; want none", got, err)
+	}
+
+	missing := key + ".missing"
+	if _, err := checksums.Checksum(ctx, missing, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Checksum of a missing object: got %v, want ErrNotFound", err)
+	}
+}
@@ -3,4 +3,80 @@ package rest
 // Request DTOs (Data Transfer Objects) for API endpoints
 
 type HealthRequest struct {
-}
\ No newline at end of file
+}
+
+type SignupRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=64"`
+	Password string `json:"password" binding:"required,min=8"` // at most auth.MaxPasswordLength bytes
+}
+
+type SigninRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type GrantRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// CreateShareRequest sets the optional limits on a new share link
+type CreateShareRequest struct {
+	ExpiresIn    *int   `json:"expires_in" binding:"omitempty,gt=0"` // in seconds
+	Password     string `json:"password"` // at most auth.MaxPasswordLength bytes
+	MaxDownloads *int   `json:"max_downloads" binding:"omitempty,gt=0"`
+}
+
+// DirectUploadRequest starts an upload that goes straight to the bucket
+type DirectUploadRequest struct {
+	FileName    string `json:"file_name" binding:"required,max=255"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size" binding:"min=0"`
+}
+
+// PresignPartsRequest asks for upload URLs for some of an upload's parts
+type PresignPartsRequest struct {
+	PartNumbers []int32 `json:"part_numbers" binding:"required,min=1,max=1000,dive,min=1"`
+}
+
+// CompleteUploadRequest lists every part with the ETag the bucket returned for it
+type CompleteUploadRequest struct {
+	Parts []CompletedPartRequest `json:"parts" binding:"required,min=1,dive"`
+}
+
+type CompletedPartRequest struct {
+	PartNumber int32  `json:"part_number" binding:"min=1"`
+	ETag       string `json:"etag" binding:"required"`
+}
+
+// CreateFolderRequest adds a folder; an empty parent_id means the root
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID string `json:"parent_id"`
+}
+
+// UpdateFolderRequest renames and/or moves a folder. Fields left out are
+// unchanged; a parent_id of "" moves the folder to the root.
+type UpdateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+// MoveFileRequest puts a file in a folder; "" is the root
+type MoveFileRequest struct {
+	FolderID *string `json:"folder_id" binding:"required"`
+}
+
+// HoldRequest changes a file or folder's hold. Fields left out are
+// unchanged; retain_days keeps it until that many days from now.
+type HoldRequest struct {
+	RetainDays *int  `json:"retain_days" binding:"omitempty,gt=0,lte=36500"`
+	LegalHold  *bool `json:"legal_hold"`
+}
@@ -2,8 +2,10 @@ package rest
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/okoye-dev/oss-archive/internal/models"
 )
 
 type HealthResponse struct {
@@ -12,6 +14,37 @@ type HealthResponse struct {
 	Service   string `json:"service"`
 }
 
+// UserResponse is the public view of an account. It is the only shape a
+// user is ever returned in, so credentials can't reach a response body.
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewUserResponse(user *models.User) UserResponse {
+	return UserResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      string(user.Role),
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // in seconds
+}
+
+type AuthResponse struct {
+	User UserResponse `json:"user"`
+	TokenResponse
+	Message string `json:"message"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Code    int    `json:"code"`
@@ -48,4 +81,28 @@ func BadRequest(c *gin.Context, message string) {
 
 func NotFound(c *gin.Context, message string) {
 	Error(c, http.StatusNotFound, message)
+}
+
+func Unauthorized(c *gin.Context, message string) {
+	Error(c, http.StatusUnauthorized, message)
+}
+
+func Conflict(c *gin.Context, message string) {
+	Error(c, http.StatusConflict, message)
+}
+
+func Forbidden(c *gin.Context, message string) {
+	Error(c, http.StatusForbidden, message)
+}
+
+func Gone(c *gin.Context, message string) {
+	Error(c, http.StatusGone, message)
+}
+
+func TooManyRequests(c *gin.Context, message string) {
+	Error(c, http.StatusTooManyRequests, message)
+}
+
+func NotImplemented(c *gin.Context, message string) {
+	Error(c, http.StatusNotImplemented, message)
 }
\ No newline at end of file